@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Load balancing policies, chosen with LB_POLICY.
+const (
+	roundRobin = "round_robin"
+	weighted   = "weighted"
+)
+
+// Passive health checking: an instance that fails ejectAfter requests in a
+// row, with a 5xx or a connection error, gets no requests for ejectFor.
+const (
+	ejectAfter = 3
+	ejectFor   = 30 * time.Second
+)
+
+// instance is one healthy instance of a service as Consul last listed it.
+type instance struct {
+	id     string
+	target *url.URL
+	weight int
+
+	// Guarded by the pool's mutex.
+	failures     int // in a row
+	ejectedUntil time.Time
+	current      int // smooth weighted round-robin state
+}
+
+// pool holds the healthy instances of one service and picks between them.
+type pool struct {
+	service string
+	policy  string
+
+	mu        sync.Mutex
+	instances []*instance
+	next      int
+}
+
+// set replaces the instances with those Consul reports, keeping the failure
+// counts of instances that are still listed at the same address.
+func (p *pool) set(entries []*consulapi.ServiceEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known := make(map[string]*instance, len(p.instances))
+	for _, inst := range p.instances {
+		known[inst.id] = inst
+	}
+	instances := make([]*instance, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		target := &url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", host, entry.Service.Port)}
+		weight := entry.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		if inst, ok := known[entry.Service.ID]; ok && inst.target.Host == target.Host {
+			inst.weight = weight
+			instances = append(instances, inst)
+			continue
+		}
+		instances = append(instances, &instance{id: entry.Service.ID, target: target, weight: weight})
+	}
+	p.instances = instances
+	log.Printf("%s has %d healthy instances", p.service, len(instances))
+}
+
+// pick chooses the instance for the next request. Ejected instances are
+// passed over unless every instance is ejected, in which case they all take
+// requests again rather than the service being cut off.
+func (p *pool) pick(now time.Time) (*instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.instances) == 0 {
+		return nil, fmt.Errorf("no healthy instances of %s", p.service)
+	}
+	candidates := make([]*instance, 0, len(p.instances))
+	for _, inst := range p.instances {
+		if now.After(inst.ejectedUntil) {
+			candidates = append(candidates, inst)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.instances
+	}
+
+	if p.policy == weighted {
+		// Smooth weighted round-robin: each instance gains its weight per
+		// pick and the one with the most pays back the total, which spreads
+		// heavier instances' turns out instead of bunching them.
+		var best *instance
+		total := 0
+		for _, inst := range candidates {
+			inst.current += inst.weight
+			total += inst.weight
+			if best == nil || inst.current > best.current {
+				best = inst
+			}
+		}
+		best.current -= total
+		return best, nil
+	}
+	inst := candidates[p.next%len(candidates)]
+	p.next++
+	return inst, nil
+}
+
+// report records how a request to inst went.
+func (p *pool) report(inst *instance, ok bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ok {
+		inst.failures = 0
+		return
+	}
+	inst.failures++
+	if inst.failures >= ejectAfter {
+		inst.failures = 0
+		inst.ejectedUntil = now.Add(ejectFor)
+		log.Printf("Ejecting %s instance %s at %s for %v", p.service, inst.id, inst.target.Host, ejectFor)
+	}
+}
+
+// watch keeps p in step with the passing instances of its service using
+// Consul blocking queries. When Consul cannot be reached the last instances
+// stay in use and the query is retried with backoff.
+func (p *pool) watch(ctx context.Context, consul *consulapi.Client) {
+	var index uint64
+	retry := time.Second
+	for {
+		q := (&consulapi.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+		entries, meta, err := consul.Health().Service(p.service, "", true, q)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Discovering %s failed, retrying in %v: %v", p.service, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry = min(2*retry, 30*time.Second)
+			continue
+		}
+		retry = time.Second
+
+		// An index that goes backwards means Consul's state was reset; start
+		// over rather than wait for an index that will not come. Starting
+		// over is from 1, as a wait index of 0 does not block at all.
+		if meta.LastIndex < index || meta.LastIndex == 0 {
+			index = 1
+		} else if meta.LastIndex == index {
+			continue // the wait timed out with nothing new
+		} else {
+			index = meta.LastIndex
+		}
+		p.set(entries)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// t0 is the fixed time the pool tests start at.
+var t0 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// entry is a Consul health entry for a service instance.
+func entry(id, addr string, port, weight int) *consulapi.ServiceEntry {
+	return &consulapi.ServiceEntry{
+		Node: &consulapi.Node{Address: "10.0.0.1"},
+		Service: &consulapi.AgentService{
+			ID:      id,
+			Service: "menu-service",
+			Address: addr,
+			Port:    port,
+			Weights: consulapi.AgentWeights{Passing: weight},
+		},
+	}
+}
+
+// newTestPool returns a pool of instances a, b, c, ... with the given
+// weights.
+func newTestPool(policy string, weights ...int) *pool {
+	p := &pool{service: "menu-service", policy: policy}
+	entries := make([]*consulapi.ServiceEntry, len(weights))
+	for i, w := range weights {
+		entries[i] = entry(string(rune('a'+i)), "10.0.1."+strconv.Itoa(i+1), 8080, w)
+	}
+	p.set(entries)
+	return p
+}
+
+func (p *pool) find(id string) *instance {
+	for _, inst := range p.instances {
+		if inst.id == id {
+			return inst
+		}
+	}
+	return nil
+}
+
+// picks picks n instances at now and returns their IDs.
+func picks(t *testing.T, p *pool, n int, now time.Time) string {
+	t.Helper()
+	var ids strings.Builder
+	for range n {
+		inst, err := p.pick(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids.WriteString(inst.id)
+	}
+	return ids.String()
+}
+
+func TestPoolSet(t *testing.T) {
+	p := &pool{service: "menu-service", policy: roundRobin}
+	p.set([]*consulapi.ServiceEntry{
+		entry("a", "10.0.1.1", 8080, 3),
+		entry("b", "", 8081, 0), // no address of its own, no weight
+	})
+	a, b := p.find("a"), p.find("b")
+	if a.target.String() != "http://10.0.1.1:8080" || a.weight != 3 {
+		t.Errorf("a = %s weight %d", a.target, a.weight)
+	}
+	if b.target.String() != "http://10.0.0.1:8081" || b.weight != 1 {
+		t.Errorf("b = %s weight %d, want the node address and weight 1", b.target, b.weight)
+	}
+
+	// Consul lists a again at the same address with a new weight, moves b
+	// and drops nothing else: a keeps its failure count, b starts afresh.
+	p.report(a, false, t0)
+	p.report(b, false, t0)
+	p.set([]*consulapi.ServiceEntry{
+		entry("a", "10.0.1.1", 8080, 5),
+		entry("b", "10.0.1.2", 8081, 1),
+		entry("c", "10.0.1.3", 8082, 1),
+	})
+	if got := p.find("a"); got != a || got.weight != 5 || got.failures != 1 {
+		t.Errorf("a after update = %+v, want the same instance with weight 5 and 1 failure", got)
+	}
+	if got := p.find("b"); got == b || got.failures != 0 {
+		t.Errorf("b after moving = %+v, want a new instance", got)
+	}
+
+	p.set(nil)
+	if _, err := p.pick(t0); err == nil || err.Error() != "no healthy instances of menu-service" {
+		t.Errorf("pick() from an empty pool: err = %v", err)
+	}
+}
+
+func TestPoolPick(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		weights []int
+		n       int
+		want    string
+	}{
+		{name: "round robin", policy: roundRobin, weights: []int{1, 1, 1}, n: 7, want: "abcabca"},
+		{name: "round robin ignores weights", policy: roundRobin, weights: []int{5, 1}, n: 4, want: "abab"},
+		{name: "weighted", policy: weighted, weights: []int{5, 1, 1}, n: 14, want: "aabacaaaabacaa"},
+		{name: "weighted evenly", policy: weighted, weights: []int{1, 1}, n: 4, want: "abab"},
+		{name: "weighted two to one", policy: weighted, weights: []int{2, 1}, n: 6, want: "abaaba"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool(tt.policy, tt.weights...)
+			if got := picks(t, p, tt.n, t0); got != tt.want {
+				t.Errorf("picks = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolEjection(t *testing.T) {
+	// report is a request to an instance finishing, at an offset from t0.
+	type report struct {
+		id string
+		ok bool
+		at time.Duration
+	}
+	fail := func(id string, at time.Duration) report { return report{id, false, at} }
+	succeed := func(id string, at time.Duration) report { return report{id, true, at} }
+
+	tests := []struct {
+		name    string
+		policy  string
+		weights []int
+		reports []report
+		at      time.Duration // when to pick
+		want    string
+	}{
+		{
+			name:    "two failures do not eject",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0)},
+			at:      time.Second,
+			want:    "abab",
+		},
+		{
+			name:    "three failures eject",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0)},
+			at:      time.Second,
+			want:    "bbbb",
+		},
+		{
+			name:    "a success resets the count",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), succeed("a", 0), fail("a", 0), fail("a", 0)},
+			at:      time.Second,
+			want:    "abab",
+		},
+		{
+			name:    "still ejected at the end of the window",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0)},
+			at:      ejectFor,
+			want:    "bbbb",
+		},
+		{
+			name:    "readmitted after the window",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0)},
+			at:      ejectFor + time.Nanosecond,
+			want:    "abab",
+		},
+		{
+			name:    "the window runs from the third failure",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 10*time.Second), fail("a", 20*time.Second)},
+			at:      40 * time.Second,
+			want:    "bbbb",
+		},
+		{
+			name:    "all ejected take requests again",
+			policy:  roundRobin,
+			weights: []int{1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0), fail("b", 0), fail("b", 0), fail("b", 0)},
+			at:      time.Second,
+			want:    "abab",
+		},
+		{
+			name:    "weighted passes over the ejected",
+			policy:  weighted,
+			weights: []int{5, 1, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0)},
+			at:      time.Second,
+			want:    "bcbc",
+		},
+		{
+			name:    "weighted all ejected",
+			policy:  weighted,
+			weights: []int{2, 1},
+			reports: []report{fail("a", 0), fail("a", 0), fail("a", 0), fail("b", 0), fail("b", 0), fail("b", 0)},
+			at:      time.Second,
+			want:    "abaaba",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool(tt.policy, tt.weights...)
+			for _, r := range tt.reports {
+				p.report(p.find(r.id), r.ok, t0.Add(r.at))
+			}
+			if got := picks(t, p, len(tt.want), t0.Add(tt.at)); got != tt.want {
+				t.Errorf("picks = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatchStartsOverWhenIndexGoesBack runs watch against a fake Consul
+// whose index goes back, as it does when Consul loses its state.
+func TestWatchStartsOverWhenIndexGoesBack(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		index   uint64 = 50
+		port           = 8080
+		changed        = make(chan struct{})
+		waits   []uint64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		mu.Lock()
+		waits = append(waits, wait)
+		for wait != 0 && wait == index {
+			ch := changed
+			mu.Unlock()
+			select {
+			case <-ch:
+			case <-r.Context().Done():
+				return
+			}
+			mu.Lock()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		json.NewEncoder(w).Encode([]*consulapi.ServiceEntry{entry("a", "10.0.1.1", port, 1)})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	consul, err := consulapi.NewClient(&consulapi.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pool{service: "menu-service", policy: roundRobin}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.watch(ctx, consul)
+
+	target := func() string {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if len(p.instances) == 0 {
+			return ""
+		}
+		return p.instances[0].target.Host
+	}
+	eventually(t, func() bool { return target() == "10.0.1.1:8080" })
+
+	mu.Lock()
+	index, port = 3, 8081
+	close(changed)
+	changed = make(chan struct{})
+	mu.Unlock()
+	eventually(t, func() bool { return target() == "10.0.1.1:8081" })
+
+	// A wait index of 0 would not block, so the watch would spin; it starts
+	// over from 1 instead and then blocks on 3.
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Contains(waits, 3)
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []uint64{0, 50, 1, 3}; !slices.Equal(waits, want) {
+		t.Errorf("wait indexes = %v, want %v", waits, want)
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
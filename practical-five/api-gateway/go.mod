@@ -5,6 +5,7 @@ go 1.23
 require (
 	github.com/go-chi/chi/v5 v5.0.11
 	github.com/hashicorp/consul/api v1.25.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
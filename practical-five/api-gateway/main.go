@@ -1,65 +1,117 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	consulapi "github.com/hashicorp/consul/api"
 )
 
-func discoverService(serviceName string) (string, error) {
-	config := consulapi.DefaultConfig()
-	config.Address = "consul:8500"
-
-	consul, err := consulapi.NewClient(config)
-	if err != nil {
-		return "", err
-	}
+// transport is shared by every proxy so connections to the services are kept
+// alive and reused across requests.
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   32,
+	IdleConnTimeout:       90 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
 
-	services, _, err := consul.Health().Service(serviceName, "", true, nil)
-	if err != nil {
-		return "", err
-	}
+type instanceKey struct{}
 
-	if len(services) == 0 {
-		return "", fmt.Errorf("no healthy instances of %s", serviceName)
+// newProxy returns a reverse proxy that sends each request to the instance
+// stored in its context, and reports back to p how the request went.
+func newProxy(p *pool) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Director: func(r *http.Request) {
+			inst := r.Context().Value(instanceKey{}).(*instance)
+			r.URL.Scheme = inst.target.Scheme
+			r.URL.Host = inst.target.Host
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			inst := resp.Request.Context().Value(instanceKey{}).(*instance)
+			p.report(inst, resp.StatusCode < 500, time.Now())
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			inst := r.Context().Value(instanceKey{}).(*instance)
+			// A client that went away says nothing about the instance.
+			if r.Context().Err() != context.Canceled {
+				p.report(inst, false, time.Now())
+			}
+			log.Printf("Proxying to %s at %s failed: %v", p.service, inst.target.Host, err)
+			if r.Context().Err() == context.DeadlineExceeded {
+				http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+				return
+			}
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		},
 	}
-
-	service := services[0].Service
-	return fmt.Sprintf("http://%s:%d", service.Address, service.Port), nil
 }
 
-func proxyToService(serviceName, stripPrefix string) http.HandlerFunc {
+// proxyToService sends requests to p's instances with stripPrefix taken off
+// the front of the path and addPrefix put on.
+func proxyToService(p *pool, stripPrefix, addPrefix string) http.HandlerFunc {
+	proxy := newProxy(p)
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Discover service dynamically
-		targetURL, err := discoverService(serviceName)
+		inst, err := p.pick(time.Now())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
 
-		target, _ := url.Parse(targetURL)
-		proxy := httputil.NewSingleHostReverseProxy(target)
-
-		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api")
-		log.Printf("Proxying to %s at %s", serviceName, targetURL)
-		proxy.ServeHTTP(w, r)
+		r.URL.Path = addPrefix + strings.TrimPrefix(r.URL.Path, stripPrefix)
+		r.URL.RawPath = ""
+		if !strings.HasPrefix(r.URL.Path, "/") {
+			r.URL.Path = "/" + r.URL.Path
+		}
+		log.Printf("Proxying to %s at %s", p.service, inst.target.Host)
+		proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), instanceKey{}, inst)))
 	}
 }
 
 func main() {
+	config := consulapi.DefaultConfig()
+	config.Address = "consul:8500"
+	consul, err := consulapi.NewClient(config)
+	if err != nil {
+		log.Fatalf("Failed to create Consul client: %v", err)
+	}
+
+	policy := os.Getenv("LB_POLICY")
+	switch policy {
+	case "":
+		policy = roundRobin
+	case roundRobin, weighted:
+	default:
+		log.Fatalf("Unknown LB_POLICY %q, want %s or %s", policy, roundRobin, weighted)
+	}
+	routesFile := os.Getenv("ROUTES_FILE")
+	if routesFile == "" {
+		routesFile = "routes.yaml"
+	}
+	g := newGateway(consul, policy)
+	if err := g.load(routesFile); err != nil {
+		log.Fatalf("Failed to load routes: %v", err)
+	}
+	go g.watchRoutes(routesFile)
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
-
-	r.HandleFunc("/api/users*", proxyToService("user-service", "/users"))
-	r.HandleFunc("/api/menu*", proxyToService("menu-service", "/menu"))
-	r.HandleFunc("/api/orders*", proxyToService("order-service", "/orders"))
+	r.Handle("/*", g)
 
 	log.Println("API Gateway starting on :8080")
 	http.ListenAndServe(":8080", r)
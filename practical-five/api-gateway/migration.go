@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Migration moves a route from the monolith to its new service a share of
+// users at a time:
+//
+//	migration:
+//	  legacy: http://monolith:8080  # where requests not sent to the service go
+//	  percent: 10                   # share of users sent to the service
+//	  cohorts: [beta, staff]        # X-Cohort values always sent to the service
+//	  shadow: true                  # mirror monolith requests to the service
+//	  shadow_methods: [GET]         # methods mirrored (default: GET, HEAD)
+//	  ignore_fields: [CreatedAt]    # JSON fields left out of the diff
+//
+// Users are told apart by X-User-Id, and a user stays on the same side for
+// as long as percent is unchanged; raising percent only moves users over.
+// Requests without X-User-Id are split at random. The monolith is sent the
+// path unchanged, the service the path after strip_prefix and add_prefix.
+//
+// With shadow on, requests the monolith serves are also sent to the service
+// once the monolith has answered, and any difference in status or body is
+// logged. The service's answer is thrown away. Only safe methods are
+// mirrored by default, so shadowing does not write twice.
+type Migration struct {
+	Legacy        string   `yaml:"legacy"`
+	Percent       int      `yaml:"percent"`
+	Cohorts       []string `yaml:"cohorts"`
+	Shadow        bool     `yaml:"shadow"`
+	ShadowMethods []string `yaml:"shadow_methods"`
+	IgnoreFields  []string `yaml:"ignore_fields"`
+}
+
+const (
+	userHeader   = "X-User-Id"
+	cohortHeader = "X-Cohort"
+	// servedByHeader tells clients which side answered: "monolith" or the
+	// service name.
+	servedByHeader = "X-Served-By"
+	// shadowHeader marks mirrored requests for the service.
+	shadowHeader = "X-Shadow-Request"
+)
+
+const (
+	// shadowMaxBody is the largest request or response body a shadow
+	// request is made for; bigger exchanges are not mirrored.
+	shadowMaxBody = 1 << 20
+	// shadowMaxInFlight caps the shadow requests running at once. Requests
+	// over the cap are not mirrored rather than queued.
+	shadowMaxInFlight = 32
+	// shadowTimeout bounds shadow requests on routes without a timeout.
+	shadowTimeout = 10 * time.Second
+	// shadowMaxDiffs is how many differences one log line lists.
+	shadowMaxDiffs = 10
+)
+
+func (m *Migration) validate() error {
+	u, err := url.Parse(m.Legacy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("migration legacy %q must be an http:// or https:// URL", m.Legacy)
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return fmt.Errorf("migration percent %d must be between 0 and 100", m.Percent)
+	}
+	for _, method := range m.ShadowMethods {
+		if method != strings.ToUpper(method) || method == "" {
+			return fmt.Errorf("shadow method %q must be upper case", method)
+		}
+	}
+	return nil
+}
+
+// toService reports whether r goes to the new service rather than the
+// monolith.
+func (m *Migration) toService(r *http.Request) bool {
+	if cohort := r.Header.Get(cohortHeader); cohort != "" {
+		for _, c := range m.Cohorts {
+			if c == cohort {
+				return true
+			}
+		}
+	}
+	user := r.Header.Get(userHeader)
+	if user == "" {
+		return rand.IntN(100) < m.Percent
+	}
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return int(h.Sum32()%100) < m.Percent
+}
+
+func (m *Migration) shadows(method string) bool {
+	methods := m.ShadowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	for _, sm := range methods {
+		if sm == method {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowSlots limits the shadow requests in flight across all routes.
+var shadowSlots = make(chan struct{}, shadowMaxInFlight)
+
+// shadowStats counts the shadow comparisons made on one route.
+type shadowStats struct {
+	compared atomic.Int64
+	differed atomic.Int64
+}
+
+// migrationHandler splits rt's requests between the monolith and service,
+// which proxies to the new service.
+func migrationHandler(rt Route, service http.Handler) http.Handler {
+	m := rt.Migration
+	target, _ := url.Parse(m.Legacy) // checked by validate
+	legacy := &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxying to the monolith at %s failed: %v", target.Host, err)
+			if r.Context().Err() == context.DeadlineExceeded {
+				http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+				return
+			}
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		},
+	}
+	ignore := make(map[string]bool, len(m.IgnoreFields))
+	for _, field := range m.IgnoreFields {
+		ignore[field] = true
+	}
+	timeout := rt.Timeout
+	if timeout <= 0 {
+		timeout = shadowTimeout
+	}
+	stats := &shadowStats{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.toService(r) {
+			w.Header().Set(servedByHeader, rt.Service)
+			service.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(servedByHeader, "monolith")
+		if !m.Shadow || !m.shadows(r.Method) {
+			legacy.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := bufferBody(r)
+		if !ok {
+			legacy.ServeHTTP(w, r)
+			return
+		}
+		// The shadow request outlives r, so it gets a copy of its own that
+		// is not canceled when the client's request ends.
+		shadow := r.Clone(context.WithoutCancel(r.Context()))
+		method, uri := r.Method, r.URL.RequestURI()
+		rec := &teeWriter{ResponseWriter: w, status: http.StatusOK}
+		legacy.ServeHTTP(rec, r)
+		if rec.overflow {
+			return
+		}
+
+		select {
+		case shadowSlots <- struct{}{}:
+		default:
+			log.Printf("Not shadowing %s %s: %d shadow requests already in flight", method, uri, shadowMaxInFlight)
+			return
+		}
+		go func() {
+			defer func() { <-shadowSlots }()
+			ctx, cancel := context.WithTimeout(shadow.Context(), timeout)
+			defer cancel()
+			shadow = shadow.WithContext(ctx)
+			shadow.Body = io.NopCloser(bytes.NewReader(body))
+			shadow.Header.Set(shadowHeader, "true")
+			got := &recorder{header: make(http.Header), status: http.StatusOK}
+			service.ServeHTTP(got, shadow)
+
+			stats.compared.Add(1)
+			diffs := diffResponses(rec.status, rec.body.Bytes(), got.status, got.body.Bytes(), ignore)
+			if len(diffs) > 0 {
+				stats.differed.Add(1)
+				log.Printf("Shadow diff for %s %s (%d of %d differ): monolith vs %s: %s",
+					method, uri, stats.differed.Load(), stats.compared.Load(), rt.Service, strings.Join(diffs, "; "))
+			}
+		}()
+	})
+}
+
+// bufferBody reads r's body so it can be sent twice. Bodies over
+// shadowMaxBody are left to stream and ok is false.
+func bufferBody(r *http.Request) (body []byte, ok bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, shadowMaxBody+1))
+	if err != nil || len(body) > shadowMaxBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// teeWriter passes a response on to the client and keeps a copy of it for
+// the shadow comparison.
+type teeWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (t *teeWriter) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if !t.overflow {
+		if t.body.Len()+len(p) > shadowMaxBody {
+			t.overflow = true
+			t.body.Reset()
+		} else {
+			t.body.Write(p)
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the client's writer, so
+// streamed responses are still flushed.
+func (t *teeWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// recorder collects the service's answer to a shadow request.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) WriteHeader(status int)      { r.status = status }
+func (r *recorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+
+// diffResponses lists how the service's response differs from the
+// monolith's. JSON bodies are compared by value, with fields named in ignore
+// left out; other bodies must match byte for byte.
+func diffResponses(wantStatus int, want []byte, gotStatus int, got []byte, ignore map[string]bool) []string {
+	var diffs []string
+	if wantStatus != gotStatus {
+		diffs = append(diffs, fmt.Sprintf("status: %d vs %d", wantStatus, gotStatus))
+	}
+	var wantJSON, gotJSON any
+	if json.Unmarshal(want, &wantJSON) == nil && json.Unmarshal(got, &gotJSON) == nil {
+		diffJSON("$", wantJSON, gotJSON, ignore, &diffs)
+	} else if !bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+		diffs = append(diffs, fmt.Sprintf("body: %d bytes vs %d bytes", len(want), len(got)))
+	}
+	if len(diffs) > shadowMaxDiffs {
+		diffs = append(diffs[:shadowMaxDiffs], fmt.Sprintf("and %d more", len(diffs)-shadowMaxDiffs))
+	}
+	return diffs
+}
+
+// diffJSON appends the differences between two decoded JSON values, each
+// named by its path, such as $[0].user_id.
+func diffJSON(path string, want, got any, ignore map[string]bool, diffs *[]string) {
+	if len(*diffs) > shadowMaxDiffs {
+		return
+	}
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ignore[k] {
+				continue
+			}
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inGot:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing from the service", path, k))
+			case !inWant:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: only from the service", path, k))
+			default:
+				diffJSON(path+"."+k, wv, gv, ignore, diffs)
+			}
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: %d items vs %d", path, len(w), len(g)))
+		}
+		for i := 0; i < len(w) && i < len(g); i++ {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], ignore, diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s vs %s", path, compactJSON(want), compactJSON(got)))
+	}
+}
+
+func compactJSON(v any) string {
+	b, _ := json.Marshal(v)
+	if len(b) > 80 {
+		return string(b[:77]) + "..."
+	}
+	return string(b)
+}
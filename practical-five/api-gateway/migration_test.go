@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// logBuffer collects log output; shadow requests log from their own
+// goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return logs
+}
+
+// respond answers every request with status and body.
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// shadowed counts the shadow requests a service was sent and answers them,
+// and any others, with next.
+type shadowed struct {
+	count atomic.Int32
+	next  http.Handler
+}
+
+func (s *shadowed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(shadowHeader) == "true" {
+		s.count.Add(1)
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+// newMigration returns /api/orders split between a monolith serving legacy
+// and service as m says.
+func newMigration(t *testing.T, m Migration, legacy, service http.Handler) http.Handler {
+	t.Helper()
+	monolith := httptest.NewServer(legacy)
+	t.Cleanup(monolith.Close)
+	m.Legacy = monolith.URL
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return migrationHandler(Route{Prefix: "/api/orders", Service: "order-service", Migration: &m}, service)
+}
+
+// waitForShadows waits for every shadow request in flight to be compared.
+// A request's shadow holds its slot from before the request returns until
+// after the comparison is logged.
+func waitForShadows(t *testing.T) {
+	t.Helper()
+	eventually(t, func() bool { return len(shadowSlots) == 0 })
+}
+
+func servedBy(t *testing.T, h http.Handler, r *http.Request) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s = %d", r.Method, r.URL, rec.Code)
+	}
+	return rec.Header().Get(servedByHeader)
+}
+
+func TestMigrationUsersStayPutAsPercentRises(t *testing.T) {
+	monolith, service := respond(200, `"monolith"`), respond(200, `"service"`)
+	onService := make(map[string]bool) // user IDs already moved over
+	for _, percent := range []int{0, 10, 25, 50, 90, 100} {
+		h := newMigration(t, Migration{Percent: percent}, monolith, service)
+		moved := 0
+		for i := range 500 {
+			user := strconv.Itoa(i)
+			r := httptest.NewRequest("GET", "/api/orders", nil)
+			r.Header.Set(userHeader, user)
+			side := servedBy(t, h, r)
+			if onService[user] && side != "order-service" {
+				t.Fatalf("percent %d: user %s went back to %s", percent, user, side)
+			}
+			if side == "order-service" {
+				onService[user] = true
+				moved++
+			}
+			// The same user lands on the same side every time.
+			if again := servedBy(t, h, r); again != side {
+				t.Fatalf("percent %d: user %s served by %s, then %s", percent, user, side, again)
+			}
+		}
+		if want := 500 * percent / 100; moved < want-50 || moved > want+50 {
+			t.Errorf("percent %d sent %d of 500 users to the service, want about %d", percent, moved, want)
+		}
+		if percent == 0 && moved != 0 || percent == 100 && moved != 500 {
+			t.Errorf("percent %d sent %d of 500 users to the service", percent, moved)
+		}
+	}
+}
+
+func TestMigrationCohorts(t *testing.T) {
+	h := newMigration(t, Migration{Percent: 0, Cohorts: []string{"beta", "staff"}},
+		respond(200, `"monolith"`), respond(200, `"service"`))
+
+	tests := []struct {
+		cohort string
+		want   string
+	}{
+		{"staff", "order-service"},
+		{"beta", "order-service"},
+		{"Staff", "monolith"},
+		{"public", "monolith"},
+		{"", "monolith"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/orders", nil)
+		r.Header.Set(userHeader, "42")
+		if tt.cohort != "" {
+			r.Header.Set(cohortHeader, tt.cohort)
+		}
+		if got := servedBy(t, h, r); got != tt.want {
+			t.Errorf("X-Cohort %q served by %s, want %s", tt.cohort, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationShadow(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        Migration
+		monolith http.Handler
+		service  http.Handler
+		// wantLog is in the shadow diff logged, or "" if none should be.
+		wantLog []string
+	}{
+		{
+			name:     "same answer",
+			m:        Migration{Shadow: true},
+			monolith: respond(200, `{"ID":1,"status":"pending"}`),
+			service:  respond(200, `{"status":"pending","ID":1}`),
+		},
+		{
+			name:     "field differs",
+			m:        Migration{Shadow: true},
+			monolith: respond(200, `{"ID":1,"status":"pending","items":[{"qty":2}]}`),
+			service:  respond(200, `{"ID":1,"status":"done","items":[{"qty":3}]}`),
+			wantLog: []string{
+				"Shadow diff for GET /api/orders/1 (1 of 1 differ): monolith vs order-service:",
+				`$.items[0].qty: 2 vs 3`,
+				`$.status: "pending" vs "done"`,
+			},
+		},
+		{
+			name:     "ignored fields differ",
+			m:        Migration{Shadow: true, IgnoreFields: []string{"ID", "CreatedAt"}},
+			monolith: respond(200, `{"ID":1,"CreatedAt":"2024-01-01","status":"pending"}`),
+			service:  respond(200, `{"ID":7,"CreatedAt":"2024-06-01","status":"pending"}`),
+		},
+		{
+			name:     "ignored fields differ as well as others",
+			m:        Migration{Shadow: true, IgnoreFields: []string{"ID"}},
+			monolith: respond(200, `{"ID":1,"status":"pending"}`),
+			service:  respond(200, `{"ID":7,"status":"done"}`),
+			wantLog:  []string{`$.status: "pending" vs "done"`},
+		},
+		{
+			name:     "status differs",
+			m:        Migration{Shadow: true},
+			monolith: respond(200, `{"ID":1}`),
+			service:  respond(404, `{"ID":1}`),
+			wantLog:  []string{"status: 200 vs 404"},
+		},
+		{
+			name:     "fields only on one side",
+			m:        Migration{Shadow: true},
+			monolith: respond(200, `{"ID":1,"user":2}`),
+			service:  respond(200, `{"ID":1,"user_id":2}`),
+			wantLog:  []string{"$.user: missing from the service", "$.user_id: only from the service"},
+		},
+		{
+			name:     "text bodies differ",
+			m:        Migration{Shadow: true},
+			monolith: respond(200, "ok"),
+			service:  respond(200, "okay"),
+			wantLog:  []string{"body: 2 bytes vs 4 bytes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			service := &shadowed{next: tt.service}
+			h := newMigration(t, tt.m, tt.monolith, service)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/orders/1", nil))
+			waitForShadows(t)
+
+			// The client gets the monolith's answer whatever the service said.
+			want := httptest.NewRecorder()
+			tt.monolith.ServeHTTP(want, httptest.NewRequest("GET", "/api/orders/1", nil))
+			if rec.Code != want.Code || rec.Body.String() != want.Body.String() || rec.Header().Get(servedByHeader) != "monolith" {
+				t.Errorf("client got %d %q from %s, want the monolith's %d %q",
+					rec.Code, rec.Body, rec.Header().Get(servedByHeader), want.Code, want.Body)
+			}
+			if n := service.count.Load(); n != 1 {
+				t.Errorf("service got %d shadow requests, want 1", n)
+			}
+
+			got := logs.String()
+			if len(tt.wantLog) == 0 && strings.Contains(got, "Shadow diff") {
+				t.Errorf("logged a diff for matching responses:\n%s", got)
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(got, want) {
+					t.Errorf("log does not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationShadowMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		method  string
+		want    int32
+	}{
+		{name: "GET by default", method: "GET", want: 1},
+		{name: "HEAD by default", method: "HEAD", want: 1},
+		{name: "no POST by default", method: "POST", want: 0},
+		{name: "POST when listed", methods: []string{"GET", "POST"}, method: "POST", want: 1},
+		{name: "no GET when not listed", methods: []string{"POST"}, method: "GET", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			service := &shadowed{next: respond(200, `{}`)}
+			h := newMigration(t, Migration{Shadow: true, ShadowMethods: tt.methods}, respond(200, `{}`), service)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/api/orders", strings.NewReader(`{"user_id":1}`)))
+			waitForShadows(t)
+			if n := service.count.Load(); n != tt.want {
+				t.Errorf("service got %d shadow requests, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationShadowSkipsLargeBodies(t *testing.T) {
+	large := strings.Repeat("x", shadowMaxBody+1)
+
+	t.Run("request", func(t *testing.T) {
+		captureLog(t)
+		var got atomic.Int64
+		monolith := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n, _ := io.Copy(io.Discard, r.Body)
+			got.Store(n)
+		})
+		service := &shadowed{next: respond(200, "")}
+		h := newMigration(t, Migration{Shadow: true, ShadowMethods: []string{"POST"}}, monolith, service)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/orders", strings.NewReader(large)))
+		waitForShadows(t)
+		if rec.Code != http.StatusOK || got.Load() != int64(len(large)) {
+			t.Errorf("monolith answered %d having read %d bytes, want 200 and all %d", rec.Code, got.Load(), len(large))
+		}
+		if n := service.count.Load(); n != 0 {
+			t.Errorf("service got %d shadow requests, want none", n)
+		}
+	})
+
+	t.Run("response", func(t *testing.T) {
+		captureLog(t)
+		service := &shadowed{next: respond(200, "")}
+		h := newMigration(t, Migration{Shadow: true}, respond(200, large), service)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/orders", nil))
+		waitForShadows(t)
+		if rec.Body.Len() != len(large) {
+			t.Errorf("client got %d bytes, want all %d", rec.Body.Len(), len(large))
+		}
+		if n := service.count.Load(); n != 0 {
+			t.Errorf("service got %d shadow requests, want none", n)
+		}
+	})
+}
+
+func TestMigrationShadowCap(t *testing.T) {
+	logs := captureLog(t)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+	service := &shadowed{next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})}
+	h := newMigration(t, Migration{Shadow: true}, respond(200, `{}`), service)
+
+	// With the service stuck, every slot fills up and the requests after
+	// that are served without a shadow rather than waiting for one.
+	const extra = 5
+	for range shadowMaxInFlight + extra {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/orders", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET = %d", rec.Code)
+		}
+	}
+	eventually(t, func() bool { return service.count.Load() == shadowMaxInFlight })
+	if n := strings.Count(logs.String(), "shadow requests already in flight"); n != extra {
+		t.Errorf("%d requests not shadowed for the cap, want %d", n, extra)
+	}
+
+	releaseOnce.Do(func() { close(release) })
+	waitForShadows(t)
+	if n := service.count.Load(); n != shadowMaxInFlight {
+		t.Errorf("service got %d shadow requests, want %d", n, shadowMaxInFlight)
+	}
+
+	// Once the slots are free again, requests are shadowed again.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders", nil))
+	waitForShadows(t)
+	if n := service.count.Load(); n != shadowMaxInFlight+1 {
+		t.Errorf("service got %d shadow requests after the slots freed up, want %d", n, shadowMaxInFlight+1)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v3"
+)
+
+// Route sends requests under Prefix to Service. The routes file is YAML or
+// JSON:
+//
+//	routes:
+//	  - prefix: /api/orders
+//	    service: order-service
+//	    strip_prefix: /api      # /api/orders/1 is sent as /orders/1
+//	    methods: [GET, POST]    # others get 405; empty allows all
+//	    timeout: 10s            # 504 if the service takes longer
+//	    middleware: [request_id]
+//	    migration:              # share the route with the monolith
+//	      legacy: http://monolith:8080
+//	      percent: 10
+type Route struct {
+	Prefix      string        `yaml:"prefix"`
+	Service     string        `yaml:"service"`
+	StripPrefix string        `yaml:"strip_prefix"`
+	AddPrefix   string        `yaml:"add_prefix"`
+	Methods     []string      `yaml:"methods"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Middleware  []string      `yaml:"middleware"`
+	// Migration, when set, splits the route with the monolith; see
+	// Migration.
+	Migration *Migration `yaml:"migration"`
+}
+
+// middlewares are the per-route middleware a routes file can name.
+var middlewares = map[string]func(http.Handler) http.Handler{
+	// request_id passes the caller's X-Request-Id on to the service, making
+	// one up if there is none.
+	"request_id": func(next http.Handler) http.Handler {
+		return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+			next.ServeHTTP(w, r)
+		}))
+	},
+	"real_ip":   middleware.RealIP,
+	"no_cache":  middleware.NoCache,
+	"recoverer": middleware.Recoverer,
+	"compress":  middleware.Compress(5),
+}
+
+// routesPollInterval is how often the routes file is checked for changes.
+const routesPollInterval = 2 * time.Second
+
+// loadRoutes reads and checks a routes file.
+func loadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Routes []Route `yaml:"routes"`
+	}
+	// YAML is a superset of JSON, so this reads either.
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(file.Routes) == 0 {
+		return nil, fmt.Errorf("%s: no routes", path)
+	}
+	seen := make(map[string]bool)
+	for i, rt := range file.Routes {
+		if err := rt.validate(); err != nil {
+			return nil, fmt.Errorf("%s: route %d: %v", path, i+1, err)
+		}
+		if seen[rt.Prefix] {
+			return nil, fmt.Errorf("%s: route %d: prefix %s is used twice", path, i+1, rt.Prefix)
+		}
+		seen[rt.Prefix] = true
+	}
+	return file.Routes, nil
+}
+
+func (rt Route) validate() error {
+	if !strings.HasPrefix(rt.Prefix, "/") || strings.ContainsAny(rt.Prefix, "*{}") {
+		return fmt.Errorf("prefix %q must start with / and have no * or {}", rt.Prefix)
+	}
+	if rt.Service == "" {
+		return fmt.Errorf("prefix %s has no service", rt.Prefix)
+	}
+	if rt.AddPrefix != "" && !strings.HasPrefix(rt.AddPrefix, "/") {
+		return fmt.Errorf("add_prefix %q must start with /", rt.AddPrefix)
+	}
+	for _, method := range rt.Methods {
+		if method != strings.ToUpper(method) || method == "" {
+			return fmt.Errorf("method %q must be upper case", method)
+		}
+	}
+	if rt.Timeout < 0 {
+		return fmt.Errorf("timeout %v is negative", rt.Timeout)
+	}
+	for _, name := range rt.Middleware {
+		if _, ok := middlewares[name]; !ok {
+			return fmt.Errorf("unknown middleware %q", name)
+		}
+	}
+	if rt.Migration != nil {
+		return rt.Migration.validate()
+	}
+	return nil
+}
+
+// gateway serves the routes in the routes file. Reloading swaps in a new
+// router; requests already being served finish on the old one, and the
+// instance pools and their connections are kept.
+type gateway struct {
+	consul *consulapi.Client
+	policy string
+
+	mu    sync.Mutex
+	pools map[string]*pool // by service
+
+	router atomic.Pointer[chi.Mux]
+	// stamp tells whether the routes file has changed since it was read.
+	stamp struct {
+		modTime time.Time
+		size    int64
+	}
+}
+
+func newGateway(consul *consulapi.Client, policy string) *gateway {
+	return &gateway{consul: consul, policy: policy, pools: make(map[string]*pool)}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.router.Load().ServeHTTP(w, r)
+}
+
+// pool returns the instance pool of service, starting to watch Consul for it
+// the first time a route names it.
+func (g *gateway) pool(service string) *pool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.pools[service]
+	if !ok {
+		p = &pool{service: service, policy: g.policy}
+		g.pools[service] = p
+		go p.watch(context.Background(), g.consul)
+	}
+	return p
+}
+
+// load reads the routes file and, if it is valid, starts serving it. An
+// invalid file leaves the routes in use as they are.
+func (g *gateway) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// A broken file is not retried until it changes again.
+	g.stamp.modTime, g.stamp.size = info.ModTime(), info.Size()
+	routes, err := loadRoutes(path)
+	if err != nil {
+		return err
+	}
+
+	r := chi.NewRouter()
+	for _, rt := range routes {
+		h := g.routeHandler(rt)
+		r.Handle(rt.Prefix, h)
+		r.Handle(strings.TrimSuffix(rt.Prefix, "/")+"/*", h)
+	}
+	g.router.Store(r)
+	log.Printf("Loaded %d routes from %s", len(routes), path)
+	return nil
+}
+
+func (g *gateway) routeHandler(rt Route) http.Handler {
+	var h http.Handler = proxyToService(g.pool(rt.Service), rt.StripPrefix, rt.AddPrefix)
+	if rt.Migration != nil {
+		h = migrationHandler(rt, h)
+	}
+	if rt.Timeout > 0 {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), rt.Timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+	for i := len(rt.Middleware) - 1; i >= 0; i-- {
+		h = middlewares[rt.Middleware[i]](h)
+	}
+	if len(rt.Methods) > 0 {
+		next := h
+		allow := strings.Join(rt.Methods, ", ")
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, method := range rt.Methods {
+				if r.Method == method {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.Header().Set("Allow", allow)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		})
+	}
+	return h
+}
+
+// watchRoutes reloads the routes file on SIGHUP and whenever it changes.
+func (g *gateway) watchRoutes(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(routesPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			log.Printf("Reloading routes on SIGHUP")
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || (info.ModTime().Equal(g.stamp.modTime) && info.Size() == g.stamp.size) {
+				continue
+			}
+			log.Printf("Reloading routes: %s changed", path)
+		}
+		if err := g.load(path); err != nil {
+			log.Printf("Keeping the current routes, reload failed: %v", err)
+		}
+	}
+}
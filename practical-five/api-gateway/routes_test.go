@@ -0,0 +1,367 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// newTestGateway returns a gateway whose services are the given handlers,
+// each served by its own test server. Their pools are filled in up front,
+// so no Consul is needed.
+func newTestGateway(t *testing.T, services map[string]http.Handler) *gateway {
+	t.Helper()
+	g := newGateway(nil, roundRobin)
+	for name, h := range services {
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		u, _ := url.Parse(srv.URL)
+		host, port, _ := net.SplitHostPort(u.Host)
+		p, _ := strconv.Atoi(port)
+		g.pools[name] = &pool{service: name, policy: roundRobin}
+		g.pools[name].set([]*consulapi.ServiceEntry{{
+			Node:    &consulapi.Node{Address: host},
+			Service: &consulapi.AgentService{ID: name + "-1", Service: name, Port: p},
+		}})
+	}
+	return g
+}
+
+// echo answers with the service's name and the request URI it got.
+func echo(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.RequestURI())
+	})
+}
+
+func writeRoutes(t *testing.T, path, routes string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(routes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadTestRoutes(t *testing.T, g *gateway, routes string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeRoutes(t, path, routes)
+	if err := g.load(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(t *testing.T, h http.Handler, method, target string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestLoadRoutesRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		want   string
+	}{
+		{
+			name:   "no routes",
+			routes: "routes: []",
+			want:   "no routes",
+		},
+		{
+			name:   "not YAML",
+			routes: "routes: [",
+			want:   "yaml",
+		},
+		{
+			name: "duplicate prefix",
+			routes: `
+routes:
+  - {prefix: /api/users, service: user-service}
+  - {prefix: /api/users, service: other-service}`,
+			want: "route 2: prefix /api/users is used twice",
+		},
+		{
+			name:   "relative prefix",
+			routes: "routes: [{prefix: api/users, service: user-service}]",
+			want:   `route 1: prefix "api/users" must start with /`,
+		},
+		{
+			name:   "wildcard prefix",
+			routes: "routes: [{prefix: /api/*, service: user-service}]",
+			want:   `prefix "/api/*" must start with / and have no * or {}`,
+		},
+		{
+			name:   "no service",
+			routes: "routes: [{prefix: /api/users}]",
+			want:   "prefix /api/users has no service",
+		},
+		{
+			name:   "unknown middleware",
+			routes: "routes: [{prefix: /api/users, service: user-service, middleware: [request_id, gzip]}]",
+			want:   `unknown middleware "gzip"`,
+		},
+		{
+			name:   "timeout that is not a duration",
+			routes: "routes: [{prefix: /api/users, service: user-service, timeout: soon}]",
+			want:   "soon",
+		},
+		{
+			name:   "negative timeout",
+			routes: "routes: [{prefix: /api/users, service: user-service, timeout: -5s}]",
+			want:   "timeout -5s is negative",
+		},
+		{
+			name:   "lower case method",
+			routes: "routes: [{prefix: /api/users, service: user-service, methods: [get]}]",
+			want:   `method "get" must be upper case`,
+		},
+		{
+			name:   "relative add_prefix",
+			routes: "routes: [{prefix: /api/users, service: user-service, add_prefix: v1}]",
+			want:   `add_prefix "v1" must start with /`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "routes.yaml")
+			writeRoutes(t, path, tt.routes)
+			_, err := loadRoutes(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadRoutes() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsRoutesOnBadFile(t *testing.T) {
+	g := newTestGateway(t, map[string]http.Handler{"user-service": echo("users")})
+	path := loadTestRoutes(t, g, "routes: [{prefix: /api/users, service: user-service}]")
+
+	writeRoutes(t, path, "routes: [{prefix: /api/users, service: user-service, middleware: [nope]}]")
+	if err := g.load(path); err == nil {
+		t.Fatal("load() accepted an unknown middleware")
+	}
+	if code, body := get(t, g, "GET", "/api/users/1"); code != http.StatusOK || body != "users /api/users/1" {
+		t.Errorf("after a bad reload: %d %q, want the old route", code, body)
+	}
+
+	os.Remove(path)
+	if err := g.load(path); err == nil {
+		t.Fatal("load() accepted a missing file")
+	}
+	if code, _ := get(t, g, "GET", "/api/users/1"); code != http.StatusOK {
+		t.Errorf("after the file went away: %d, want the old route", code)
+	}
+}
+
+func TestPrefixRewriting(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		target string
+		want   string
+	}{
+		{
+			name:   "as is",
+			route:  "{prefix: /api/orders, service: order-service}",
+			target: "/api/orders/1?expand=items",
+			want:   "orders /api/orders/1?expand=items",
+		},
+		{
+			name:   "strip",
+			route:  "{prefix: /api/orders, service: order-service, strip_prefix: /api}",
+			target: "/api/orders/1",
+			want:   "orders /orders/1",
+		},
+		{
+			name:   "strip the whole prefix",
+			route:  "{prefix: /api/orders, service: order-service, strip_prefix: /api/orders}",
+			target: "/api/orders",
+			want:   "orders /",
+		},
+		{
+			name:   "add",
+			route:  "{prefix: /api/orders, service: order-service, add_prefix: /v1}",
+			target: "/api/orders/1",
+			want:   "orders /v1/api/orders/1",
+		},
+		{
+			name:   "strip and add",
+			route:  "{prefix: /api/orders, service: order-service, strip_prefix: /api, add_prefix: /v2}",
+			target: "/api/orders/1?status=open",
+			want:   "orders /v2/orders/1?status=open",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, map[string]http.Handler{"order-service": echo("orders")})
+			loadTestRoutes(t, g, "routes: ["+tt.route+"]")
+			if code, body := get(t, g, "GET", tt.target); code != http.StatusOK || body != tt.want {
+				t.Errorf("GET %s = %d %q, want %q", tt.target, code, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnroutedPath(t *testing.T) {
+	g := newTestGateway(t, map[string]http.Handler{"order-service": echo("orders")})
+	loadTestRoutes(t, g, "routes: [{prefix: /api/orders, service: order-service}]")
+	if code, _ := get(t, g, "GET", "/api/ordersx"); code != http.StatusNotFound {
+		t.Errorf("GET /api/ordersx = %d, want 404", code)
+	}
+}
+
+func TestMethodFiltering(t *testing.T) {
+	g := newTestGateway(t, map[string]http.Handler{"menu-service": echo("menu")})
+	loadTestRoutes(t, g, "routes: [{prefix: /api/menu, service: menu-service, methods: [GET, HEAD]}]")
+
+	for _, method := range []string{"GET", "HEAD"} {
+		if code, _ := get(t, g, method, "/api/menu"); code != http.StatusOK {
+			t.Errorf("%s = %d, want 200", method, code)
+		}
+	}
+	for _, method := range []string{"POST", "DELETE"} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(method, "/api/menu/1", nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s = %d with Allow %q, want 405 with Allow: GET, HEAD", method, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			io.WriteString(w, "done")
+		case <-r.Context().Done():
+		}
+	})
+	g := newTestGateway(t, map[string]http.Handler{"slow-service": slow})
+	loadTestRoutes(t, g, `
+routes:
+  - {prefix: /impatient, service: slow-service, timeout: 50ms}
+  - {prefix: /patient, service: slow-service, timeout: 5s}
+  - {prefix: /forever, service: slow-service}`)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/impatient", http.StatusGatewayTimeout},
+		{"/patient", http.StatusOK},
+		{"/forever", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, _ := get(t, g, "GET", tt.path); code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, code, tt.want)
+		}
+	}
+}
+
+// TestReloadWhileServing swaps the router under requests in flight: a
+// request that started on the old routes finishes there, new requests take
+// the new routes, and none fail.
+func TestReloadWhileServing(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/users/block" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "old "+r.URL.Path)
+	})
+	g := newTestGateway(t, map[string]http.Handler{"old-service": blocking, "new-service": echo("new")})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	oldRoutes := "routes: [{prefix: /api/users, service: old-service}]"
+	newRoutes := "routes: [{prefix: /api/users, service: new-service}]"
+	path := loadTestRoutes(t, g, oldRoutes)
+
+	blocked := make(chan string)
+	go func() {
+		resp, err := http.Get(srv.URL + "/api/users/block")
+		if err != nil {
+			blocked <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		blocked <- string(body)
+	}()
+	<-started
+
+	// Hammer the route while it is switched back and forth.
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := http.Get(srv.URL + "/api/users/1")
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || (string(body) != "old /api/users/1" && string(body) != "new /api/users/1") {
+					errs <- resp.Status + " " + string(body)
+					return
+				}
+			}
+		}()
+	}
+	for i := range 20 {
+		routes := newRoutes
+		if i%2 == 1 {
+			routes = oldRoutes
+		}
+		writeRoutes(t, path, routes)
+		if err := g.load(path); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	writeRoutes(t, path, newRoutes)
+	if err := g.load(path); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("request during reloads: %s", err)
+	}
+
+	if code, body := get(t, g, "GET", "/api/users/1"); code != http.StatusOK || body != "new /api/users/1" {
+		t.Errorf("after reloading: %d %q, want the new service", code, body)
+	}
+	releaseOnce.Do(func() { close(release) })
+	if body := <-blocked; body != "old /api/users/block" {
+		t.Errorf("request in flight during the reloads got %q, want the old service's answer", body)
+	}
+}
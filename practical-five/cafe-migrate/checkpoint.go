@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint records how far each table has been copied, so an interrupted
+// migration carries on where it stopped. It is rewritten after every batch.
+type checkpoint struct {
+	path string
+
+	// Databases fingerprints the source and targets the checkpoint was made
+	// for, so it is not applied to others by mistake.
+	Databases string `json:"databases"`
+	// LastID is the highest ID copied, by table.
+	LastID map[string]uint `json:"last_id"`
+}
+
+// loadCheckpoint reads the checkpoint at path, or starts a new one if there
+// is none.
+func loadCheckpoint(path, databases string) (*checkpoint, error) {
+	cp := &checkpoint{path: path, Databases: databases, LastID: make(map[string]uint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if cp.Databases != databases {
+		return nil, fmt.Errorf("%s was written for other databases; remove it or run with -restart", path)
+	}
+	if cp.LastID == nil {
+		cp.LastID = make(map[string]uint)
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint through a temporary file, so a crash leaves
+// either the old checkpoint or the new one.
+func (cp *checkpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), filepath.Base(cp.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cp.path)
+}
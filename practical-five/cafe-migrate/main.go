@@ -0,0 +1,138 @@
+// Command cafe-migrate copies the student-cafe-monolith database into the
+// practical-five service databases: users to user_db, menu items to menu_db,
+// and orders with their items to order_db. IDs are kept, so references
+// between services stay valid.
+//
+//	cafe-migrate [-dry-run] [-verify] [-restart] [-batch 500] [-checkpoint file]
+//
+// Rows are copied in batches, and progress is saved to the checkpoint file
+// after each, so an interrupted run carries on where it stopped. Running it
+// again later copies rows added to the monolith since. Every run ends by
+// comparing the row count and a checksum of each table in both databases.
+//
+// Databases are PostgreSQL DSNs, or sqlite:<path> for SQLite, taken from
+// SOURCE_DATABASE_URL, USER_DATABASE_URL, MENU_DATABASE_URL and
+// ORDER_DATABASE_URL. The defaults are the docker-compose databases.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// config is what one run of cafe-migrate does.
+type config struct {
+	Source, Users, Menu, Orders string // DSNs
+	Batch                       int
+	Checkpoint                  string
+	DryRun                      bool
+	VerifyOnly                  bool
+	Restart                     bool
+}
+
+func main() {
+	cfg := config{
+		Source: getenv("SOURCE_DATABASE_URL", "host=localhost user=postgres password=postgres dbname=student_cafe port=5432 sslmode=disable"),
+		Users:  getenv("USER_DATABASE_URL", "host=localhost user=postgres password=postgres dbname=user_db port=5434 sslmode=disable"),
+		Menu:   getenv("MENU_DATABASE_URL", "host=localhost user=postgres password=postgres dbname=menu_db port=5433 sslmode=disable"),
+		Orders: getenv("ORDER_DATABASE_URL", "host=localhost user=postgres password=postgres dbname=order_db port=5435 sslmode=disable"),
+	}
+	flag.IntVar(&cfg.Batch, "batch", 500, "rows copied per batch")
+	flag.StringVar(&cfg.Checkpoint, "checkpoint", "cafe-migrate.checkpoint.json", "file progress is saved to")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "report what would be copied without writing anything")
+	flag.BoolVar(&cfg.VerifyOnly, "verify", false, "only compare row counts and checksums")
+	flag.BoolVar(&cfg.Restart, "restart", false, "ignore the checkpoint and copy every row again")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ok, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// run carries out cfg and reports whether the databases match afterwards. A
+// dry run always reports true.
+func run(ctx context.Context, cfg config) (bool, error) {
+	if cfg.Batch <= 0 {
+		return false, fmt.Errorf("batch size must be positive, got %d", cfg.Batch)
+	}
+	var dbs [4]*gorm.DB
+	for i, dsn := range []string{cfg.Source, cfg.Users, cfg.Menu, cfg.Orders} {
+		db, err := open(dsn)
+		if err != nil {
+			return false, err
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			defer sqlDB.Close()
+		}
+		dbs[i] = db
+	}
+	m := newMigrator(dbs[0], dbs[1], dbs[2], dbs[3], cfg.Batch, os.Stdout)
+	return m.execute(ctx, cfg)
+}
+
+// execute is run with the databases open.
+func (m *migrator) execute(ctx context.Context, cfg config) (bool, error) {
+	if cfg.VerifyOnly {
+		return m.verify(ctx)
+	}
+
+	fingerprint := fingerprint(cfg.Source, cfg.Users, cfg.Menu, cfg.Orders)
+	cp, err := loadCheckpoint(cfg.Checkpoint, fingerprint)
+	if cfg.Restart {
+		cp, err = &checkpoint{path: cfg.Checkpoint, Databases: fingerprint, LastID: make(map[string]uint)}, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if cfg.DryRun {
+		return true, m.dryRun(ctx, cp)
+	}
+	if err := m.migrate(ctx, cp); err != nil {
+		return false, err
+	}
+	return m.verify(ctx)
+}
+
+// open connects to a PostgreSQL DSN, or to a SQLite file given as
+// sqlite:<path>.
+func open(dsn string) (*gorm.DB, error) {
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)}
+	if path, ok := strings.CutPrefix(dsn, "sqlite:"); ok {
+		return gorm.Open(sqlite.Open(path), config)
+	}
+	return gorm.Open(postgres.Open(dsn), config)
+}
+
+// fingerprint identifies a set of databases without keeping their
+// passwords.
+func fingerprint(dsns ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(dsns, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// model is a row of one of the tables copied.
+type model interface {
+	User | MenuItem | Order | OrderItem
+	key() uint
+	line() string
+}
+
+// table copies and checksums one table. Tables keep their name in the
+// service database they move to.
+type table struct {
+	name   string
+	target string // which service database, as named in the output
+	dst    *gorm.DB
+
+	// copyBatch copies up to limit rows with IDs above after, returning how
+	// many it copied and the highest ID among them.
+	copyBatch func(ctx context.Context, src, dst *gorm.DB, after uint, limit int) (n int, last uint, err error)
+	// checksum counts the rows in db and hashes them in ID order.
+	checksum func(ctx context.Context, db *gorm.DB, batch int) (count int64, sum string, err error)
+}
+
+func newTable[T model](name, target string, dst *gorm.DB) table {
+	return table{
+		name:   name,
+		target: target,
+		dst:    dst,
+		copyBatch: func(ctx context.Context, src, dst *gorm.DB, after uint, limit int) (int, uint, error) {
+			rows, err := readBatch[T](ctx, src, after, limit)
+			if err != nil || len(rows) == 0 {
+				return 0, after, err
+			}
+			cols, err := columns[T](dst)
+			if err != nil {
+				return 0, after, err
+			}
+			// Rows are upserted, so copying a batch again after a crash
+			// between the insert and the checkpoint does no harm. The
+			// columns are listed because UpdateAll would set updated_at to
+			// now.
+			err = dst.WithContext(ctx).
+				Omit(clause.Associations).
+				Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "id"}},
+					DoUpdates: clause.AssignmentColumns(cols),
+				}).
+				Create(&rows).Error
+			if err != nil {
+				return 0, after, err
+			}
+			return len(rows), rows[len(rows)-1].key(), nil
+		},
+		checksum: func(ctx context.Context, db *gorm.DB, batch int) (int64, string, error) {
+			h := sha256.New()
+			var count int64
+			var after uint
+			for {
+				rows, err := readBatch[T](ctx, db, after, batch)
+				if err != nil {
+					return 0, "", err
+				}
+				if len(rows) == 0 {
+					return count, hex.EncodeToString(h.Sum(nil))[:16], nil
+				}
+				for _, row := range rows {
+					io.WriteString(h, row.line()+"\n")
+				}
+				count += int64(len(rows))
+				after = rows[len(rows)-1].key()
+			}
+		},
+	}
+}
+
+// columns lists T's columns other than its ID.
+func columns[T model](db *gorm.DB) ([]string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	var cols []string
+	for _, name := range stmt.Schema.DBNames {
+		if name != "id" {
+			cols = append(cols, name)
+		}
+	}
+	return cols, nil
+}
+
+// readBatch reads up to limit rows with IDs above after, soft-deleted ones
+// included, in ID order.
+func readBatch[T model](ctx context.Context, db *gorm.DB, after uint, limit int) ([]T, error) {
+	var rows []T
+	err := db.WithContext(ctx).Unscoped().
+		Where("id > ?", after).
+		Order("id").
+		Limit(limit).
+		Find(&rows).Error
+	return rows, err
+}
+
+// migrator copies the monolith's database into the service databases.
+type migrator struct {
+	source  *gorm.DB
+	tables  []table
+	batch   int
+	out     io.Writer
+	targets map[string]*gorm.DB // by name, for creating their tables
+
+	// afterBatch, if set, is called once a batch is copied and checkpointed.
+	afterBatch func(table string, lastID uint)
+}
+
+func newMigrator(source, users, menu, orders *gorm.DB, batch int, out io.Writer) *migrator {
+	return &migrator{
+		source: source,
+		batch:  batch,
+		out:    out,
+		// Orders come before their items, which reference them.
+		tables: []table{
+			newTable[User]("users", "user_db", users),
+			newTable[MenuItem]("menu_items", "menu_db", menu),
+			newTable[Order]("orders", "order_db", orders),
+			newTable[OrderItem]("order_items", "order_db", orders),
+		},
+		targets: map[string]*gorm.DB{"user_db": users, "menu_db": menu, "order_db": orders},
+	}
+}
+
+// prepare creates the service tables as the services themselves would.
+func (m *migrator) prepare() error {
+	for name, models := range map[string][]any{
+		"user_db":  {&User{}},
+		"menu_db":  {&MenuItem{}},
+		"order_db": {&Order{}, &OrderItem{}},
+	} {
+		if err := m.targets[name].AutoMigrate(models...); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// migrate copies every table from where cp says it got to, in batches,
+// checkpointing after each. Rows added to the monolith since an earlier run
+// are picked up; rows changed since they were copied are not, which verify
+// shows.
+func (m *migrator) migrate(ctx context.Context, cp *checkpoint) error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+	for _, t := range m.tables {
+		copied := 0
+		for {
+			n, last, err := t.copyBatch(ctx, m.source, t.dst, cp.LastID[t.name], m.batch)
+			if err != nil {
+				return fmt.Errorf("copying %s after ID %d: %w", t.name, cp.LastID[t.name], err)
+			}
+			if n == 0 {
+				break
+			}
+			cp.LastID[t.name] = last
+			if err := cp.save(); err != nil {
+				return fmt.Errorf("saving checkpoint: %v", err)
+			}
+			copied += n
+			log.Printf("Copied %d %s rows up to ID %d", n, t.name, last)
+			if m.afterBatch != nil {
+				m.afterBatch(t.name, last)
+			}
+			if n < m.batch {
+				break
+			}
+		}
+		if err := resetSequence(ctx, t.dst, t.name); err != nil {
+			return fmt.Errorf("resetting %s ID sequence: %v", t.name, err)
+		}
+		fmt.Fprintf(m.out, "%s: copied %d rows to %s (up to ID %d)\n", t.name, copied, t.target, cp.LastID[t.name])
+	}
+	return nil
+}
+
+// resetSequence moves a PostgreSQL table's ID sequence past the copied IDs,
+// so the service's next insert does not collide with them. SQLite needs
+// nothing.
+func resetSequence(ctx context.Context, db *gorm.DB, table string) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return db.WithContext(ctx).Exec(
+		fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)", table),
+		table,
+	).Error
+}
+
+// dryRun reports what migrate would copy without writing anything, not even
+// the checkpoint.
+func (m *migrator) dryRun(ctx context.Context, cp *checkpoint) error {
+	for _, t := range m.tables {
+		after := cp.LastID[t.name]
+		var stats struct {
+			Count int64
+			MinID *uint
+			MaxID *uint
+		}
+		err := m.source.WithContext(ctx).Table(t.name).Unscoped().
+			Select("COUNT(*) AS count, MIN(id) AS min_id, MAX(id) AS max_id").
+			Where("id > ?", after).
+			Scan(&stats).Error
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", t.name, err)
+		}
+
+		existing := "does not exist yet"
+		if t.dst.Migrator().HasTable(t.name) {
+			var count int64
+			if err := t.dst.WithContext(ctx).Table(t.name).Count(&count).Error; err != nil {
+				return fmt.Errorf("reading %s.%s: %v", t.target, t.name, err)
+			}
+			existing = fmt.Sprintf("has %d rows", count)
+		}
+
+		if stats.Count == 0 {
+			fmt.Fprintf(m.out, "%s: nothing to copy after ID %d; %s.%s %s\n", t.name, after, t.target, t.name, existing)
+			continue
+		}
+		batches := (stats.Count + int64(m.batch) - 1) / int64(m.batch)
+		fmt.Fprintf(m.out, "%s: would copy %d rows (IDs %d-%d) in %d batches to %s.%s, which %s\n",
+			t.name, stats.Count, *stats.MinID, *stats.MaxID, batches, t.target, t.name, existing)
+	}
+	return nil
+}
+
+// verify compares each table's row count and checksum in the monolith and
+// its service database, and reports whether they all match.
+func (m *migrator) verify(ctx context.Context) (bool, error) {
+	ok := true
+	for _, t := range m.tables {
+		srcCount, srcSum, err := t.checksum(ctx, m.source, m.batch)
+		if err != nil {
+			return false, fmt.Errorf("checksumming %s: %v", t.name, err)
+		}
+		dstCount, dstSum, err := t.checksum(ctx, t.dst, m.batch)
+		if err != nil {
+			return false, fmt.Errorf("checksumming %s.%s: %v", t.target, t.name, err)
+		}
+		switch {
+		case srcCount != dstCount:
+			ok = false
+			fmt.Fprintf(m.out, "%s: MISMATCH: %d rows in the monolith, %d in %s\n", t.name, srcCount, dstCount, t.target)
+		case srcSum != dstSum:
+			ok = false
+			fmt.Fprintf(m.out, "%s: MISMATCH: %d rows each, checksum %s in the monolith, %s in %s\n", t.name, srcCount, srcSum, dstSum, t.target)
+		default:
+			fmt.Fprintf(m.out, "%s: OK: %d rows, checksum %s\n", t.name, srcCount, srcSum)
+		}
+	}
+	return ok, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// env is a monolith database and three service databases, all SQLite files.
+type env struct {
+	cfg    config
+	source *gorm.DB
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	dir := t.TempDir()
+	e := &env{cfg: config{
+		Source:     "sqlite:" + filepath.Join(dir, "student_cafe.db"),
+		Users:      "sqlite:" + filepath.Join(dir, "user_db.db"),
+		Menu:       "sqlite:" + filepath.Join(dir, "menu_db.db"),
+		Orders:     "sqlite:" + filepath.Join(dir, "order_db.db"),
+		Batch:      2,
+		Checkpoint: filepath.Join(dir, "checkpoint.json"),
+	}}
+	e.source = e.open(t, e.cfg.Source)
+	if err := e.source.AutoMigrate(&User{}, &MenuItem{}, &Order{}, &OrderItem{}); err != nil {
+		t.Fatal(err)
+	}
+	e.seed(t)
+	return e
+}
+
+func (e *env) open(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// seed fills the monolith with rows whose IDs have gaps, and some
+// soft-deleted rows, as a database in use would have.
+func (e *env) seed(t *testing.T) {
+	t.Helper()
+	at := time.Date(2025, 3, 1, 9, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range []string{"Ada", "Grace", "Linus", "Ken", "Barbara"} {
+		must(e.source.Create(&User{Model: gorm.Model{CreatedAt: at.Add(time.Duration(i) * time.Hour)}, Name: name, Email: strings.ToLower(name) + "@example.com"}).Error)
+	}
+	must(e.source.Unscoped().Delete(&User{}, 2).Error) // a gap
+	must(e.source.Delete(&User{}, 4).Error)            // soft-deleted, still copied
+	for _, item := range []MenuItem{{Name: "Coffee", Price: 2.5}, {Name: "Tea", Price: 1.8}, {Name: "Bagel", Description: "With \"cream\" cheese", Price: 3.25}} {
+		must(e.source.Create(&item).Error)
+	}
+	must(e.source.Create(&Order{UserID: 1, Status: "pending", OrderItems: []OrderItem{{MenuItemID: 1, Quantity: 2, Price: 2.5}, {MenuItemID: 3, Quantity: 1, Price: 3.25}}}).Error)
+	must(e.source.Create(&Order{UserID: 3, Status: "completed", OrderItems: []OrderItem{{MenuItemID: 2, Quantity: 1, Price: 1.8}}}).Error)
+	must(e.source.Create(&Order{UserID: 5, Status: "pending", OrderItems: []OrderItem{{MenuItemID: 1, Quantity: 1, Price: 2.5}}}).Error)
+}
+
+func (e *env) run(t *testing.T, ctx context.Context, cfg config) (string, bool, error) {
+	t.Helper()
+	var out bytes.Buffer
+	m := newMigrator(e.source, e.open(t, cfg.Users), e.open(t, cfg.Menu), e.open(t, cfg.Orders), cfg.Batch, &out)
+	ok, err := m.execute(ctx, cfg)
+	return out.String(), ok, err
+}
+
+func ids[T model](t *testing.T, db *gorm.DB) []uint {
+	t.Helper()
+	var rows []T
+	if err := db.Unscoped().Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	for _, row := range rows {
+		ids = append(ids, row.key())
+	}
+	return ids
+}
+
+func TestMigrateCopiesEveryTableWithItsIDs(t *testing.T) {
+	e := newEnv(t)
+	out, ok, err := e.run(t, context.Background(), e.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("verify failed:\n%s", out)
+	}
+	for _, table := range []string{"users: OK: 4 rows", "menu_items: OK: 3 rows", "orders: OK: 3 rows", "order_items: OK: 4 rows"} {
+		if !strings.Contains(out, table) {
+			t.Errorf("output lacks %q:\n%s", table, out)
+		}
+	}
+
+	users := e.open(t, e.cfg.Users)
+	if got := ids[User](t, users); !reflect.DeepEqual(got, []uint{1, 3, 4, 5}) {
+		t.Errorf("user IDs = %v", got)
+	}
+	var deleted User
+	if err := users.Unscoped().First(&deleted, 4).Error; err != nil || !deleted.DeletedAt.Valid {
+		t.Errorf("soft-deleted user 4 = %+v, %v", deleted, err)
+	}
+	orders := e.open(t, e.cfg.Orders)
+	var order Order
+	if err := orders.Preload("OrderItems").First(&order, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.UserID != 1 || len(order.OrderItems) != 2 || order.OrderItems[1].Price != 3.25 {
+		t.Errorf("order 1 = %+v", order)
+	}
+
+	// The services can go on inserting without reusing a copied ID.
+	next := User{Name: "New", Email: "new@example.com"}
+	if err := users.Create(&next).Error; err != nil {
+		t.Fatal(err)
+	}
+	if next.ID <= 5 {
+		t.Errorf("new user got ID %d, which the monolith used", next.ID)
+	}
+}
+
+func TestMigrateResumesFromCheckpoint(t *testing.T) {
+	e := newEnv(t)
+
+	// Interrupt the first run once users and the first menu batch are in.
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	m := newMigrator(e.source, e.open(t, e.cfg.Users), e.open(t, e.cfg.Menu), e.open(t, e.cfg.Orders), e.cfg.Batch, &out)
+	m.afterBatch = func(table string, lastID uint) {
+		if table == "menu_items" {
+			cancel()
+		}
+	}
+	if _, err := m.execute(ctx, e.cfg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted run: err = %v", err)
+	}
+	cp, err := loadCheckpoint(e.cfg.Checkpoint, fingerprint(e.cfg.Source, e.cfg.Users, e.cfg.Menu, e.cfg.Orders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]uint{"users": 5, "menu_items": 2}; !reflect.DeepEqual(cp.LastID, want) {
+		t.Fatalf("checkpoint = %v, want %v", cp.LastID, want)
+	}
+
+	// The second run starts after the checkpoint rather than from scratch.
+	var batches []string
+	m = newMigrator(e.source, e.open(t, e.cfg.Users), e.open(t, e.cfg.Menu), e.open(t, e.cfg.Orders), e.cfg.Batch, &out)
+	m.afterBatch = func(table string, lastID uint) { batches = append(batches, table) }
+	ok, err := m.execute(context.Background(), e.cfg)
+	if err != nil || !ok {
+		t.Fatalf("resumed run: ok = %v, err = %v\n%s", ok, err, out.String())
+	}
+	want := []string{"menu_items", "orders", "orders", "order_items", "order_items"}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("resumed run copied batches %v, want %v", batches, want)
+	}
+
+	// Rows added to the monolith later are copied by the next run.
+	if err := e.source.Create(&User{Name: "Late", Email: "late@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	out2, ok, err := e.run(t, context.Background(), e.cfg)
+	if err != nil || !ok {
+		t.Fatalf("catch-up run: ok = %v, err = %v\n%s", ok, err, out2)
+	}
+	if !strings.Contains(out2, "users: copied 1 rows") {
+		t.Errorf("catch-up run:\n%s", out2)
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	e := newEnv(t)
+	cfg := e.cfg
+	cfg.DryRun = true
+	out, ok, err := e.run(t, context.Background(), cfg)
+	if err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	for _, line := range []string{
+		"users: would copy 4 rows (IDs 1-5) in 2 batches to user_db.users, which does not exist yet",
+		"order_items: would copy 4 rows (IDs 1-4) in 2 batches to order_db.order_items, which does not exist yet",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+	if _, err := os.Stat(cfg.Checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dry run wrote a checkpoint: %v", err)
+	}
+	for _, dsn := range []string{cfg.Users, cfg.Menu, cfg.Orders} {
+		if tables, _ := e.open(t, dsn).Migrator().GetTables(); len(tables) != 0 {
+			t.Errorf("dry run created %v in %s", tables, dsn)
+		}
+	}
+}
+
+func TestVerifyFindsDifferences(t *testing.T) {
+	e := newEnv(t)
+	if _, ok, err := e.run(t, context.Background(), e.cfg); err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+
+	if err := e.open(t, e.cfg.Menu).Model(&MenuItem{}).Where("id = ?", 2).Update("price", 1.9).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := e.open(t, e.cfg.Orders).Unscoped().Delete(&OrderItem{}, 4).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := e.cfg
+	cfg.VerifyOnly = true
+	out, ok, err := e.run(t, context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatalf("verify passed:\n%s", out)
+	}
+	for _, line := range []string{
+		"users: OK: 4 rows",
+		"menu_items: MISMATCH: 3 rows each, checksum",
+		"order_items: MISMATCH: 4 rows in the monolith, 3 in order_db",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+
+	// Copying every row again, ignoring the checkpoint, puts both right.
+	cfg = e.cfg
+	cfg.Restart = true
+	if out, ok, err := e.run(t, context.Background(), cfg); err != nil || !ok {
+		t.Fatalf("restarted run: ok = %v, err = %v\n%s", ok, err, out)
+	}
+}
+
+func TestCheckpointForOtherDatabasesIsRefused(t *testing.T) {
+	e := newEnv(t)
+	if _, _, err := e.run(t, context.Background(), e.cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg := e.cfg
+	cfg.Users = "sqlite:" + filepath.Join(t.TempDir(), "other_user_db.db")
+	if _, _, err := e.run(t, context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "-restart") {
+		t.Errorf("err = %v, want a hint to use -restart", err)
+	}
+}
+
+func TestChecksumIgnoresTimeZoneAndNanoseconds(t *testing.T) {
+	at := time.Date(2025, 3, 1, 9, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	a := MenuItem{Model: gorm.Model{ID: 1, CreatedAt: at, UpdatedAt: at}, Name: "Coffee", Price: 2.5}
+	b := a
+	b.CreatedAt = at.UTC().Truncate(time.Microsecond)
+	b.UpdatedAt = b.CreatedAt
+	if a.line() != b.line() {
+		t.Errorf("lines differ:\n%s\n%s", a.line(), b.line())
+	}
+	b.Price = 2.51
+	if a.line() == b.line() {
+		t.Error("a different price gave the same line")
+	}
+}
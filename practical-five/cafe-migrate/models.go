@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The models are the tables as the monolith and the services define them.
+// Each can describe itself as one checksum line, which is the same whichever
+// database the row was read from.
+
+type User struct {
+	gorm.Model
+	Name  string `json:"name"`
+	Email string `json:"email" gorm:"unique"`
+}
+
+type MenuItem struct {
+	gorm.Model
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type Order struct {
+	gorm.Model
+	UserID     uint        `json:"user_id"`
+	Status     string      `json:"status"`
+	OrderItems []OrderItem `json:"order_items" gorm:"foreignKey:OrderID"`
+}
+
+type OrderItem struct {
+	gorm.Model
+	OrderID    uint    `json:"order_id"`
+	MenuItemID uint    `json:"menu_item_id"`
+	Quantity   int     `json:"quantity"`
+	Price      float64 `json:"price"`
+}
+
+func (u User) key() uint { return u.ID }
+func (u User) line() string {
+	return fmt.Sprintf("%s|%q|%q", modelLine(u.Model), u.Name, u.Email)
+}
+
+func (m MenuItem) key() uint { return m.ID }
+func (m MenuItem) line() string {
+	return fmt.Sprintf("%s|%q|%q|%v", modelLine(m.Model), m.Name, m.Description, m.Price)
+}
+
+func (o Order) key() uint { return o.ID }
+func (o Order) line() string {
+	return fmt.Sprintf("%s|%d|%q", modelLine(o.Model), o.UserID, o.Status)
+}
+
+func (i OrderItem) key() uint { return i.ID }
+func (i OrderItem) line() string {
+	return fmt.Sprintf("%s|%d|%d|%d|%v", modelLine(i.Model), i.OrderID, i.MenuItemID, i.Quantity, i.Price)
+}
+
+// modelLine formats the gorm.Model columns. Times are compared in UTC to the
+// microsecond, as PostgreSQL stores them.
+func modelLine(m gorm.Model) string {
+	deleted := "-"
+	if m.DeletedAt.Valid {
+		deleted = timeLine(m.DeletedAt.Time)
+	}
+	return fmt.Sprintf("%d|%s|%s|%s", m.ID, timeLine(m.CreatedAt), timeLine(m.UpdatedAt), deleted)
+}
+
+func timeLine(t time.Time) string {
+	return t.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+}
@@ -1,44 +1,22 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"menu-service/database"
 	"menu-service/handlers"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"registration"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	consulapi "github.com/hashicorp/consul/api"
 )
 
-func registerWithConsul(serviceName string, port int) error {
-	config := consulapi.DefaultConfig()
-	config.Address = "consul:8500"
-
-	consul, err := consulapi.NewClient(config)
-	if err != nil {
-		return err
-	}
-
-	hostname, _ := os.Hostname()
-
-	registration := &consulapi.AgentServiceRegistration{
-		ID:      fmt.Sprintf("%s-%s", serviceName, hostname),
-		Name:    serviceName,
-		Port:    port,
-		Address: hostname,
-		Check: &consulapi.AgentServiceCheck{
-			HTTP:     fmt.Sprintf("http://%s:%d/health", hostname, port),
-			Interval: "10s",
-			Timeout:  "3s",
-		},
-	}
-
-	return consul.Agent().ServiceRegister(registration)
-}
-
 func main() {
 	// Connect to dedicated menu database
 	dsn := os.Getenv("DATABASE_URL")
@@ -63,16 +41,39 @@ func main() {
 	r.Post("/menu", handlers.CreateMenuItem)
 	r.Get("/menu/{id}", handlers.GetMenuItem)
 
-	// Register with Consul
-	if err := registerWithConsul("menu-service", 8082); err != nil {
-		log.Printf("Failed to register with Consul: %v", err)
-	}
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8082"
 	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on :%s: %v", port, err)
+	}
 
+	// Register the port actually listened on, whatever PORT says.
+	reg, err := registration.New(registration.FromEnv("menu-service", lis.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		log.Fatalf("Failed to set up Consul registration: %v", err)
+	}
+
+	srv := &http.Server{Handler: r}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
 	log.Printf("Menu service starting on :%s", port)
-	http.ListenAndServe(":"+port, r)
+
+	// Run returns on SIGINT or SIGTERM once the instance is deregistered, so
+	// no new requests are routed here while in-flight ones finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reg.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown failed: %v", err)
+	}
+	log.Printf("Menu service stopped")
 }
@@ -0,0 +1,180 @@
+// Package clients calls the services order-service depends on over HTTP.
+//
+// Calls take a context, time out, and are retried when the service could
+// not answer. Errors wrap ErrNotFound when the service says the thing asked
+// for does not exist and ErrUnavailable when the service could not be
+// reached or failed, so callers can tell a bad request from an outage.
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+var (
+	// ErrNotFound means the service answered 404.
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable means no instance of the service could be found or
+	// reached, or the service kept failing or timing out.
+	ErrUnavailable = errors.New("service unavailable")
+)
+
+// Resolver returns the base URL, such as http://10.0.0.5:8081, of an
+// instance of service.
+type Resolver func(ctx context.Context, service string) (string, error)
+
+// ConsulResolver resolves services to one of their instances that pass their
+// health checks, chosen at random so that retries spread over instances.
+func ConsulResolver(consul *consulapi.Client) Resolver {
+	return func(ctx context.Context, service string) (string, error) {
+		entries, _, err := consul.Health().Service(service, "", true, (&consulapi.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		if len(entries) == 0 {
+			return "", fmt.Errorf("no healthy instances of %s", service)
+		}
+		entry := entries[rand.IntN(len(entries))]
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		return fmt.Sprintf("http://%s:%d", host, entry.Service.Port), nil
+	}
+}
+
+// StaticResolver resolves every service to baseURL.
+func StaticResolver(baseURL string) Resolver {
+	return func(context.Context, string) (string, error) {
+		return baseURL, nil
+	}
+}
+
+// Config tunes a client. Zero fields take the defaults.
+type Config struct {
+	// HTTPClient sends the requests. Defaults to a client of its own so
+	// http.DefaultClient's lack of a timeout does not apply.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt. Defaults to 2s.
+	Timeout time.Duration
+	// Attempts is how many times a call is tried in all. Defaults to 3.
+	Attempts int
+	// Backoff is the wait before the first retry, doubling for each one
+	// after. Defaults to 100ms.
+	Backoff time.Duration
+}
+
+// client holds what UserClient and MenuClient share.
+type client struct {
+	service  string
+	resolve  Resolver
+	http     *http.Client
+	timeout  time.Duration
+	attempts int
+	backoff  time.Duration
+}
+
+func newClient(service string, resolve Resolver, cfg Config) client {
+	c := client{
+		service:  service,
+		resolve:  resolve,
+		http:     cfg.HTTPClient,
+		timeout:  cfg.Timeout,
+		attempts: cfg.Attempts,
+		backoff:  cfg.Backoff,
+	}
+	if c.http == nil {
+		c.http = &http.Client{}
+	}
+	if c.timeout <= 0 {
+		c.timeout = 2 * time.Second
+	}
+	if c.attempts <= 0 {
+		c.attempts = 3
+	}
+	if c.backoff <= 0 {
+		c.backoff = 100 * time.Millisecond
+	}
+	return c
+}
+
+// get fetches path from the service and decodes the JSON response into v,
+// retrying while the service is unavailable. It gives up early if ctx is
+// done.
+func (c client) get(ctx context.Context, path string, v any) error {
+	backoff := c.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.try(ctx, path, v)
+		if err == nil || !errors.Is(err, ErrUnavailable) || attempt == c.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", c.service, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// try makes one attempt at get.
+func (c client) try(ctx context.Context, path string, v any) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", c.service, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	base, err := c.resolve(ctx, c.service)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %v", c.service, ErrUnavailable, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.service, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return c.requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+	// Reading the body to the end lets the connection be reused.
+	defer io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: GET %s: %w", c.service, path, ErrNotFound)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%s: GET %s: %w: %s", c.service, path, ErrUnavailable, resp.Status)
+	default:
+		return fmt.Errorf("%s: GET %s: unexpected status %s", c.service, path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		if ctx.Err() != nil {
+			return c.requestError(ctx, err)
+		}
+		return fmt.Errorf("%s: GET %s: decoding response: %v", c.service, path, err)
+	}
+	return nil
+}
+
+// requestError classifies a failed request. The caller giving up is
+// reported as such; anything else, including the attempt timing out, means
+// the service is unavailable.
+func (c client) requestError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w", c.service, cause)
+	}
+	return fmt.Errorf("%s: %w: %v", c.service, ErrUnavailable, err)
+}
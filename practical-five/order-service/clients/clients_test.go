@@ -0,0 +1,223 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastConfig keeps retries quick in tests.
+var fastConfig = Config{Timeout: 200 * time.Millisecond, Attempts: 3, Backoff: time.Millisecond}
+
+// serve starts a server whose handler is called with the number of the
+// request, starting at 1, and returns a resolver for it and the request
+// count.
+func serve(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, n int32)) (Resolver, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, requests.Add(1))
+	}))
+	t.Cleanup(srv.Close)
+	return StaticResolver(srv.URL), &requests
+}
+
+func TestGetUser(t *testing.T) {
+	resolve, _ := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		if r.URL.Path != "/users/7" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ID":7,"name":"Ada","email":"ada@example.com"}`))
+	})
+	user, err := NewUserClient(resolve, fastConfig).GetUser(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user != (User{ID: 7, Name: "Ada", Email: "ada@example.com"}) {
+		t.Errorf("GetUser() = %+v", user)
+	}
+}
+
+func TestGetMenuItem(t *testing.T) {
+	resolve, _ := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		if r.URL.Path != "/menu/3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"ID":3,"name":"Coffee","description":"Hot","price":2.5}`))
+	})
+	item, err := NewMenuClient(resolve, fastConfig).GetMenuItem(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *item != (MenuItem{ID: 3, Name: "Coffee", Description: "Hot", Price: 2.5}) {
+		t.Errorf("GetMenuItem() = %+v", item)
+	}
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+	})
+	_, err := NewMenuClient(resolve, fastConfig).GetMenuItem(context.Background(), 99)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestServerErrorsAreRetried(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		if n < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ID":1,"name":"Ada"}`))
+	})
+	if _, err := NewUserClient(resolve, fastConfig).GetUser(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+}
+
+func TestUnavailableAfterAllAttempts(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	_, err := NewUserClient(resolve, fastConfig).GetUser(context.Background(), 1)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+}
+
+func TestSlowServiceTimesOut(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	cfg := fastConfig
+	cfg.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := NewUserClient(resolve, cfg).GetUser(context.Background(), 1)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+}
+
+func TestUnreachableServiceIsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	_, err := NewUserClient(StaticResolver(srv.URL), fastConfig).GetUser(context.Background(), 1)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestResolverFailureIsUnavailable(t *testing.T) {
+	resolve := func(context.Context, string) (string, error) {
+		return "", errors.New("no healthy instances of menu-service")
+	}
+	_, err := NewMenuClient(resolve, fastConfig).GetMenuItem(context.Background(), 1)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestBadJSONIsAnErrorButNotRetried(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		w.Write([]byte(`{"price": "free"}`))
+	})
+	_, err := NewMenuClient(resolve, fastConfig).GetMenuItem(context.Background(), 1)
+	if err == nil {
+		t.Fatal("decoded a bad price")
+	}
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want neither ErrUnavailable nor ErrNotFound", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestOtherClientErrorsAreNotRetried(t *testing.T) {
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		http.Error(w, "bad id", http.StatusBadRequest)
+	})
+	_, err := NewUserClient(resolve, fastConfig).GetUser(context.Background(), 1)
+	if err == nil || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want a plain error", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestCanceledCallStopsRetrying(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	resolve, requests := serve(t, func(w http.ResponseWriter, r *http.Request, n int32) {
+		cancel()
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	})
+	cfg := fastConfig
+	cfg.Backoff = time.Second
+	_, err := NewUserClient(resolve, cfg).GetUser(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestResponseBodiesAreClosed(t *testing.T) {
+	// Each response is fully read and closed, so one kept-alive connection
+	// serves every request.
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/menu/1" {
+			http.Error(w, "Menu item not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"ID":2,"price":1}` + "\n\n"))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	menu := NewMenuClient(StaticResolver(srv.URL), fastConfig)
+	for i := 0; i < 5; i++ {
+		menu.GetMenuItem(context.Background(), 1)
+		if _, err := menu.GetMenuItem(context.Background(), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("opened %d connections, want 1", n)
+	}
+}
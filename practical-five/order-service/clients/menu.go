@@ -0,0 +1,32 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+)
+
+// MenuItem is a menu item as menu-service returns it.
+type MenuItem struct {
+	ID          uint    `json:"ID"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+// MenuClient calls menu-service.
+type MenuClient struct {
+	client
+}
+
+func NewMenuClient(resolve Resolver, cfg Config) *MenuClient {
+	return &MenuClient{newClient("menu-service", resolve, cfg)}
+}
+
+// GetMenuItem returns the menu item with the given ID.
+func (c *MenuClient) GetMenuItem(ctx context.Context, id uint) (*MenuItem, error) {
+	var item MenuItem
+	if err := c.get(ctx, fmt.Sprintf("/menu/%d", id), &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
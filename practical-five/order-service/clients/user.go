@@ -0,0 +1,31 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+)
+
+// User is a user as user-service returns it.
+type User struct {
+	ID    uint   `json:"ID"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserClient calls user-service.
+type UserClient struct {
+	client
+}
+
+func NewUserClient(resolve Resolver, cfg Config) *UserClient {
+	return &UserClient{newClient("user-service", resolve, cfg)}
+}
+
+// GetUser returns the user with the given ID.
+func (c *UserClient) GetUser(ctx context.Context, id uint) (*User, error) {
+	var user User
+	if err := c.get(ctx, fmt.Sprintf("/users/%d", id), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
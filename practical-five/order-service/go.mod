@@ -7,6 +7,7 @@ require (
 	github.com/hashicorp/consul/api v1.25.1
 	gorm.io/driver/postgres v1.5.4
 	gorm.io/gorm v1.25.5
+	registration v0.0.0
 )
 
 require (
@@ -32,3 +33,5 @@ require (
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
+
+replace registration => ../registration
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"order-service/clients"
 	"order-service/database"
 	"order-service/models"
-
-	consulapi "github.com/hashicorp/consul/api"
 )
 
 type CreateOrderRequest struct {
@@ -18,92 +20,69 @@ type CreateOrderRequest struct {
 	} `json:"items"`
 }
 
-func discoverService(serviceName string) (string, error) {
-	config := consulapi.DefaultConfig()
-	config.Address = "consul:8500"
-
-	consul, err := consulapi.NewClient(config)
-	if err != nil {
-		return "", err
-	}
-
-	services, _, err := consul.Health().Service(serviceName, "", true, nil)
-	if err != nil {
-		return "", err
-	}
-
-	if len(services) == 0 {
-		return "", fmt.Errorf("no healthy instances of %s", serviceName)
-	}
-
-	service := services[0].Service
-	return fmt.Sprintf("http://%s:%d", service.Address, service.Port), nil
-}
-
-func CreateOrder(w http.ResponseWriter, r *http.Request) {
-	var req CreateOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Discover and call user-service to validate user exists
-	userServiceURL, err := discoverService("user-service")
-	if err != nil {
-		http.Error(w, "User service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-
-	userResp, err := http.Get(fmt.Sprintf("%s/users/%d", userServiceURL, req.UserID))
-	if err != nil || userResp.StatusCode != http.StatusOK {
-		http.Error(w, "User not found", http.StatusBadRequest)
-		return
-	}
-	userResp.Body.Close()
-
-	// Create order
-	order := models.Order{
-		UserID: req.UserID,
-		Status: "pending",
-	}
-
-	// Discover and validate each menu item by calling menu-service
-	menuServiceURL, err := discoverService("menu-service")
-	if err != nil {
-		http.Error(w, "Menu service unavailable", http.StatusServiceUnavailable)
-		return
-	}
+// CreateOrder checks the user exists with user-service and snapshots each
+// item's price from menu-service before saving the order.
+func CreateOrder(users *clients.UserClient, menu *clients.MenuClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateOrderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	for _, item := range req.Items {
-		// Get menu item to snapshot price
-		menuResp, err := http.Get(fmt.Sprintf("%s/menu/%d", menuServiceURL, item.MenuItemID))
-		if err != nil || menuResp.StatusCode != http.StatusOK {
-			http.Error(w, "Menu item not found", http.StatusBadRequest)
+		if _, err := users.GetUser(r.Context(), req.UserID); err != nil {
+			serviceError(w, err, "User not found", "User service unavailable")
 			return
 		}
 
-		var menuItem struct {
-			Price float64 `json:"price"`
+		// Create order
+		order := models.Order{
+			UserID: req.UserID,
+			Status: "pending",
 		}
-		json.NewDecoder(menuResp.Body).Decode(&menuItem)
-		menuResp.Body.Close()
 
-		orderItem := models.OrderItem{
-			MenuItemID: item.MenuItemID,
-			Quantity:   item.Quantity,
-			Price:      menuItem.Price,
+		for _, item := range req.Items {
+			// Get menu item to snapshot price
+			menuItem, err := menu.GetMenuItem(r.Context(), item.MenuItemID)
+			if err != nil {
+				serviceError(w, err, fmt.Sprintf("Menu item %d not found", item.MenuItemID), "Menu service unavailable")
+				return
+			}
+
+			orderItem := models.OrderItem{
+				MenuItemID: item.MenuItemID,
+				Quantity:   item.Quantity,
+				Price:      menuItem.Price,
+			}
+			order.OrderItems = append(order.OrderItems, orderItem)
 		}
-		order.OrderItems = append(order.OrderItems, orderItem)
-	}
 
-	if err := database.DB.Create(&order).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if err := database.DB.Create(&order).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(order)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(order)
+// serviceError answers a request that failed because a call to another
+// service did.
+func serviceError(w http.ResponseWriter, err error, notFound, unavailable string) {
+	switch {
+	case errors.Is(err, clients.ErrNotFound):
+		http.Error(w, notFound, http.StatusBadRequest)
+	case errors.Is(err, clients.ErrUnavailable):
+		log.Printf("Order not created: %v", err)
+		http.Error(w, unavailable, http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		// The client went away; there is nobody to answer.
+	default:
+		log.Printf("Order not created: %v", err)
+		http.Error(w, "Bad response from a dependency", http.StatusBadGateway)
+	}
 }
 
 func GetOrders(w http.ResponseWriter, r *http.Request) {
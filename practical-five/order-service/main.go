@@ -1,44 +1,24 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"order-service/clients"
 	"order-service/database"
 	"order-service/handlers"
 	"os"
+	"os/signal"
+	"registration"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	consulapi "github.com/hashicorp/consul/api"
 )
 
-func registerWithConsul(serviceName string, port int) error {
-	config := consulapi.DefaultConfig()
-	config.Address = "consul:8500"
-
-	consul, err := consulapi.NewClient(config)
-	if err != nil {
-		return err
-	}
-
-	hostname, _ := os.Hostname()
-
-	registration := &consulapi.AgentServiceRegistration{
-		ID:      fmt.Sprintf("%s-%s", serviceName, hostname),
-		Name:    serviceName,
-		Port:    port,
-		Address: hostname,
-		Check: &consulapi.AgentServiceCheck{
-			HTTP:     fmt.Sprintf("http://%s:%d/health", hostname, port),
-			Interval: "10s",
-			Timeout:  "3s",
-		},
-	}
-
-	return consul.Agent().ServiceRegister(registration)
-}
-
 func main() {
 	// Connect to dedicated order database
 	dsn := os.Getenv("DATABASE_URL")
@@ -50,6 +30,19 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Find user-service and menu-service instances through Consul.
+	consulConfig := consulapi.DefaultConfig()
+	if os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		consulConfig.Address = "consul:8500"
+	}
+	consul, err := consulapi.NewClient(consulConfig)
+	if err != nil {
+		log.Fatalf("Failed to create Consul client: %v", err)
+	}
+	resolve := clients.ConsulResolver(consul)
+	users := clients.NewUserClient(resolve, clients.Config{})
+	menu := clients.NewMenuClient(resolve, clients.Config{})
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
@@ -59,19 +52,42 @@ func main() {
 	})
 
 	// Order endpoints
-	r.Post("/orders", handlers.CreateOrder)
+	r.Post("/orders", handlers.CreateOrder(users, menu))
 	r.Get("/orders", handlers.GetOrders)
 
-	// Register with Consul
-	if err := registerWithConsul("order-service", 8083); err != nil {
-		log.Printf("Failed to register with Consul: %v", err)
-	}
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8083"
 	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on :%s: %v", port, err)
+	}
 
+	// Register the port actually listened on, whatever PORT says.
+	reg, err := registration.New(registration.FromEnv("order-service", lis.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		log.Fatalf("Failed to set up Consul registration: %v", err)
+	}
+
+	srv := &http.Server{Handler: r}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
 	log.Printf("Order service starting on :%s", port)
-	http.ListenAndServe(":"+port, r)
+
+	// Run returns on SIGINT or SIGTERM once the instance is deregistered, so
+	// no new requests are routed here while in-flight ones finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reg.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown failed: %v", err)
+	}
+	log.Printf("Order service stopped")
 }
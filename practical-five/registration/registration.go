@@ -0,0 +1,201 @@
+// Package registration keeps a service instance registered with Consul for
+// as long as it runs.
+package registration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Config describes the instance to register.
+type Config struct {
+	// ConsulAddr is the Consul agent's host:port.
+	ConsulAddr string
+	// Service is the name other services look the instance up by.
+	Service string
+	// ID tells this instance apart from the service's others. Defaults to
+	// <service>-<address>-<port>.
+	ID string
+	// Address is the host other services reach the instance at. Defaults to
+	// the host name.
+	Address string
+	// Port is the port the instance actually listens on.
+	Port int
+	Tags []string
+	// Meta is free-form metadata such as "version" and "zone".
+	Meta map[string]string
+	// HealthPath is the path Consul checks over HTTP. Defaults to /health.
+	HealthPath string
+	// CheckInterval and CheckTimeout pace the health check. DeregisterAfter
+	// removes an instance whose check has failed that long, e.g. one that was
+	// killed without deregistering.
+	CheckInterval   time.Duration
+	CheckTimeout    time.Duration
+	DeregisterAfter time.Duration
+	// ResyncInterval is how often Run makes sure Consul still knows the
+	// instance, registering it again if not.
+	ResyncInterval time.Duration
+}
+
+// FromEnv returns the Config for service listening on port, read from:
+//
+//	CONSUL_HTTP_ADDR   Consul agent host:port (default consul:8500)
+//	SERVICE_ADDRESS    host other services reach this one at (default: host name)
+//	SERVICE_TAGS       comma-separated tags
+//	SERVICE_VERSION    version metadata
+//	SERVICE_ZONE       zone metadata
+func FromEnv(service string, port int) Config {
+	cfg := Config{
+		ConsulAddr: os.Getenv("CONSUL_HTTP_ADDR"),
+		Service:    service,
+		Address:    os.Getenv("SERVICE_ADDRESS"),
+		Port:       port,
+		Meta:       make(map[string]string),
+	}
+	if cfg.ConsulAddr == "" {
+		cfg.ConsulAddr = "consul:8500"
+	}
+	for _, tag := range strings.Split(os.Getenv("SERVICE_TAGS"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			cfg.Tags = append(cfg.Tags, tag)
+		}
+	}
+	if version := os.Getenv("SERVICE_VERSION"); version != "" {
+		cfg.Meta["version"] = version
+	}
+	if zone := os.Getenv("SERVICE_ZONE"); zone != "" {
+		cfg.Meta["zone"] = zone
+	}
+	return cfg
+}
+
+// Registration is one instance's registration with Consul.
+type Registration struct {
+	consul *consulapi.Client
+	reg    *consulapi.AgentServiceRegistration
+	resync time.Duration
+}
+
+// New prepares the registration cfg describes. Nothing is sent to Consul
+// until Run.
+func New(cfg Config) (*Registration, error) {
+	if cfg.Service == "" || cfg.Port <= 0 {
+		return nil, fmt.Errorf("registration: service name and port are required")
+	}
+	if cfg.Address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("registration: %v", err)
+		}
+		cfg.Address = hostname
+	}
+	if cfg.ID == "" {
+		cfg.ID = fmt.Sprintf("%s-%s-%d", cfg.Service, cfg.Address, cfg.Port)
+	}
+	if cfg.HealthPath == "" {
+		cfg.HealthPath = "/health"
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 10 * time.Second
+	}
+	if cfg.CheckTimeout <= 0 {
+		cfg.CheckTimeout = 3 * time.Second
+	}
+	if cfg.DeregisterAfter <= 0 {
+		cfg.DeregisterAfter = time.Minute
+	}
+	if cfg.ResyncInterval <= 0 {
+		cfg.ResyncInterval = 15 * time.Second
+	}
+
+	config := consulapi.DefaultConfig()
+	config.Address = cfg.ConsulAddr
+	consul, err := consulapi.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Registration{
+		consul: consul,
+		resync: cfg.ResyncInterval,
+		reg: &consulapi.AgentServiceRegistration{
+			ID:      cfg.ID,
+			Name:    cfg.Service,
+			Address: cfg.Address,
+			Port:    cfg.Port,
+			Tags:    cfg.Tags,
+			Meta:    cfg.Meta,
+			Check: &consulapi.AgentServiceCheck{
+				HTTP:                           fmt.Sprintf("http://%s:%d%s", cfg.Address, cfg.Port, cfg.HealthPath),
+				Interval:                       cfg.CheckInterval.String(),
+				Timeout:                        cfg.CheckTimeout.String(),
+				DeregisterCriticalServiceAfter: cfg.DeregisterAfter.String(),
+			},
+		},
+	}, nil
+}
+
+// ID returns the instance's service ID in Consul.
+func (r *Registration) ID() string {
+	return r.reg.ID
+}
+
+// Run registers the instance and keeps it registered until ctx is done, then
+// deregisters it. Consul being unreachable is logged and retried, so a
+// service can start before Consul does or outlive a Consul restart.
+func (r *Registration) Run(ctx context.Context) {
+	registered := false
+	ticker := time.NewTicker(r.resync)
+	defer ticker.Stop()
+	for {
+		if !registered || !r.known(ctx) {
+			if err := r.register(ctx); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to register %s with Consul: %v", r.reg.ID, err)
+				}
+			} else {
+				if registered {
+					log.Printf("Registered %s with Consul again", r.reg.ID)
+				} else {
+					log.Printf("Registered %s with Consul", r.reg.ID)
+				}
+				registered = true
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if registered {
+				r.deregister()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Registration) register(ctx context.Context) error {
+	opts := consulapi.ServiceRegisterOpts{}.WithContext(ctx)
+	return r.consul.Agent().ServiceRegisterOpts(r.reg, opts)
+}
+
+// known reports whether the agent still has the instance. An agent that
+// restarted in dev mode, or lost its data, has forgotten it.
+func (r *Registration) known(ctx context.Context) bool {
+	service, _, err := r.consul.Agent().Service(r.reg.ID, (&consulapi.QueryOptions{}).WithContext(ctx))
+	return err == nil && service != nil
+}
+
+func (r *Registration) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.consul.Agent().ServiceDeregisterOpts(r.reg.ID, (&consulapi.QueryOptions{}).WithContext(ctx)); err != nil {
+		log.Printf("Failed to deregister %s from Consul: %v", r.reg.ID, err)
+		return
+	}
+	log.Printf("Deregistered %s from Consul", r.reg.ID)
+}
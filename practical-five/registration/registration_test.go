@@ -0,0 +1,236 @@
+package registration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// fakeConsul implements the agent endpoints Registration uses.
+type fakeConsul struct {
+	mu       sync.Mutex
+	services map[string]consulapi.AgentServiceRegistration
+	// down makes every request fail, as when Consul is not running.
+	down          bool
+	registrations int
+	deregistered  []string
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, string) {
+	f := &fakeConsul{services: make(map[string]consulapi.AgentServiceRegistration)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/agent/service/register", func(w http.ResponseWriter, r *http.Request) {
+		var reg consulapi.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.services[reg.ID] = reg
+		f.registrations++
+	})
+	mux.HandleFunc("GET /v1/agent/service/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		reg, ok := f.services[r.PathValue("id")]
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "unknown service ID", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(consulapi.AgentService{ID: reg.ID, Service: reg.Name, Port: reg.Port})
+	})
+	mux.HandleFunc("PUT /v1/agent/service/deregister/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.services, r.PathValue("id"))
+		f.deregistered = append(f.deregistered, r.PathValue("id"))
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		down := f.down
+		f.mu.Unlock()
+		if down {
+			http.Error(w, "consul is down", http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv.Listener.Addr().String()
+}
+
+func (f *fakeConsul) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+// forget drops every registration, as a Consul agent restarted in dev mode
+// would.
+func (f *fakeConsul) forget() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.services = make(map[string]consulapi.AgentServiceRegistration)
+}
+
+func (f *fakeConsul) service(id string) (consulapi.AgentServiceRegistration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reg, ok := f.services[id]
+	return reg, ok
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// start runs a registration for cfg and returns a func that stops it and
+// waits for Run to return.
+func start(t *testing.T, cfg Config) (*Registration, func()) {
+	t.Helper()
+	reg, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reg.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return reg, stop
+}
+
+func TestRunRegistersAndDeregisters(t *testing.T) {
+	fake, addr := newFakeConsul(t)
+	reg, stop := start(t, Config{
+		ConsulAddr: addr,
+		Service:    "menu-service",
+		Address:    "menu-1",
+		Port:       9082,
+		Tags:       []string{"http"},
+		Meta:       map[string]string{"version": "1.4.0", "zone": "a"},
+	})
+	if reg.ID() != "menu-service-menu-1-9082" {
+		t.Errorf("ID() = %q", reg.ID())
+	}
+
+	eventually(t, "registered", func() bool { _, ok := fake.service(reg.ID()); return ok })
+	got, _ := fake.service(reg.ID())
+	if got.Name != "menu-service" || got.Address != "menu-1" || got.Port != 9082 {
+		t.Errorf("registered %s at %s:%d, want menu-service at menu-1:9082", got.Name, got.Address, got.Port)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"http"}) {
+		t.Errorf("tags = %v", got.Tags)
+	}
+	if !reflect.DeepEqual(got.Meta, map[string]string{"version": "1.4.0", "zone": "a"}) {
+		t.Errorf("meta = %v", got.Meta)
+	}
+	if got.Check == nil || got.Check.HTTP != "http://menu-1:9082/health" || got.Check.DeregisterCriticalServiceAfter != "1m0s" {
+		t.Errorf("check = %+v", got.Check)
+	}
+
+	stop()
+	if _, ok := fake.service(reg.ID()); ok {
+		t.Error("still registered after shutdown")
+	}
+	if !reflect.DeepEqual(fake.deregistered, []string{reg.ID()}) {
+		t.Errorf("deregistered %v", fake.deregistered)
+	}
+}
+
+func TestRunRegistersAgainAfterConsulRestart(t *testing.T) {
+	fake, addr := newFakeConsul(t)
+	reg, _ := start(t, Config{ConsulAddr: addr, Service: "user-service", Address: "user-1", Port: 8081, ResyncInterval: 10 * time.Millisecond})
+	eventually(t, "registered", func() bool { _, ok := fake.service(reg.ID()); return ok })
+
+	fake.forget()
+	eventually(t, "registered again", func() bool { _, ok := fake.service(reg.ID()); return ok })
+}
+
+func TestRunRetriesUntilConsulIsUp(t *testing.T) {
+	fake, addr := newFakeConsul(t)
+	fake.setDown(true)
+	reg, stop := start(t, Config{ConsulAddr: addr, Service: "order-service", Address: "order-1", Port: 8083, ResyncInterval: 10 * time.Millisecond})
+
+	time.Sleep(50 * time.Millisecond)
+	fake.setDown(false)
+	eventually(t, "registered", func() bool { _, ok := fake.service(reg.ID()); return ok })
+
+	// Registering is only repeated when Consul has lost the instance.
+	fake.mu.Lock()
+	before := fake.registrations
+	fake.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	fake.mu.Lock()
+	after := fake.registrations
+	fake.mu.Unlock()
+	if after != before {
+		t.Errorf("registered %d more times while Consul knew the instance", after-before)
+	}
+	stop()
+}
+
+func TestShutdownWithoutRegisteringDoesNotDeregister(t *testing.T) {
+	fake, addr := newFakeConsul(t)
+	fake.setDown(true)
+	_, stop := start(t, Config{ConsulAddr: addr, Service: "order-service", Address: "order-1", Port: 8083})
+	stop()
+	if len(fake.deregistered) != 0 {
+		t.Errorf("deregistered %v", fake.deregistered)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("CONSUL_HTTP_ADDR", "consul.internal:8500")
+	t.Setenv("SERVICE_ADDRESS", "menu-2")
+	t.Setenv("SERVICE_TAGS", "http, v2,")
+	t.Setenv("SERVICE_VERSION", "2.0.1")
+	t.Setenv("SERVICE_ZONE", "zone-b")
+
+	want := Config{
+		ConsulAddr: "consul.internal:8500",
+		Service:    "menu-service",
+		Address:    "menu-2",
+		Port:       9000,
+		Tags:       []string{"http", "v2"},
+		Meta:       map[string]string{"version": "2.0.1", "zone": "zone-b"},
+	}
+	if got := FromEnv("menu-service", 9000); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromEnv() = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("CONSUL_HTTP_ADDR", "")
+	if got := FromEnv("menu-service", 9000).ConsulAddr; got != "consul:8500" {
+		t.Errorf("default Consul address = %q", got)
+	}
+}
+
+func TestNewRequiresServiceAndPort(t *testing.T) {
+	if _, err := New(Config{Service: "menu-service"}); err == nil {
+		t.Error("New without a port succeeded")
+	}
+	if _, err := New(Config{Port: 8082}); err == nil {
+		t.Error("New without a service succeeded")
+	}
+}
@@ -4,14 +4,15 @@ go 1.23
 
 require (
 	github.com/go-chi/chi/v5 v5.0.11
-	github.com/hashicorp/consul/api v1.25.1
 	gorm.io/driver/postgres v1.5.4
 	gorm.io/gorm v1.25.5
+	registration v0.0.0
 )
 
 require (
 	github.com/armon/go-metrics v0.4.1 // indirect
 	github.com/fatih/color v1.14.1 // indirect
+	github.com/hashicorp/consul/api v1.25.1 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-hclog v1.5.0 // indirect
 	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
@@ -32,3 +33,5 @@ require (
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
+
+replace registration => ../registration
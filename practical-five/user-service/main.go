@@ -1,44 +1,22 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"registration"
+	"syscall"
+	"time"
 	"user-service/database"
 	"user-service/handlers"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	consulapi "github.com/hashicorp/consul/api"
 )
 
-func registerWithConsul(serviceName string, port int) error {
-	config := consulapi.DefaultConfig()
-	config.Address = "consul:8500"
-
-	consul, err := consulapi.NewClient(config)
-	if err != nil {
-		return err
-	}
-
-	hostname, _ := os.Hostname()
-
-	registration := &consulapi.AgentServiceRegistration{
-		ID:      fmt.Sprintf("%s-%s", serviceName, hostname),
-		Name:    serviceName,
-		Port:    port,
-		Address: hostname,
-		Check: &consulapi.AgentServiceCheck{
-			HTTP:     fmt.Sprintf("http://%s:%d/health", hostname, port),
-			Interval: "10s",
-			Timeout:  "3s",
-		},
-	}
-
-	return consul.Agent().ServiceRegister(registration)
-}
-
 func main() {
 	// Connect to dedicated user database
 	dsn := os.Getenv("DATABASE_URL")
@@ -62,16 +40,39 @@ func main() {
 	r.Post("/users", handlers.CreateUser)
 	r.Get("/users/{id}", handlers.GetUser)
 
-	// Register with Consul
-	if err := registerWithConsul("user-service", 8081); err != nil {
-		log.Printf("Failed to register with Consul: %v", err)
-	}
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on :%s: %v", port, err)
+	}
 
+	// Register the port actually listened on, whatever PORT says.
+	reg, err := registration.New(registration.FromEnv("user-service", lis.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		log.Fatalf("Failed to set up Consul registration: %v", err)
+	}
+
+	srv := &http.Server{Handler: r}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
 	log.Printf("User service starting on :%s", port)
-	http.ListenAndServe(":"+port, r)
+
+	// Run returns on SIGINT or SIGTERM once the instance is deregistered, so
+	// no new requests are routed here while in-flight ones finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reg.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown failed: %v", err)
+	}
+	log.Printf("User service stopped")
 }
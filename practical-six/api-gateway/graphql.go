@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/graphql-go/graphql"
+	"github.com/practical6/pkg/dataloader"
+	menuv1 "github.com/practical6/proto/menu/v1"
+	orderv1 "github.com/practical6/proto/order/v1"
+	userv1 "github.com/practical6/proto/user/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The GraphQL API reads users, menus and orders through the same gRPC
+// clients as the REST API. Object fields are resolved from the proto
+// messages by name; only the fields that cross services have resolvers, and
+// those go through per-request loaders so that a list of orders costs one
+// GetUsers and one GetMenuItems call per cafe, however many orders it holds.
+
+// loaders batch the lookups of one GraphQL request.
+type loaders struct {
+	users     *dataloader.Loader[uint32, *userv1.User]
+	menuItems *dataloader.Loader[menuItemKey, *menuv1.MenuItem]
+}
+
+type menuItemKey struct {
+	cafeID uint32
+	id     uint32
+}
+
+type loadersKey struct{}
+
+func newLoaders(users userv1.UserServiceClient, menu menuv1.MenuServiceClient) *loaders {
+	return &loaders{
+		users: dataloader.New(func(ctx context.Context, ids []uint32) (map[uint32]*userv1.User, error) {
+			resp, err := users.GetUsers(ctx, &userv1.GetUsersRequest{Ids: ids})
+			if err != nil {
+				return nil, rpcError(err)
+			}
+			found := make(map[uint32]*userv1.User, len(resp.Users))
+			for _, user := range resp.Users {
+				found[user.Id] = user
+			}
+			return found, nil
+		}),
+		menuItems: dataloader.New(func(ctx context.Context, keys []menuItemKey) (map[menuItemKey]*menuv1.MenuItem, error) {
+			byCafe := make(map[uint32][]uint32)
+			for _, key := range keys {
+				byCafe[key.cafeID] = append(byCafe[key.cafeID], key.id)
+			}
+			found := make(map[menuItemKey]*menuv1.MenuItem, len(keys))
+			for cafeID, ids := range byCafe {
+				resp, err := menu.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{CafeId: cafeID, Ids: ids})
+				if err != nil {
+					return nil, rpcError(err)
+				}
+				for _, item := range resp.MenuItems {
+					found[menuItemKey{cafeID, item.Id}] = item
+				}
+			}
+			return found, nil
+		}),
+	}
+}
+
+// withLoaders gives every GraphQL request its own loaders, so nothing one
+// request fetched is served to another.
+func withLoaders(users userv1.UserServiceClient, menu menuv1.MenuServiceClient) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, loadersKey{}, newLoaders(users, menu))
+	}
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// orderItem is an order line as the schema sees it. Unlike the proto message
+// it knows its order's cafe, which its menu item has to be looked up in.
+type orderItem struct {
+	ID           uint32
+	MenuItemID   uint32
+	MenuItemName string
+	Quantity     uint32
+	Price        float64
+	cafeID       uint32
+}
+
+// rpcError turns a backend error into one fit to show a GraphQL client.
+func rpcError(err error) error {
+	return errors.New(status.Convert(err).Message())
+}
+
+// notFoundIsNull resolves to null instead of an error when the backend has
+// no such record.
+func notFoundIsNull(err error) (interface{}, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	return nil, rpcError(err)
+}
+
+func idArg(p graphql.ResolveParams, name string) uint32 {
+	return uint32(p.Args[name].(int))
+}
+
+var (
+	nonNullInt     = graphql.NewNonNull(graphql.Int)
+	nonNullString  = graphql.NewNonNull(graphql.String)
+	nonNullFloat   = graphql.NewNonNull(graphql.Float)
+	nonNullBoolean = graphql.NewNonNull(graphql.Boolean)
+)
+
+func newGraphQLSchema(users userv1.UserServiceClient, menu menuv1.MenuServiceClient, orders orderv1.OrderServiceClient) (graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":             &graphql.Field{Type: nonNullInt},
+			"name":           &graphql.Field{Type: nonNullString},
+			"email":          &graphql.Field{Type: nonNullString},
+			"isCafeOwner":    &graphql.Field{Type: nonNullBoolean},
+			"managedCafeIds": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(nonNullInt))},
+		},
+	})
+
+	menuItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MenuItem",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: nonNullInt},
+			"cafeId":      &graphql.Field{Type: nonNullInt},
+			"name":        &graphql.Field{Type: nonNullString},
+			"description": &graphql.Field{Type: nonNullString},
+			"price": &graphql.Field{
+				Type:        nonNullFloat,
+				Description: "Price in effect now.",
+			},
+			"available": &graphql.Field{
+				Type:        nonNullBoolean,
+				Description: "Whether the item can be ordered now.",
+			},
+		},
+	})
+
+	orderItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderItem",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: nonNullInt},
+			"menuItemId":   &graphql.Field{Type: nonNullInt},
+			"menuItemName": &graphql.Field{Type: nonNullString},
+			"quantity":     &graphql.Field{Type: nonNullInt},
+			"price": &graphql.Field{
+				Type:        nonNullFloat,
+				Description: "Unit price when the order was placed.",
+			},
+			"menuItem": &graphql.Field{
+				Type:        menuItemType,
+				Description: "The menu item as it is now; null if it has been removed.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					item := p.Source.(orderItem)
+					thunk := loadersFrom(p.Context).menuItems.Load(p.Context, menuItemKey{item.cafeID, item.MenuItemID})
+					return func() (interface{}, error) { return thunk() }, nil
+				},
+			},
+		},
+	})
+
+	orderType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: nonNullInt},
+			"cafeId": &graphql.Field{Type: nonNullInt},
+			"userId": &graphql.Field{Type: nonNullInt},
+			"status": &graphql.Field{Type: nonNullString},
+			"items": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderItemType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					order := p.Source.(*orderv1.Order)
+					items := make([]orderItem, len(order.OrderItems))
+					for i, item := range order.OrderItems {
+						items[i] = orderItem{
+							ID:           item.Id,
+							MenuItemID:   item.MenuItemId,
+							MenuItemName: item.MenuItemName,
+							Quantity:     item.Quantity,
+							Price:        item.Price,
+							cafeID:       order.CafeId,
+						}
+					}
+					return items, nil
+				},
+			},
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "The user who placed the order; null if they no longer exist.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					thunk := loadersFrom(p.Context).users.Load(p.Context, p.Source.(*orderv1.Order).UserId)
+					return func() (interface{}, error) { return thunk() }, nil
+				},
+			},
+		},
+	})
+
+	idArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{Type: nonNullInt},
+	}
+	cafeArgs := graphql.FieldConfigArgument{
+		"cafeId": &graphql.ArgumentConfig{Type: nonNullInt},
+	}
+	cafeIDArgs := graphql.FieldConfigArgument{
+		"cafeId": &graphql.ArgumentConfig{Type: nonNullInt},
+		"id":     &graphql.ArgumentConfig{Type: nonNullInt},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: idArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					thunk := loadersFrom(p.Context).users.Load(p.Context, idArg(p, "id"))
+					return func() (interface{}, error) { return thunk() }, nil
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					resp, err := users.GetUsers(p.Context, &userv1.GetUsersRequest{})
+					if err != nil {
+						return nil, rpcError(err)
+					}
+					return resp.Users, nil
+				},
+			},
+			"menuItem": &graphql.Field{
+				Type: menuItemType,
+				Args: cafeIDArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					thunk := loadersFrom(p.Context).menuItems.Load(p.Context, menuItemKey{idArg(p, "cafeId"), idArg(p, "id")})
+					return func() (interface{}, error) { return thunk() }, nil
+				},
+			},
+			"menuItems": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(menuItemType))),
+				Args: cafeArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					resp, err := menu.GetMenuItems(p.Context, &menuv1.GetMenuItemsRequest{CafeId: idArg(p, "cafeId")})
+					if err != nil {
+						return nil, rpcError(err)
+					}
+					return resp.MenuItems, nil
+				},
+			},
+			"order": &graphql.Field{
+				Type: orderType,
+				Args: cafeIDArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					resp, err := orders.GetOrder(p.Context, &orderv1.GetOrderRequest{CafeId: idArg(p, "cafeId"), Id: idArg(p, "id")})
+					if err != nil {
+						return notFoundIsNull(err)
+					}
+					return resp.Order, nil
+				},
+			},
+			"orders": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderType))),
+				Args: cafeArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					resp, err := orders.GetOrders(p.Context, &orderv1.GetOrdersRequest{CafeId: idArg(p, "cafeId")})
+					if err != nil {
+						return nil, rpcError(err)
+					}
+					return resp.Orders, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
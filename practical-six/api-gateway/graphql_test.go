@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	menuv1 "github.com/practical6/proto/menu/v1"
+	orderv1 "github.com/practical6/proto/order/v1"
+	userv1 "github.com/practical6/proto/user/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// calls records the requests a fake client was sent, by method.
+type calls struct {
+	mu       sync.Mutex
+	requests map[string][]proto.Message
+}
+
+func (c *calls) record(method string, req proto.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.requests == nil {
+		c.requests = make(map[string][]proto.Message)
+	}
+	c.requests[method] = append(c.requests[method], req)
+}
+
+func (c *calls) get(method string) []proto.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[method]
+}
+
+// The fake clients serve the methods the schema uses from fixed data.
+// Embedding the interface makes any other method panic.
+
+type countingUsers struct {
+	userv1.UserServiceClient
+	calls
+	users map[uint32]*userv1.User
+}
+
+func (c *countingUsers) GetUsers(_ context.Context, req *userv1.GetUsersRequest, _ ...grpc.CallOption) (*userv1.GetUsersResponse, error) {
+	c.record("GetUsers", req)
+	resp := &userv1.GetUsersResponse{}
+	for _, id := range req.Ids {
+		if user, ok := c.users[id]; ok {
+			resp.Users = append(resp.Users, user)
+		}
+	}
+	return resp, nil
+}
+
+type countingMenu struct {
+	menuv1.MenuServiceClient
+	calls
+	items map[uint32]*menuv1.MenuItem
+}
+
+func (c *countingMenu) GetMenuItems(_ context.Context, req *menuv1.GetMenuItemsRequest, _ ...grpc.CallOption) (*menuv1.GetMenuItemsResponse, error) {
+	c.record("GetMenuItems", req)
+	resp := &menuv1.GetMenuItemsResponse{}
+	for _, id := range req.Ids {
+		if item, ok := c.items[id]; ok && item.CafeId == req.CafeId {
+			resp.MenuItems = append(resp.MenuItems, item)
+		}
+	}
+	return resp, nil
+}
+
+type countingOrders struct {
+	orderv1.OrderServiceClient
+	calls
+	orders []*orderv1.Order
+}
+
+func (c *countingOrders) GetOrder(_ context.Context, req *orderv1.GetOrderRequest, _ ...grpc.CallOption) (*orderv1.GetOrderResponse, error) {
+	c.record("GetOrder", req)
+	for _, order := range c.orders {
+		if order.Id == req.Id && order.CafeId == req.CafeId {
+			return &orderv1.GetOrderResponse{Order: order}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "order not found")
+}
+
+func (c *countingOrders) GetOrders(_ context.Context, req *orderv1.GetOrdersRequest, _ ...grpc.CallOption) (*orderv1.GetOrdersResponse, error) {
+	c.record("GetOrders", req)
+	resp := &orderv1.GetOrdersResponse{}
+	for _, order := range c.orders {
+		if order.CafeId == req.CafeId {
+			resp.Orders = append(resp.Orders, order)
+		}
+	}
+	return resp, nil
+}
+
+func newCountingClients() (*countingUsers, *countingMenu, *countingOrders) {
+	users := &countingUsers{users: map[uint32]*userv1.User{
+		1: {Id: 1, Name: "Ada", Email: "ada@example.com", IsCafeOwner: true, ManagedCafeIds: []uint32{7}},
+		2: {Id: 2, Name: "Bob", Email: "bob@example.com"},
+	}}
+	menu := &countingMenu{items: map[uint32]*menuv1.MenuItem{
+		3: {Id: 3, CafeId: 7, Name: "Latte", Description: "Espresso with milk", Price: 3.5, Available: true},
+		4: {Id: 4, CafeId: 7, Name: "Scone", Price: 2.25},
+	}}
+	orders := &countingOrders{orders: []*orderv1.Order{
+		{Id: 10, CafeId: 7, UserId: 1, Status: "pending", OrderItems: []*orderv1.OrderItem{
+			{Id: 100, MenuItemId: 3, MenuItemName: "Latte", Quantity: 2, Price: 3.0},
+		}},
+		{Id: 11, CafeId: 7, UserId: 2, Status: "completed", OrderItems: []*orderv1.OrderItem{
+			{Id: 101, MenuItemId: 3, MenuItemName: "Latte", Quantity: 1, Price: 3.5},
+			{Id: 102, MenuItemId: 4, MenuItemName: "Scone", Quantity: 1, Price: 2.25},
+		}},
+		// User 3 and menu item 5 no longer exist.
+		{Id: 12, CafeId: 7, UserId: 3, Status: "pending", OrderItems: []*orderv1.OrderItem{
+			{Id: 103, MenuItemId: 5, MenuItemName: "Muffin", Quantity: 1, Price: 2.0},
+		}},
+		{Id: 13, CafeId: 7, UserId: 1, Status: "pending", OrderItems: []*orderv1.OrderItem{
+			{Id: 104, MenuItemId: 4, MenuItemName: "Scone", Quantity: 3, Price: 2.25},
+		}},
+	}}
+	return users, menu, orders
+}
+
+// runQuery executes query against the real schema, with loaders as
+// graphqlhttp would set them up for a request, and returns the result as
+// JSON.
+func runQuery(t *testing.T, users *countingUsers, menu *countingMenu, orders *countingOrders, query string) string {
+	t.Helper()
+	schema, err := newGraphQLSchema(users, menu, orders)
+	require.NoError(t, err)
+
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: query,
+		Context:       withLoaders(users, menu)(context.Background()),
+	})
+	require.Empty(t, result.Errors)
+	data, err := json.Marshal(result.Data)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestGraphQLFieldsMapFromProtos(t *testing.T) {
+	t.Parallel()
+
+	users, menu, orders := newCountingClients()
+	got := runQuery(t, users, menu, orders, `{
+		user(id: 1) { id name email isCafeOwner managedCafeIds }
+		menuItem(cafeId: 7, id: 3) { id cafeId name description price available }
+		order(cafeId: 7, id: 10) {
+			id cafeId userId status
+			items { id menuItemId menuItemName quantity price }
+		}
+		missing: order(cafeId: 7, id: 99) { id }
+	}`)
+
+	assert.JSONEq(t, `{
+		"user": {"id": 1, "name": "Ada", "email": "ada@example.com", "isCafeOwner": true, "managedCafeIds": [7]},
+		"menuItem": {"id": 3, "cafeId": 7, "name": "Latte", "description": "Espresso with milk", "price": 3.5, "available": true},
+		"order": {
+			"id": 10, "cafeId": 7, "userId": 1, "status": "pending",
+			"items": [{"id": 100, "menuItemId": 3, "menuItemName": "Latte", "quantity": 2, "price": 3}]
+		},
+		"missing": null
+	}`, got)
+}
+
+func TestGraphQLOrderListBatchesLookups(t *testing.T) {
+	t.Parallel()
+
+	users, menu, orders := newCountingClients()
+	got := runQuery(t, users, menu, orders, `{
+		orders(cafeId: 7) {
+			id
+			user { name }
+			items { menuItem { name price } }
+		}
+	}`)
+
+	assert.JSONEq(t, `{"orders": [
+		{"id": 10, "user": {"name": "Ada"}, "items": [{"menuItem": {"name": "Latte", "price": 3.5}}]},
+		{"id": 11, "user": {"name": "Bob"}, "items": [
+			{"menuItem": {"name": "Latte", "price": 3.5}},
+			{"menuItem": {"name": "Scone", "price": 2.25}}
+		]},
+		{"id": 12, "user": null, "items": [{"menuItem": null}]},
+		{"id": 13, "user": {"name": "Ada"}, "items": [{"menuItem": {"name": "Scone", "price": 2.25}}]}
+	]}`, got)
+
+	// Four orders, but one call for all their users and one for all their
+	// menu items, each asking for every distinct ID once.
+	assert.Len(t, orders.get("GetOrders"), 1)
+	userCalls := users.get("GetUsers")
+	require.Len(t, userCalls, 1)
+	assert.ElementsMatch(t, []uint32{1, 2, 3}, userCalls[0].(*userv1.GetUsersRequest).Ids)
+	menuCalls := menu.get("GetMenuItems")
+	require.Len(t, menuCalls, 1)
+	assert.Equal(t, uint32(7), menuCalls[0].(*menuv1.GetMenuItemsRequest).CafeId)
+	assert.ElementsMatch(t, []uint32{3, 4, 5}, menuCalls[0].(*menuv1.GetMenuItemsRequest).Ids)
+}
+
+func TestGraphQLFieldsNotAskedForAreNotFetched(t *testing.T) {
+	t.Parallel()
+
+	users, menu, orders := newCountingClients()
+	runQuery(t, users, menu, orders, `{ orders(cafeId: 7) { id status items { quantity } } }`)
+
+	assert.Len(t, orders.get("GetOrders"), 1)
+	assert.Empty(t, users.get("GetUsers"))
+	assert.Empty(t, menu.get("GetMenuItems"))
+}
@@ -1,362 +1,411 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/practical6/pkg/apispec"
+	"github.com/practical6/pkg/config"
+	"github.com/practical6/pkg/discovery"
+	"github.com/practical6/pkg/graphqlhttp"
+	"github.com/practical6/pkg/healthcheck"
+	"github.com/practical6/pkg/httpcache"
+	"github.com/practical6/pkg/lb"
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/metrics"
+	"github.com/practical6/pkg/mtls"
+	"github.com/practical6/pkg/ratelimit"
+	"github.com/practical6/pkg/rpcclient"
+	"github.com/practical6/pkg/serve"
+	"github.com/practical6/pkg/tracing"
 	menuv1 "github.com/practical6/proto/menu/v1"
+	"github.com/practical6/proto/openapi"
 	orderv1 "github.com/practical6/proto/order/v1"
 	userv1 "github.com/practical6/proto/user/v1"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
-	userClient  userv1.UserServiceClient
-	menuClient  menuv1.MenuServiceClient
-	orderClient orderv1.OrderServiceClient
+	// defaultCafeID is the cafe served by the unscoped /api/menu and
+	// /api/orders routes.
+	defaultCafeID uint32
+
+	// readiness checks the backend services for /readyz.
+	readiness *healthcheck.Checker
 )
 
+// menuCacheControl lets browsers keep menu responses but makes them check
+// back every time, so a new item shows up straight away while an unchanged
+// menu costs a 304 instead of the whole list.
+const menuCacheControl = "public, no-cache"
+
+type Config struct {
+	Port             int                `yaml:"port" env:"PORT" flag:"port" usage:"HTTP port" required:"true"`
+	MetricsPort      int                `yaml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" usage:"admin port to serve /metrics and /loglevel on" required:"true"`
+	DefaultCafeID    uint32             `yaml:"default_cafe_id" env:"DEFAULT_CAFE_ID" flag:"default-cafe-id" usage:"cafe served by /api/menu and /api/orders" required:"true"`
+	UserServiceAddr  string             `yaml:"user_service_addr" env:"USER_SERVICE_ADDR" flag:"user-service-addr" usage:"user-service host:port, dns:///host:port for every address it resolves to, consul:///user-service for its healthy instances in Consul, or a comma-separated list" required:"true"`
+	MenuServiceAddr  string             `yaml:"menu_service_addr" env:"MENU_SERVICE_ADDR" flag:"menu-service-addr" usage:"menu-service host:port, dns:///host:port, consul:///menu-service or a comma-separated list" required:"true"`
+	OrderServiceAddr string             `yaml:"order_service_addr" env:"ORDER_SERVICE_ADDR" flag:"order-service-addr" usage:"order-service host:port, dns:///host:port, consul:///order-service or a comma-separated list" required:"true"`
+	RPCTimeout       time.Duration      `yaml:"rpc_timeout" env:"RPC_TIMEOUT" flag:"rpc-timeout" usage:"deadline for each call to a backend service, retries included"`
+	ShutdownTimeout  time.Duration      `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to drain in-flight requests on shutdown"`
+	LogLevel         string             `yaml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error; PUT /loglevel on the admin port changes it at runtime"`
+	Tracing          tracing.Config     `yaml:"tracing"`
+	RateLimit        ratelimit.Config   `yaml:"rate_limit"`
+	GraphQL          graphqlhttp.Limits `yaml:"graphql"`
+	TLS              mtls.Config        `yaml:"tls"`
+	LoadBalancing    lb.Config          `yaml:"load_balancing"`
+	Discovery        discovery.Config   `yaml:"discovery"`
+	MaxBodySize      int64              `yaml:"max_body_size" env:"MAX_BODY_SIZE" flag:"max-body-size" usage:"largest REST request body in bytes; bigger ones get 413"`
+}
+
+func (c Config) Validate() error {
+	return c.LoadBalancing.Validate()
+}
+
 func main() {
+	cfg := Config{
+		Port:            8080,
+		MetricsPort:     9090,
+		DefaultCafeID:   1,
+		RPCTimeout:      5 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		LogLevel:        "info",
+		Tracing:         tracing.DefaultConfig(),
+		RateLimit:       ratelimit.DefaultConfig(),
+		GraphQL:         graphqlhttp.DefaultLimits(),
+		TLS:             mtls.DefaultConfig(),
+		LoadBalancing:   lb.DefaultConfig(),
+		Discovery:       discovery.DefaultConfig(),
+		MaxBodySize:     apispec.DefaultMaxBodySize,
+	}
+	logLevel := logging.Setup("api-gateway")
+	config.MustLoad("api-gateway", &cfg)
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		logging.Fatal("Invalid log level", "err", err)
+	}
+	defaultCafeID = cfg.DefaultCafeID
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, "api-gateway", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "err", err)
+		}
+	}()
+	tracerProvider := otel.GetTracerProvider()
+
+	creds, err := mtls.Load(cfg.TLS)
+	if err != nil {
+		logging.Fatal("Failed to load TLS certificates", "err", err)
+	}
+	go creds.Run(ctx)
+
+	serviceRegistry, err := discovery.New(cfg.Discovery)
+	if err != nil {
+		logging.Fatal("Failed to set up service discovery", "err", err)
+	}
+
+	rpcConfig := rpcclient.DefaultConfig()
+	rpcConfig.Timeout = cfg.RPCTimeout
+	userUpstream := rpcclient.New("user-service", rpcConfig)
+	menuUpstream := rpcclient.New("menu-service", rpcConfig)
+	orderUpstream := rpcclient.New("order-service", rpcConfig)
+
+	registry := metrics.NewRegistry()
+	registry.MustRegister(rpcclient.Collector(userUpstream, menuUpstream, orderUpstream))
+	recorder := metrics.NewRecorder(registry)
+
 	// Connect to user service
 	userConn, err := grpc.Dial(
-		getEnv("USER_SERVICE_ADDR", "localhost:50051"),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		lb.Target(cfg.UserServiceAddr),
+		grpc.WithTransportCredentials(creds.Client()),
+		cfg.LoadBalancing.DialOption(userv1.UserService_ServiceDesc.ServiceName),
+		discovery.DialOption(serviceRegistry),
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor(), recorder.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(logging.StreamClientInterceptor(), recorder.StreamClientInterceptor()),
+		userUpstream.DialOption(),
+		tracing.DialOption(tracerProvider),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to user service: %v", err)
+		logging.Fatal("Failed to connect to user service", "err", err)
 	}
 	defer userConn.Close()
-	userClient = userv1.NewUserServiceClient(userConn)
 
 	// Connect to menu service
 	menuConn, err := grpc.Dial(
-		getEnv("MENU_SERVICE_ADDR", "localhost:50052"),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		lb.Target(cfg.MenuServiceAddr),
+		grpc.WithTransportCredentials(creds.Client()),
+		cfg.LoadBalancing.DialOption(menuv1.MenuService_ServiceDesc.ServiceName),
+		discovery.DialOption(serviceRegistry),
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor(), recorder.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(logging.StreamClientInterceptor(), recorder.StreamClientInterceptor()),
+		menuUpstream.DialOption(),
+		tracing.DialOption(tracerProvider),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to menu service: %v", err)
+		logging.Fatal("Failed to connect to menu service", "err", err)
 	}
 	defer menuConn.Close()
-	menuClient = menuv1.NewMenuServiceClient(menuConn)
 
 	// Connect to order service
 	orderConn, err := grpc.Dial(
-		getEnv("ORDER_SERVICE_ADDR", "localhost:50053"),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		lb.Target(cfg.OrderServiceAddr),
+		grpc.WithTransportCredentials(creds.Client()),
+		cfg.LoadBalancing.DialOption(orderv1.OrderService_ServiceDesc.ServiceName),
+		discovery.DialOption(serviceRegistry),
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor(), recorder.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(logging.StreamClientInterceptor(), recorder.StreamClientInterceptor()),
+		orderUpstream.DialOption(),
+		tracing.DialOption(tracerProvider),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to order service: %v", err)
+		logging.Fatal("Failed to connect to order service", "err", err)
 	}
 	defer orderConn.Close()
-	orderClient = orderv1.NewOrderServiceClient(orderConn)
-
-	router := mux.NewRouter()
-
-	// User endpoints
-	router.HandleFunc("/api/users", createUserHandler).Methods("POST")
-	router.HandleFunc("/api/users/{id}", getUserHandler).Methods("GET")
-	router.HandleFunc("/api/users", getUsersHandler).Methods("GET")
 
-	// Menu endpoints
-	router.HandleFunc("/api/menu", createMenuItemHandler).Methods("POST")
-	router.HandleFunc("/api/menu/{id}", getMenuItemHandler).Methods("GET")
-	router.HandleFunc("/api/menu", getMenuItemsHandler).Methods("GET")
+	readiness = healthcheck.NewChecker(nil)
+	readiness.Add("user-service", healthcheck.GRPC(userConn, userv1.UserService_ServiceDesc.ServiceName))
+	readiness.Add("menu-service", healthcheck.GRPC(menuConn, menuv1.MenuService_ServiceDesc.ServiceName))
+	readiness.Add("order-service", healthcheck.GRPC(orderConn, orderv1.OrderService_ServiceDesc.ServiceName))
 
-	// Order endpoints
-	router.HandleFunc("/api/orders", createOrderHandler).Methods("POST")
-	router.HandleFunc("/api/orders/{id}", getOrderHandler).Methods("GET")
-	router.HandleFunc("/api/orders", getOrdersHandler).Methods("GET")
+	// The gateway does not authenticate callers yet, so clients are limited
+	// by IP address; set limiter.User once requests carry a verified user.
+	limiter := ratelimit.New(cfg.RateLimit, ratelimit.NewMemoryStore())
+	// GraphQL has no mutations yet, so a POST to it is as much a read as a GET.
+	limiter.ReadRoutes = []string{"/graphql"}
 
-	port := getEnv("PORT", "8080")
-	log.Printf("API Gateway listening on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
-}
-
-// User handlers
-func createUserHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name        string `json:"name"`
-		Email       string `json:"email"`
-		IsCafeOwner bool   `json:"is_cafe_owner"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware(tracerProvider), logging.Middleware, recorder.Middleware, limiter.Middleware)
+	// Router.Use middleware only runs for matched routes, so requests that
+	// match none are logged and counted here, under route "unmatched".
+	router.NotFoundHandler = logging.Middleware(recorder.Middleware(http.NotFoundHandler()))
+	router.MethodNotAllowedHandler = logging.Middleware(recorder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})))
 
-	resp, err := userClient.CreateUser(r.Context(), &userv1.CreateUserRequest{
-		Name:        req.Name,
-		Email:       req.Email,
-		IsCafeOwner: req.IsCafeOwner,
-	})
+	// Health endpoints
+	router.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET")
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	router.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":            resp.User.Id,
-		"name":          resp.User.Name,
-		"email":         resp.User.Email,
-		"is_cafe_owner": resp.User.IsCafeOwner,
-	})
-}
+	userClient := userv1.NewUserServiceClient(userConn)
+	menuClient := menuv1.NewMenuServiceClient(menuConn)
+	orderClient := orderv1.NewOrderServiceClient(orderConn)
 
-func getUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseUint(vars["id"], 10, 32)
+	schema, err := newGraphQLSchema(userClient, menuClient, orderClient)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+		logging.Fatal("Failed to build GraphQL schema", "err", err)
 	}
+	graphQL := graphqlhttp.New(schema, cfg.GraphQL)
+	graphQL.Context = withLoaders(userClient, menuClient)
+	router.Handle("/graphql", graphQL).Methods("GET", "POST")
 
-	resp, err := userClient.GetUser(r.Context(), &userv1.GetUserRequest{Id: uint32(id)})
+	gateway, err := newRESTGateway(ctx, userClient, menuClient, orderClient)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+		logging.Fatal("Failed to register REST routes", "err", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":            resp.User.Id,
-		"name":          resp.User.Name,
-		"email":         resp.User.Email,
-		"is_cafe_owner": resp.User.IsCafeOwner,
-	})
-}
-
-func getUsersHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := userClient.GetUsers(r.Context(), &userv1.GetUsersRequest{})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if err := routeAPI(router, gateway, openapi.Spec, cfg.MaxBodySize); err != nil {
+		logging.Fatal("Failed to route API", "err", err)
 	}
 
-	var users []map[string]interface{}
-	for _, user := range resp.Users {
-		users = append(users, map[string]interface{}{
-			"id":            user.Id,
-			"name":          user.Name,
-			"email":         user.Email,
-			"is_cafe_owner": user.IsCafeOwner,
-		})
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
-}
-
-// Menu handlers
-func createMenuItemHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name        string  `json:"name"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-	}
+	admin := http.NewServeMux()
+	admin.Handle("/metrics", metrics.Handler(registry))
+	admin.Handle("/loglevel", logging.LevelHandler(logLevel))
+	go func() {
+		if err := serve.HTTP(ctx, serve.AdminServer(cfg.MetricsPort, admin), cfg.ShutdownTimeout); err != nil {
+			slog.Error("Admin server failed", "err", err)
+		}
+	}()
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	slog.Info("API Gateway listening", "port", cfg.Port)
+	if err := serve.HTTP(ctx, srv, cfg.ShutdownTimeout); err != nil {
+		logging.Fatal("Failed to serve", "err", err)
 	}
+	slog.Info("API Gateway stopped")
+}
 
-	resp, err := menuClient.CreateMenuItem(r.Context(), &menuv1.CreateMenuItemRequest{
-		Name:        req.Name,
-		Description: req.Description,
-		Price:       req.Price,
-	})
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// Health handlers
 
+// healthzHandler reports that the gateway process is up. It does not look at
+// the backends, so a backend outage does not get the gateway restarted.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":          resp.MenuItem.Id,
-		"name":        resp.MenuItem.Name,
-		"description": resp.MenuItem.Description,
-		"price":       resp.MenuItem.Price,
-	})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-func getMenuItemHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseUint(vars["id"], 10, 32)
-	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+// readyzHandler reports whether every backend service is serving.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := readiness.Check(r.Context())
+
+	checks := make(map[string]string, len(report))
+	for _, name := range report.Names() {
+		checks[name] = "ok"
+		if err := report[name]; err != nil {
+			checks[name] = err.Error()
+		}
 	}
 
-	resp, err := menuClient.GetMenuItem(r.Context(), &menuv1.GetMenuItemRequest{Id: uint32(id)})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+	status, code := "ready", http.StatusOK
+	if !report.Healthy() {
+		status, code = "unavailable", http.StatusServiceUnavailable
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":          resp.MenuItem.Id,
-		"name":        resp.MenuItem.Name,
-		"description": resp.MenuItem.Description,
-		"price":       resp.MenuItem.Price,
+		"status": status,
+		"checks": checks,
 	})
 }
 
-func getMenuItemsHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := menuClient.GetMenuItems(r.Context(), &menuv1.GetMenuItemsRequest{})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var items []map[string]interface{}
-	for _, item := range resp.MenuItems {
-		items = append(items, map[string]interface{}{
-			"id":          item.Id,
-			"name":        item.Name,
-			"description": item.Description,
-			"price":       item.Price,
-		})
-	}
-
+// openAPIHandler serves the OpenAPI v2 spec generated from the protos. There
+// is no v3 document; see the README.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	w.Write(openapi.Spec)
 }
 
-// Order handlers
-func createOrderHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		UserID uint32 `json:"user_id"`
-		Items  []struct {
-			MenuItemID uint32 `json:"menu_item_id"`
-			Quantity   uint32 `json:"quantity"`
-		} `json:"items"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	var items []*orderv1.OrderItemRequest
-	for _, item := range req.Items {
-		items = append(items, &orderv1.OrderItemRequest{
-			MenuItemId: item.MenuItemID,
-			Quantity:   item.Quantity,
-		})
+// newRESTGateway transcodes the REST API to gRPC, as the google.api.http
+// annotations in the protos describe it.
+func newRESTGateway(ctx context.Context, users userv1.UserServiceClient, menu menuv1.MenuServiceClient, orders orderv1.OrderServiceClient) (*runtime.ServeMux, error) {
+	gateway := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+		}),
+		runtime.WithForwardResponseOption(createdStatus),
+		// Backend response metadata is none of the client's business.
+		runtime.WithOutgoingHeaderMatcher(func(string) (string, bool) { return "", false }),
+	)
+	if err := userv1.RegisterUserServiceHandlerClient(ctx, gateway, users); err != nil {
+		return nil, fmt.Errorf("user service: %w", err)
 	}
-
-	resp, err := orderClient.CreateOrder(r.Context(), &orderv1.CreateOrderRequest{
-		UserId: req.UserID,
-		Items:  items,
-	})
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if err := menuv1.RegisterMenuServiceHandlerClient(ctx, gateway, menu); err != nil {
+		return nil, fmt.Errorf("menu service: %w", err)
 	}
-
-	var orderItems []map[string]interface{}
-	for _, item := range resp.Order.OrderItems {
-		orderItems = append(orderItems, map[string]interface{}{
-			"id":             item.Id,
-			"menu_item_id":   item.MenuItemId,
-			"menu_item_name": item.MenuItemName,
-			"quantity":       item.Quantity,
-			"price":          item.Price,
-		})
+	if err := orderv1.RegisterOrderServiceHandlerClient(ctx, gateway, orders); err != nil {
+		return nil, fmt.Errorf("order service: %w", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":          resp.Order.Id,
-		"user_id":     resp.Order.UserId,
-		"status":      resp.Order.Status,
-		"order_items": orderItems,
-	})
+	return gateway, nil
 }
 
-func getOrderHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseUint(vars["id"], 10, 32)
+// routeAPI sends every operation in the OpenAPI spec to gateway through its
+// own router route, so the access log, metrics and rate limits see the route
+// template rather than one catch-all. The spec is generated from the same
+// annotations as gateway, so the two cannot disagree, and every request is
+// validated against it before it is transcoded.
+//
+// Before cafes existed the menu and orders lived at /api/menu and
+// /api/orders. Those routes still work and serve the default cafe.
+func routeAPI(router *mux.Router, gateway *runtime.ServeMux, spec []byte, maxBodySize int64) error {
+	api, err := apispec.Parse(spec)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+		return err
 	}
+	api.MaxBodySize = maxBodySize
 
-	resp, err := orderClient.GetOrder(r.Context(), &orderv1.GetOrderRequest{Id: uint32(id)})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
+	for _, op := range api.Operations() {
+		handler := validated(gateway, op)
+		if op.Method == http.MethodGet && slices.Contains(op.Tags, "MenuService") {
+			handler = httpcache.Handler(menuCacheControl, handler)
+		}
+		router.Handle(op.Path, handler).Methods(op.Method)
 
-	var orderItems []map[string]interface{}
-	for _, item := range resp.Order.OrderItems {
-		orderItems = append(orderItems, map[string]interface{}{
-			"id":             item.Id,
-			"menu_item_id":   item.MenuItemId,
-			"menu_item_name": item.MenuItemName,
-			"quantity":       item.Quantity,
-			"price":          item.Price,
-		})
+		rest, scoped := strings.CutPrefix(op.Path, "/api/cafes/{cafe_id}")
+		if scoped && (strings.HasPrefix(rest, "/menu") || strings.HasPrefix(rest, "/orders")) {
+			router.Handle("/api"+rest, defaultCafe(handler)).Methods(op.Method)
+		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":          resp.Order.Id,
-		"user_id":     resp.Order.UserId,
-		"status":      resp.Order.Status,
-		"order_items": orderItems,
-	})
+	return nil
 }
 
-func getOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := orderClient.GetOrders(r.Context(), &orderv1.GetOrdersRequest{})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// validated rejects requests that do not match op before gateway sees them.
+// Errors are written the way grpc-gateway writes backend errors; field
+// violations go in a google.rpc.BadRequest detail.
+func validated(gateway *runtime.ServeMux, op *apispec.Operation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := op.Validate(r, mux.Vars(r))
+		if err == nil {
+			gateway.ServeHTTP(w, r)
+			return
+		}
 
-	var orders []map[string]interface{}
-	for _, order := range resp.Orders {
-		var orderItems []map[string]interface{}
-		for _, item := range order.OrderItems {
-			orderItems = append(orderItems, map[string]interface{}{
-				"id":             item.Id,
-				"menu_item_id":   item.MenuItemId,
-				"menu_item_name": item.MenuItemName,
-				"quantity":       item.Quantity,
-				"price":          item.Price,
-			})
+		st := status.New(codes.InvalidArgument, err.Error())
+		var invalid *apispec.ValidationError
+		if errors.As(err, &invalid) {
+			badRequest := &errdetails.BadRequest{}
+			for _, v := range invalid.Violations {
+				badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+					Field:       v.Field,
+					Description: v.Description,
+				})
+			}
+			if detailed, detailErr := st.WithDetails(badRequest); detailErr == nil {
+				st = detailed
+			}
+		}
+		rpcErr := st.Err()
+		if errors.Is(err, apispec.ErrBodyTooLarge) {
+			rpcErr = &runtime.HTTPStatusError{HTTPStatus: http.StatusRequestEntityTooLarge, Err: rpcErr}
 		}
 
-		orders = append(orders, map[string]interface{}{
-			"id":          order.Id,
-			"user_id":     order.UserId,
-			"status":      order.Status,
-			"order_items": orderItems,
-		})
-	}
+		_, marshaler := runtime.MarshalerForRequest(gateway, r)
+		runtime.HTTPError(r.Context(), gateway, marshaler, w, r, rpcErr)
+	})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(orders)
+// defaultCafe serves an unscoped /api/menu or /api/orders request as the
+// default cafe's /api/cafes/{cafe_id} equivalent.
+func defaultCafe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scoped := new(http.Request)
+		*scoped = *r
+		scoped.URL = new(url.URL)
+		*scoped.URL = *r.URL
+		scoped.URL.Path = fmt.Sprintf("/api/cafes/%d", defaultCafeID) + strings.TrimPrefix(r.URL.Path, "/api")
+		scoped.URL.RawPath = ""
+		next.ServeHTTP(w, scoped)
+	})
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// createdStatus answers the Create RPCs with 201 Created instead of
+// grpc-gateway's 200 OK.
+func createdStatus(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	method, ok := runtime.RPCMethod(ctx)
+	if ok && strings.HasPrefix(method[strings.LastIndex(method, "/")+1:], "Create") {
+		w.WriteHeader(http.StatusCreated)
 	}
-	return defaultValue
+	return nil
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	menuv1 "github.com/practical6/proto/menu/v1"
+	"github.com/practical6/proto/openapi"
+	orderv1 "github.com/practical6/proto/order/v1"
+	userv1 "github.com/practical6/proto/user/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testCafeID = 7
+
+// The fake services answer with fixed data, and remember the last request so
+// tests can see what the gateway made of the REST call.
+
+type fakeUserService struct {
+	userv1.UnimplementedUserServiceServer
+	last proto.Message
+}
+
+var testUser = &userv1.User{Id: 1, Name: "Ada", Email: "ada@example.com", IsCafeOwner: true, ManagedCafeIds: []uint32{testCafeID}}
+
+var testCafe = &userv1.Cafe{Id: testCafeID, Name: "Corner", Location: "Main St", OwnerIds: []uint32{1}}
+
+func (s *fakeUserService) CreateUser(_ context.Context, req *userv1.CreateUserRequest) (*userv1.CreateUserResponse, error) {
+	s.last = req
+	return &userv1.CreateUserResponse{User: testUser}, nil
+}
+
+func (s *fakeUserService) GetUser(_ context.Context, req *userv1.GetUserRequest) (*userv1.GetUserResponse, error) {
+	s.last = req
+	if req.Id != testUser.Id {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return &userv1.GetUserResponse{User: testUser}, nil
+}
+
+func (s *fakeUserService) GetUsers(_ context.Context, req *userv1.GetUsersRequest) (*userv1.GetUsersResponse, error) {
+	s.last = req
+	return &userv1.GetUsersResponse{Users: []*userv1.User{testUser}}, nil
+}
+
+func (s *fakeUserService) CreateCafe(_ context.Context, req *userv1.CreateCafeRequest) (*userv1.CreateCafeResponse, error) {
+	s.last = req
+	return &userv1.CreateCafeResponse{Cafe: testCafe}, nil
+}
+
+func (s *fakeUserService) GetCafe(_ context.Context, req *userv1.GetCafeRequest) (*userv1.GetCafeResponse, error) {
+	s.last = req
+	return &userv1.GetCafeResponse{Cafe: testCafe}, nil
+}
+
+func (s *fakeUserService) GetCafes(_ context.Context, req *userv1.GetCafesRequest) (*userv1.GetCafesResponse, error) {
+	s.last = req
+	return &userv1.GetCafesResponse{Cafes: []*userv1.Cafe{testCafe}}, nil
+}
+
+type fakeMenuService struct {
+	menuv1.UnimplementedMenuServiceServer
+	last proto.Message
+}
+
+var testMenuItem = &menuv1.MenuItem{Id: 3, CafeId: testCafeID, Name: "Latte", Description: "Espresso with milk", Price: 3.5, Available: true}
+
+func (s *fakeMenuService) CreateMenuItem(_ context.Context, req *menuv1.CreateMenuItemRequest) (*menuv1.CreateMenuItemResponse, error) {
+	s.last = req
+	return &menuv1.CreateMenuItemResponse{MenuItem: testMenuItem}, nil
+}
+
+func (s *fakeMenuService) GetMenuItem(_ context.Context, req *menuv1.GetMenuItemRequest) (*menuv1.GetMenuItemResponse, error) {
+	s.last = req
+	return &menuv1.GetMenuItemResponse{MenuItem: testMenuItem}, nil
+}
+
+func (s *fakeMenuService) GetMenuItems(_ context.Context, req *menuv1.GetMenuItemsRequest) (*menuv1.GetMenuItemsResponse, error) {
+	s.last = req
+	return &menuv1.GetMenuItemsResponse{MenuItems: []*menuv1.MenuItem{testMenuItem}}, nil
+}
+
+type fakeOrderService struct {
+	orderv1.UnimplementedOrderServiceServer
+	last proto.Message
+}
+
+var testOrder = &orderv1.Order{
+	Id:     5,
+	CafeId: testCafeID,
+	UserId: 1,
+	Status: "pending",
+	OrderItems: []*orderv1.OrderItem{
+		{Id: 9, MenuItemId: 3, MenuItemName: "Latte", Quantity: 2, Price: 3.5},
+	},
+}
+
+func (s *fakeOrderService) CreateOrder(_ context.Context, req *orderv1.CreateOrderRequest) (*orderv1.CreateOrderResponse, error) {
+	s.last = req
+	return &orderv1.CreateOrderResponse{Order: testOrder}, nil
+}
+
+func (s *fakeOrderService) GetOrder(_ context.Context, req *orderv1.GetOrderRequest) (*orderv1.GetOrderResponse, error) {
+	s.last = req
+	return &orderv1.GetOrderResponse{Order: testOrder}, nil
+}
+
+func (s *fakeOrderService) GetOrders(_ context.Context, req *orderv1.GetOrdersRequest) (*orderv1.GetOrdersResponse, error) {
+	s.last = req
+	return &orderv1.GetOrdersResponse{Orders: []*orderv1.Order{testOrder}}, nil
+}
+
+// dialFake serves register on a bufconn listener and dials it.
+func dialFake(t *testing.T, register func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestAPI routes the REST API the way main does, to the fake services.
+func newTestAPI(t *testing.T) (http.Handler, *fakeUserService, *fakeMenuService, *fakeOrderService) {
+	t.Helper()
+	users, menu, orders := &fakeUserService{}, &fakeMenuService{}, &fakeOrderService{}
+	userConn := dialFake(t, func(s *grpc.Server) { userv1.RegisterUserServiceServer(s, users) })
+	menuConn := dialFake(t, func(s *grpc.Server) { menuv1.RegisterMenuServiceServer(s, menu) })
+	orderConn := dialFake(t, func(s *grpc.Server) { orderv1.RegisterOrderServiceServer(s, orders) })
+
+	gateway, err := newRESTGateway(context.Background(),
+		userv1.NewUserServiceClient(userConn),
+		menuv1.NewMenuServiceClient(menuConn),
+		orderv1.NewOrderServiceClient(orderConn),
+	)
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	require.NoError(t, routeAPI(router, gateway, openapi.Spec, 1<<20))
+	return router, users, menu, orders
+}
+
+// TestRESTCompatibility pins the REST API that clients of the monolith were
+// written against: the same paths, 201 on create, and the resource itself as
+// the body rather than the gRPC response wrapping it. protojson varies its
+// whitespace from build to build, so bodies are compacted before comparing;
+// everything else, field order included, must match exactly.
+func TestRESTCompatibility(t *testing.T) {
+	// defaultCafeID is global, so these subtests do not run in parallel with
+	// anything else that sets it.
+	defaultCafeID = testCafeID
+	api, users, menu, orders := newTestAPI(t)
+
+	const (
+		user     = `{"id":1,"name":"Ada","email":"ada@example.com","is_cafe_owner":true,"managed_cafe_ids":[7]}`
+		cafe     = `{"id":7,"name":"Corner","location":"Main St","owner_ids":[1]}`
+		menuItem = `{"id":3,"name":"Latte","description":"Espresso with milk","price":3.5,"availability":[],"price_changes":[],"available":true,"cafe_id":7}`
+		order    = `{"id":5,"user_id":1,"status":"pending","order_items":[{"id":9,"menu_item_id":3,"menu_item_name":"Latte","quantity":2,"price":3.5}],"cafe_id":7}`
+	)
+
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+		// backend reports the request the backend got, and want is what it
+		// should have been.
+		backend func() proto.Message
+		want    proto.Message
+	}{
+		{
+			method: "POST", path: "/api/users", body: `{"name":"Ada","email":"ada@example.com","is_cafe_owner":true}`,
+			wantStatus: http.StatusCreated, wantBody: user,
+			backend: func() proto.Message { return users.last }, want: &userv1.CreateUserRequest{Name: "Ada", Email: "ada@example.com", IsCafeOwner: true},
+		},
+		{
+			method: "GET", path: "/api/users/1",
+			wantStatus: http.StatusOK, wantBody: user,
+			backend: func() proto.Message { return users.last }, want: &userv1.GetUserRequest{Id: 1},
+		},
+		{
+			method: "GET", path: "/api/users",
+			wantStatus: http.StatusOK, wantBody: "[" + user + "]",
+		},
+		{
+			method: "POST", path: "/api/cafes", body: `{"name":"Corner","location":"Main St","owner_ids":[1]}`,
+			wantStatus: http.StatusCreated, wantBody: cafe,
+			backend: func() proto.Message { return users.last }, want: &userv1.CreateCafeRequest{Name: "Corner", Location: "Main St", OwnerIds: []uint32{1}},
+		},
+		{
+			method: "GET", path: "/api/cafes/7",
+			wantStatus: http.StatusOK, wantBody: cafe,
+		},
+		{
+			method: "GET", path: "/api/cafes",
+			wantStatus: http.StatusOK, wantBody: "[" + cafe + "]",
+		},
+		{
+			method: "POST", path: "/api/menu", body: `{"name":"Latte","description":"Espresso with milk","price":3.5}`,
+			wantStatus: http.StatusCreated, wantBody: menuItem,
+			backend: func() proto.Message { return menu.last }, want: &menuv1.CreateMenuItemRequest{Name: "Latte", Description: "Espresso with milk", Price: 3.5, CafeId: testCafeID},
+		},
+		{
+			method: "POST", path: "/api/cafes/7/menu", body: `{"name":"Latte","price":3.5}`,
+			wantStatus: http.StatusCreated, wantBody: menuItem,
+			backend: func() proto.Message { return menu.last }, want: &menuv1.CreateMenuItemRequest{Name: "Latte", Price: 3.5, CafeId: testCafeID},
+		},
+		{
+			method: "GET", path: "/api/menu/3",
+			wantStatus: http.StatusOK, wantBody: menuItem,
+			backend: func() proto.Message { return menu.last }, want: &menuv1.GetMenuItemRequest{Id: 3, CafeId: testCafeID},
+		},
+		{
+			method: "GET", path: "/api/menu",
+			wantStatus: http.StatusOK, wantBody: "[" + menuItem + "]",
+			backend: func() proto.Message { return menu.last }, want: &menuv1.GetMenuItemsRequest{CafeId: testCafeID},
+		},
+		{
+			method: "GET", path: "/api/menu?orderable_at=2024-01-01T09:00:00Z",
+			wantStatus: http.StatusOK, wantBody: "[" + menuItem + "]",
+			backend: func() proto.Message { return menu.last }, want: &menuv1.GetMenuItemsRequest{OrderableAt: timestamppb.New(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)), CafeId: testCafeID},
+		},
+		{
+			method: "POST", path: "/api/orders", body: `{"user_id":1,"items":[{"menu_item_id":3,"quantity":2}]}`,
+			wantStatus: http.StatusCreated, wantBody: order,
+			backend: func() proto.Message { return orders.last }, want: &orderv1.CreateOrderRequest{UserId: 1, Items: []*orderv1.OrderItemRequest{{MenuItemId: 3, Quantity: 2}}, CafeId: testCafeID},
+		},
+		{
+			method: "GET", path: "/api/orders/5",
+			wantStatus: http.StatusOK, wantBody: order,
+			backend: func() proto.Message { return orders.last }, want: &orderv1.GetOrderRequest{Id: 5, CafeId: testCafeID},
+		},
+		{
+			method: "GET", path: "/api/orders",
+			wantStatus: http.StatusOK, wantBody: "[" + order + "]",
+			backend: func() proto.Message { return orders.last }, want: &orderv1.GetOrdersRequest{CafeId: testCafeID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var body bytes.Buffer
+			require.NoError(t, json.Compact(&body, rec.Body.Bytes()), rec.Body.String())
+			assert.Equal(t, tt.wantBody, body.String())
+
+			if tt.backend != nil {
+				got := tt.backend()
+				assert.True(t, proto.Equal(tt.want, got), "backend got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRESTCompatibility_Errors(t *testing.T) {
+	defaultCafeID = testCafeID
+	api, _, _, _ := newTestAPI(t)
+
+	tests := []struct {
+		name, method, path, body string
+		wantStatus               int
+	}{
+		{name: "unknown user", method: "GET", path: "/api/users/2", wantStatus: http.StatusNotFound},
+		{name: "missing name", method: "POST", path: "/api/users", body: `{"email":"ada@example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "order without items", method: "POST", path: "/api/orders", body: `{"user_id":1,"items":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "body too large", method: "POST", path: "/api/menu", body: `{"name":"` + strings.Repeat("x", 1<<20) + `","price":1}`, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,13 @@
+-- IF NOT EXISTS lets databases created by the old AutoMigrate start out
+-- with this migration recorded as applied.
+CREATE TABLE IF NOT EXISTS menu_items (
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    name        TEXT NOT NULL,
+    description TEXT,
+    price       DECIMAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_menu_items_deleted_at ON menu_items (deleted_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS price_changes;
+DROP TABLE IF EXISTS availability_windows;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS availability_windows (
+    id           BIGSERIAL PRIMARY KEY,
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ,
+    deleted_at   TIMESTAMPTZ,
+    menu_item_id BIGINT NOT NULL,
+    days         SMALLINT,
+    start_minute BIGINT NOT NULL,
+    end_minute   BIGINT NOT NULL,
+    CONSTRAINT fk_menu_items_availability FOREIGN KEY (menu_item_id) REFERENCES menu_items (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_availability_windows_deleted_at ON availability_windows (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_availability_windows_menu_item_id ON availability_windows (menu_item_id);
+
+CREATE TABLE IF NOT EXISTS price_changes (
+    id             BIGSERIAL PRIMARY KEY,
+    created_at     TIMESTAMPTZ,
+    updated_at     TIMESTAMPTZ,
+    deleted_at     TIMESTAMPTZ,
+    menu_item_id   BIGINT NOT NULL,
+    price          DECIMAL NOT NULL,
+    effective_from TIMESTAMPTZ NOT NULL,
+    CONSTRAINT fk_menu_items_price_changes FOREIGN KEY (menu_item_id) REFERENCES menu_items (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_changes_deleted_at ON price_changes (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_price_changes_menu_item_id ON price_changes (menu_item_id);
+CREATE INDEX IF NOT EXISTS idx_price_changes_effective_from ON price_changes (effective_from);
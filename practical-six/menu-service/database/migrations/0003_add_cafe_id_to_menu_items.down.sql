@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_menu_items_cafe_id;
+ALTER TABLE menu_items DROP COLUMN IF EXISTS cafe_id;
@@ -0,0 +1,8 @@
+ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS cafe_id BIGINT;
+
+-- Items created before cafes existed belong to the default cafe, which the
+-- gateway serves on the legacy /api/menu routes (DEFAULT_CAFE_ID).
+UPDATE menu_items SET cafe_id = 1 WHERE cafe_id IS NULL;
+
+ALTER TABLE menu_items ALTER COLUMN cafe_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_menu_items_cafe_id ON menu_items (cafe_id);
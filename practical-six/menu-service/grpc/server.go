@@ -2,79 +2,272 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
 
-	"github.com/practical6/menu-service/database"
 	"github.com/practical6/menu-service/models"
+	"github.com/practical6/menu-service/repository"
 	"github.com/practical6/proto/menu/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type MenuServer struct {
 	menuv1.UnimplementedMenuServiceServer
+	repo repository.MenuRepository
+	// Location is the cafe's time zone, used to evaluate availability
+	// windows. Defaults to the local time zone.
+	Location *time.Location
 }
 
-func NewMenuServer() *MenuServer {
-	return &MenuServer{}
+func NewMenuServer(repo repository.MenuRepository) *MenuServer {
+	return &MenuServer{repo: repo, Location: time.Local}
 }
 
 func (s *MenuServer) CreateMenuItem(ctx context.Context, req *menuv1.CreateMenuItemRequest) (*menuv1.CreateMenuItemResponse, error) {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	windows, err := windowsFromProto(req.Availability)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	changes, err := priceChangesFromProto(req.PriceChanges)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
 	menuItem := models.MenuItem{
-		Name:        req.Name,
-		Description: req.Description,
-		Price:       req.Price,
+		CafeID:       uint(req.CafeId),
+		Name:         req.Name,
+		Description:  req.Description,
+		Price:        req.Price,
+		Availability: windows,
+		PriceChanges: changes,
 	}
 
-	result := database.DB.Create(&menuItem)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create menu item: %v", result.Error)
+	if err := s.repo.CreateMenuItem(ctx, &menuItem); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create menu item: %v", err)
 	}
 
 	return &menuv1.CreateMenuItemResponse{
-		MenuItem: &menuv1.MenuItem{
-			Id:          uint32(menuItem.ID),
-			Name:        menuItem.Name,
-			Description: menuItem.Description,
-			Price:       menuItem.Price,
-		},
+		MenuItem: s.toProto(menuItem, time.Now()),
 	}, nil
 }
 
 func (s *MenuServer) GetMenuItem(ctx context.Context, req *menuv1.GetMenuItemRequest) (*menuv1.GetMenuItemResponse, error) {
-	var menuItem models.MenuItem
-	result := database.DB.First(&menuItem, req.Id)
-	if result.Error != nil {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	menuItem, err := s.repo.GetMenuItem(ctx, uint(req.CafeId), uint(req.Id))
+	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "menu item not found")
 	}
 
+	at := time.Now()
+	if req.At != nil {
+		at = req.At.AsTime()
+	}
+
 	return &menuv1.GetMenuItemResponse{
-		MenuItem: &menuv1.MenuItem{
-			Id:          uint32(menuItem.ID),
-			Name:        menuItem.Name,
-			Description: menuItem.Description,
-			Price:       menuItem.Price,
-		},
+		MenuItem: s.toProto(*menuItem, at),
 	}, nil
 }
 
 func (s *MenuServer) GetMenuItems(ctx context.Context, req *menuv1.GetMenuItemsRequest) (*menuv1.GetMenuItemsResponse, error) {
-	var menuItems []models.MenuItem
-	result := database.DB.Find(&menuItems)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to fetch menu items: %v", result.Error)
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
 	}
 
+	menuItems, err := s.repo.ListMenuItems(ctx, uint(req.CafeId))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fetch menu items: %v", err)
+	}
+
+	at := time.Now()
+	if req.OrderableAt != nil {
+		at = req.OrderableAt.AsTime()
+	}
+
+	// The cafe's whole menu is usually cached, so picking the requested IDs
+	// out of it is cheaper than querying for them.
 	var protoItems []*menuv1.MenuItem
 	for _, item := range menuItems {
-		protoItems = append(protoItems, &menuv1.MenuItem{
-			Id:          uint32(item.ID),
-			Name:        item.Name,
-			Description: item.Description,
-			Price:       item.Price,
-		})
+		if len(req.Ids) > 0 && !slices.Contains(req.Ids, uint32(item.ID)) {
+			continue
+		}
+		protoItem := s.toProto(item, at)
+		if req.OrderableAt != nil && !protoItem.Available {
+			continue
+		}
+		protoItems = append(protoItems, protoItem)
 	}
 
 	return &menuv1.GetMenuItemsResponse{
 		MenuItems: protoItems,
 	}, nil
 }
+
+func (s *MenuServer) SetAvailability(ctx context.Context, req *menuv1.SetAvailabilityRequest) (*menuv1.SetAvailabilityResponse, error) {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	windows, err := windowsFromProto(req.Availability)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	menuItem, err := s.repo.ReplaceAvailability(ctx, uint(req.CafeId), uint(req.MenuItemId), windows)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "menu item not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set availability: %v", err)
+	}
+
+	return &menuv1.SetAvailabilityResponse{
+		MenuItem: s.toProto(*menuItem, time.Now()),
+	}, nil
+}
+
+func (s *MenuServer) SchedulePriceChange(ctx context.Context, req *menuv1.SchedulePriceChangeRequest) (*menuv1.SchedulePriceChangeResponse, error) {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	changes, err := priceChangesFromProto([]*menuv1.PriceChange{{
+		Price:         req.Price,
+		EffectiveFrom: req.EffectiveFrom,
+	}})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	menuItem, err := s.repo.AddPriceChange(ctx, uint(req.CafeId), uint(req.MenuItemId), changes[0])
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "menu item not found")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to schedule price change: %v", err)
+	}
+
+	return &menuv1.SchedulePriceChangeResponse{
+		MenuItem: s.toProto(*menuItem, time.Now()),
+	}, nil
+}
+
+var errCafeRequired = status.Error(codes.InvalidArgument, "cafe_id is required")
+
+// toProto converts a menu item, evaluating its price and availability at t.
+func (s *MenuServer) toProto(item models.MenuItem, t time.Time) *menuv1.MenuItem {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	protoItem := &menuv1.MenuItem{
+		Id:          uint32(item.ID),
+		CafeId:      uint32(item.CafeID),
+		Name:        item.Name,
+		Description: item.Description,
+		Price:       item.PriceAt(t),
+		Available:   item.AvailableAt(t.In(loc)),
+	}
+	for _, w := range item.Availability {
+		protoItem.Availability = append(protoItem.Availability, windowToProto(w))
+	}
+	for _, c := range item.PriceChanges {
+		protoItem.PriceChanges = append(protoItem.PriceChanges, &menuv1.PriceChange{
+			Price:         c.Price,
+			EffectiveFrom: timestamppb.New(c.EffectiveFrom),
+		})
+	}
+	return protoItem
+}
+
+func windowsFromProto(windows []*menuv1.AvailabilityWindow) ([]models.AvailabilityWindow, error) {
+	var result []models.AvailabilityWindow
+	for _, w := range windows {
+		start, err := parseClock(w.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_time %q: %v", w.StartTime, err)
+		}
+		end, err := parseClock(w.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end_time %q: %v", w.EndTime, err)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("end_time %s must be after start_time %s", w.EndTime, w.StartTime)
+		}
+
+		var days models.Weekdays
+		for _, d := range w.Days {
+			if d == menuv1.DayOfWeek_DAY_OF_WEEK_UNSPECIFIED || menuv1.DayOfWeek_name[int32(d)] == "" {
+				return nil, fmt.Errorf("invalid day of week %v", d)
+			}
+			days = days.With(time.Weekday(d % 7))
+		}
+
+		result = append(result, models.AvailabilityWindow{
+			Days:        days,
+			StartMinute: start,
+			EndMinute:   end,
+		})
+	}
+	return result, nil
+}
+
+func windowToProto(w models.AvailabilityWindow) *menuv1.AvailabilityWindow {
+	protoWindow := &menuv1.AvailabilityWindow{
+		StartTime: formatClock(w.StartMinute),
+		EndTime:   formatClock(w.EndMinute),
+	}
+	if w.Days != 0 {
+		for d := menuv1.DayOfWeek_MONDAY; d <= menuv1.DayOfWeek_SUNDAY; d++ {
+			if w.Days.Has(time.Weekday(d % 7)) {
+				protoWindow.Days = append(protoWindow.Days, d)
+			}
+		}
+	}
+	return protoWindow
+}
+
+func priceChangesFromProto(changes []*menuv1.PriceChange) ([]models.PriceChange, error) {
+	var result []models.PriceChange
+	for _, c := range changes {
+		if c.EffectiveFrom == nil {
+			return nil, fmt.Errorf("price change requires effective_from")
+		}
+		if c.Price < 0 {
+			return nil, fmt.Errorf("price must not be negative")
+		}
+		result = append(result, models.PriceChange{
+			Price:         c.Price,
+			EffectiveFrom: c.EffectiveFrom.AsTime(),
+		})
+	}
+	return result, nil
+}
+
+// parseClock converts "HH:MM" to minutes after midnight. "24:00" is accepted
+// as the end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
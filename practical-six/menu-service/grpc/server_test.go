@@ -3,40 +3,23 @@ package grpc
 import (
 	"context"
 	"testing"
+	"time"
 
-	"github.com/practical6/menu-service/database"
-	"github.com/practical6/menu-service/models"
+	"github.com/practical6/menu-service/repository"
 	"github.com/practical6/proto/menu/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func setupTestDB(t *testing.T) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	require.NoError(t, err)
-
-	err = db.AutoMigrate(&models.MenuItem{})
-	require.NoError(t, err)
-
-	return db
-}
-
-func teardownTestDB(t *testing.T, db *gorm.DB) {
-	sqlDB, err := db.DB()
-	require.NoError(t, err)
-	sqlDB.Close()
-}
+const testCafeID = 1
 
 func TestCreateMenuItem(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewMenuServer()
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
 
 	tests := []struct {
 		name    string
@@ -46,6 +29,7 @@ func TestCreateMenuItem(t *testing.T) {
 		{
 			name: "successful menu item creation",
 			request: &menuv1.CreateMenuItemRequest{
+				CafeId:      testCafeID,
 				Name:        "Cappuccino",
 				Description: "Espresso with steamed milk",
 				Price:       4.50,
@@ -55,8 +39,9 @@ func TestCreateMenuItem(t *testing.T) {
 		{
 			name: "create item with zero price",
 			request: &menuv1.CreateMenuItemRequest{
-				Name:  "Water",
-				Price: 0.0,
+				CafeId: testCafeID,
+				Name:   "Water",
+				Price:  0.0,
 			},
 			wantErr: false,
 		},
@@ -80,15 +65,14 @@ func TestCreateMenuItem(t *testing.T) {
 }
 
 func TestGetMenuItem(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewMenuServer()
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
 	ctx := context.Background()
 
 	// Create a test menu item
 	createResp, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId:      testCafeID,
 		Name:        "Test Coffee",
 		Description: "Test description",
 		Price:       3.50,
@@ -117,7 +101,7 @@ func TestGetMenuItem(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{Id: tt.itemID})
+			resp, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{CafeId: testCafeID, Id: tt.itemID})
 
 			if tt.wantErr {
 				require.Error(t, err)
@@ -134,11 +118,9 @@ func TestGetMenuItem(t *testing.T) {
 }
 
 func TestPriceHandling(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewMenuServer()
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
 	ctx := context.Background()
 
 	testCases := []struct {
@@ -153,8 +135,9 @@ func TestPriceHandling(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			resp, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
-				Name:  "Test",
-				Price: tc.price,
+				CafeId: testCafeID,
+				Name:   "Test",
+				Price:  tc.price,
 			})
 
 			require.NoError(t, err)
@@ -164,27 +147,308 @@ func TestPriceHandling(t *testing.T) {
 }
 
 func TestGetMenuItems(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewMenuServer()
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
 	ctx := context.Background()
 
 	// Create test items
 	_, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
-		Name:  "Item 1",
-		Price: 2.50,
+		CafeId: testCafeID,
+		Name:   "Item 1",
+		Price:  2.50,
 	})
 	require.NoError(t, err)
 
-	_, err = server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
-		Name:  "Item 2",
-		Price: 3.50,
+	second, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Item 2",
+		Price:  3.50,
 	})
 	require.NoError(t, err)
 
-	resp, err := server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{})
+	resp, err := server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{CafeId: testCafeID})
 	require.NoError(t, err)
 	assert.Len(t, resp.MenuItems, 2)
+
+	resp, err = server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{
+		CafeId: testCafeID,
+		Ids:    []uint32{second.MenuItem.Id, 9999},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.MenuItems, 1)
+	assert.Equal(t, "Item 2", resp.MenuItems[0].Name)
+}
+
+func TestAvailabilityWindows(t *testing.T) {
+	t.Parallel()
+
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
+	server.Location = time.UTC
+	ctx := context.Background()
+
+	breakfast, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Pancakes",
+		Price:  6.00,
+		Availability: []*menuv1.AvailabilityWindow{
+			{StartTime: "07:00", EndTime: "11:00"},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Coffee",
+		Price:  2.50,
+	})
+	require.NoError(t, err)
+
+	weekendBrunch, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Eggs Benedict",
+		Price:  9.00,
+		Availability: []*menuv1.AvailabilityWindow{
+			{
+				Days:      []menuv1.DayOfWeek{menuv1.DayOfWeek_SATURDAY, menuv1.DayOfWeek_SUNDAY},
+				StartTime: "09:00",
+				EndTime:   "14:00",
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []menuv1.DayOfWeek{menuv1.DayOfWeek_SATURDAY, menuv1.DayOfWeek_SUNDAY},
+		weekendBrunch.MenuItem.Availability[0].Days)
+
+	// 2025-06-02 is a Monday, 2025-06-07 a Saturday.
+	testCases := []struct {
+		name     string
+		at       time.Time
+		expected []string
+	}{
+		{"weekday breakfast", time.Date(2025, 6, 2, 8, 30, 0, 0, time.UTC), []string{"Pancakes", "Coffee"}},
+		{"breakfast end is exclusive", time.Date(2025, 6, 2, 11, 0, 0, 0, time.UTC), []string{"Coffee"}},
+		{"weekend brunch", time.Date(2025, 6, 7, 10, 0, 0, 0, time.UTC), []string{"Pancakes", "Coffee", "Eggs Benedict"}},
+		{"weekend afternoon", time.Date(2025, 6, 7, 15, 0, 0, 0, time.UTC), []string{"Coffee"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{
+				CafeId:      testCafeID,
+				OrderableAt: timestamppb.New(tc.at),
+			})
+			require.NoError(t, err)
+
+			var names []string
+			for _, item := range resp.MenuItems {
+				names = append(names, item.Name)
+				assert.True(t, item.Available)
+			}
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+
+	t.Run("get item outside window", func(t *testing.T) {
+		resp, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{
+			CafeId: testCafeID,
+			Id:     breakfast.MenuItem.Id,
+			At:     timestamppb.New(time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)),
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.MenuItem.Available)
+	})
+
+	t.Run("unfiltered list includes unavailable items", func(t *testing.T) {
+		resp, err := server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{CafeId: testCafeID})
+		require.NoError(t, err)
+		assert.Len(t, resp.MenuItems, 3)
+	})
+}
+
+func TestSetAvailability(t *testing.T) {
+	t.Parallel()
+
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
+	server.Location = time.UTC
+	ctx := context.Background()
+
+	createResp, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Soup",
+		Price:  4.00,
+	})
+	require.NoError(t, err)
+	itemID := createResp.MenuItem.Id
+
+	resp, err := server.SetAvailability(ctx, &menuv1.SetAvailabilityRequest{
+		CafeId:     testCafeID,
+		MenuItemId: itemID,
+		Availability: []*menuv1.AvailabilityWindow{
+			{StartTime: "11:30", EndTime: "14:30"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.MenuItem.Availability, 1)
+	assert.Equal(t, "11:30", resp.MenuItem.Availability[0].StartTime)
+
+	getResp, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{
+		CafeId: testCafeID,
+		Id:     itemID,
+		At:     timestamppb.New(time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)),
+	})
+	require.NoError(t, err)
+	assert.False(t, getResp.MenuItem.Available)
+
+	// Clearing the windows makes the item available again.
+	resp, err = server.SetAvailability(ctx, &menuv1.SetAvailabilityRequest{CafeId: testCafeID, MenuItemId: itemID})
+	require.NoError(t, err)
+	assert.Empty(t, resp.MenuItem.Availability)
+	assert.True(t, resp.MenuItem.Available)
+
+	invalid := []struct {
+		name   string
+		window *menuv1.AvailabilityWindow
+	}{
+		{"malformed time", &menuv1.AvailabilityWindow{StartTime: "7am", EndTime: "11:00"}},
+		{"end before start", &menuv1.AvailabilityWindow{StartTime: "11:00", EndTime: "07:00"}},
+		{"unspecified day", &menuv1.AvailabilityWindow{
+			Days:      []menuv1.DayOfWeek{menuv1.DayOfWeek_DAY_OF_WEEK_UNSPECIFIED},
+			StartTime: "07:00",
+			EndTime:   "11:00",
+		}},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := server.SetAvailability(ctx, &menuv1.SetAvailabilityRequest{
+				CafeId:       testCafeID,
+				MenuItemId:   itemID,
+				Availability: []*menuv1.AvailabilityWindow{tc.window},
+			})
+			require.Error(t, err)
+			st, _ := status.FromError(err)
+			assert.Equal(t, codes.InvalidArgument, st.Code())
+		})
+	}
+
+	_, err = server.SetAvailability(ctx, &menuv1.SetAvailabilityRequest{CafeId: testCafeID, MenuItemId: 9999})
+	st, _ := status.FromError(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+}
+
+func TestScheduledPriceChanges(t *testing.T) {
+	t.Parallel()
+
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
+	ctx := context.Background()
+
+	july := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	september := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	createResp, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: testCafeID,
+		Name:   "Latte",
+		Price:  3.00,
+		PriceChanges: []*menuv1.PriceChange{
+			{Price: 3.50, EffectiveFrom: timestamppb.New(september)},
+		},
+	})
+	require.NoError(t, err)
+	itemID := createResp.MenuItem.Id
+
+	_, err = server.SchedulePriceChange(ctx, &menuv1.SchedulePriceChangeRequest{
+		CafeId:        testCafeID,
+		MenuItemId:    itemID,
+		Price:         3.25,
+		EffectiveFrom: timestamppb.New(july),
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		at    time.Time
+		price float64
+	}{
+		{"before any change", july.Add(-time.Hour), 3.00},
+		{"at first change", july, 3.25},
+		{"between changes", july.AddDate(0, 1, 0), 3.25},
+		{"after second change", september.Add(time.Hour), 3.50},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{
+				CafeId: testCafeID,
+				Id:     itemID,
+				At:     timestamppb.New(tc.at),
+			})
+			require.NoError(t, err)
+			assert.InDelta(t, tc.price, resp.MenuItem.Price, 0.001)
+			assert.Len(t, resp.MenuItem.PriceChanges, 2)
+		})
+	}
+
+	t.Run("missing effective_from", func(t *testing.T) {
+		_, err := server.SchedulePriceChange(ctx, &menuv1.SchedulePriceChangeRequest{
+			CafeId:     testCafeID,
+			MenuItemId: itemID,
+			Price:      4.00,
+		})
+		st, _ := status.FromError(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+	})
+}
+
+func TestCafeScoping(t *testing.T) {
+	t.Parallel()
+
+	server := NewMenuServer(repository.NewMemoryMenuRepository())
+	ctx := context.Background()
+
+	libraryItem, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: 1,
+		Name:   "Library Latte",
+		Price:  3.00,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), libraryItem.MenuItem.CafeId)
+
+	_, err = server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: 2,
+		Name:   "Gym Smoothie",
+		Price:  4.00,
+	})
+	require.NoError(t, err)
+
+	t.Run("list only returns the cafe's items", func(t *testing.T) {
+		resp, err := server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{CafeId: 2})
+		require.NoError(t, err)
+		require.Len(t, resp.MenuItems, 1)
+		assert.Equal(t, "Gym Smoothie", resp.MenuItems[0].Name)
+	})
+
+	t.Run("items of another cafe are not found", func(t *testing.T) {
+		_, err := server.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{CafeId: 2, Id: libraryItem.MenuItem.Id})
+		st, _ := status.FromError(err)
+		assert.Equal(t, codes.NotFound, st.Code())
+
+		_, err = server.SchedulePriceChange(ctx, &menuv1.SchedulePriceChangeRequest{
+			CafeId:        2,
+			MenuItemId:    libraryItem.MenuItem.Id,
+			Price:         1.00,
+			EffectiveFrom: timestamppb.Now(),
+		})
+		st, _ = status.FromError(err)
+		assert.Equal(t, codes.NotFound, st.Code())
+	})
+
+	t.Run("cafe is required", func(t *testing.T) {
+		_, err := server.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{Name: "Orphan", Price: 1.00})
+		st, _ := status.FromError(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+
+		_, err = server.GetMenuItems(ctx, &menuv1.GetMenuItemsRequest{})
+		st, _ = status.FromError(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+	})
 }
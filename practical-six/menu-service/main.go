@@ -1,34 +1,168 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/practical6/menu-service/database"
 	"github.com/practical6/menu-service/grpc"
+	"github.com/practical6/menu-service/repository"
+	"github.com/practical6/pkg/config"
+	"github.com/practical6/pkg/discovery"
+	"github.com/practical6/pkg/healthcheck"
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/metrics"
+	"github.com/practical6/pkg/mtls"
+	"github.com/practical6/pkg/serve"
+	"github.com/practical6/pkg/tracing"
 	"github.com/practical6/proto/menu/v1"
+	"go.opentelemetry.io/otel"
 	grpcServer "google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// callers says which services may call which RPCs when mutual TLS is on.
+// The gateway serves the whole API; order-service only looks up the items
+// being ordered.
+var callers = mtls.Policy{
+	"/menu.v1.MenuService/":            {mtls.ID("api-gateway")},
+	"/menu.v1.MenuService/GetMenuItem": {mtls.ID("api-gateway"), mtls.ID("order-service")},
+	"/grpc.health.v1.Health/":          {mtls.ID("api-gateway"), mtls.ID("order-service")},
+}
+
+type Config struct {
+	GRPCPort        int              `yaml:"grpc_port" env:"GRPC_PORT" flag:"grpc-port" usage:"port to serve gRPC on" required:"true"`
+	MetricsPort     int              `yaml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" usage:"admin port to serve /metrics and /loglevel on" required:"true"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to drain in-flight RPCs on shutdown"`
+	DB              config.Database  `yaml:"db"`
+	LogLevel        string           `yaml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error; PUT /loglevel on the admin port changes it at runtime"`
+	Tracing         tracing.Config   `yaml:"tracing"`
+	TLS             mtls.Config      `yaml:"tls"`
+	Discovery       discovery.Config `yaml:"discovery"`
+	CacheSize       int              `yaml:"cache_size" env:"CACHE_SIZE" flag:"cache-size" usage:"menu items and cafe menus to keep in memory; 0 disables the cache"`
+	CacheTTL        time.Duration    `yaml:"cache_ttl" env:"CACHE_TTL" flag:"cache-ttl" usage:"how long a cached read may be served; other replicas see writes after at most this long"`
+}
+
 func main() {
-	database.InitDB()
+	cfg := Config{
+		GRPCPort:        50052,
+		MetricsPort:     9092,
+		ShutdownTimeout: 15 * time.Second,
+		DB:              config.DefaultDatabase("menudb"),
+		LogLevel:        "info",
+		Tracing:         tracing.DefaultConfig(),
+		TLS:             mtls.DefaultConfig(),
+		Discovery:       discovery.DefaultConfig(),
+		CacheSize:       1000,
+		CacheTTL:        30 * time.Second,
+	}
+	logLevel := logging.Setup("menu-service")
+	args := config.MustLoad("menu-service", &cfg)
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		logging.Fatal("Invalid log level", "err", err)
+	}
 
-	port := os.Getenv("GRPC_PORT")
-	if port == "" {
-		port = "50052"
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := database.Migrate(context.Background(), database.Connect(cfg.DB), args[1:], os.Stdout); err != nil {
+			logging.Fatal("Migration failed", "err", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, "menu-service", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "err", err)
+		}
+	}()
+	tracerProvider := otel.GetTracerProvider()
+
+	creds, err := mtls.Load(cfg.TLS)
+	if err != nil {
+		logging.Fatal("Failed to load TLS certificates", "err", err)
 	}
+	go creds.Run(ctx)
 
-	lis, err := net.Listen("tcp", ":"+port)
+	serviceRegistry, err := discovery.New(cfg.Discovery)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to set up service discovery", "err", err)
 	}
 
-	s := grpcServer.NewServer()
-	menuv1.RegisterMenuServiceServer(s, grpc.NewMenuServer())
+	db := database.InitDB(cfg.DB)
+	if err := db.Use(tracing.GORM(tracerProvider)); err != nil {
+		logging.Fatal("Failed to instrument database", "err", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		logging.Fatal("Failed to get database handle", "err", err)
+	}
+	defer sqlDB.Close()
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+	if err != nil {
+		logging.Fatal("Failed to listen", "err", err)
+	}
+
+	healthServer := health.NewServer()
+	checker := healthcheck.NewChecker(healthServer, menuv1.MenuService_ServiceDesc.ServiceName)
+	checker.Add("database", healthcheck.DB(sqlDB))
+	go checker.Run(ctx)
+
+	// Registered only once listening, and withdrawn as soon as shutdown
+	// starts; Consul hides the instance while its health checks fail.
+	if serviceRegistry != nil {
+		instance, err := cfg.Discovery.Instance("menu-service", cfg.GRPCPort)
+		if err != nil {
+			logging.Fatal("Failed to describe this instance", "err", err)
+		}
+		if err := discovery.Announce(ctx, serviceRegistry, instance, checker.Err); err != nil {
+			logging.Fatal("Failed to register with Consul", "err", err)
+		}
+	}
+
+	registry := metrics.NewRegistry()
+	recorder := metrics.NewRecorder(registry)
+	admin := http.NewServeMux()
+	admin.Handle("/metrics", metrics.Handler(registry))
+	admin.Handle("/loglevel", logging.LevelHandler(logLevel))
+	go func() {
+		if err := serve.HTTP(ctx, serve.AdminServer(cfg.MetricsPort, admin), cfg.ShutdownTimeout); err != nil {
+			slog.Error("Admin server failed", "err", err)
+		}
+	}()
+
+	s := grpcServer.NewServer(
+		grpcServer.Creds(creds.Server()),
+		tracing.ServerOption(tracerProvider),
+		grpcServer.ChainUnaryInterceptor(logging.UnaryServerInterceptor(), recorder.UnaryServerInterceptor(), creds.UnaryServerInterceptor(callers)),
+		grpcServer.ChainStreamInterceptor(logging.StreamServerInterceptor(), recorder.StreamServerInterceptor(), creds.StreamServerInterceptor(callers)),
+	)
+	repo := repository.NewGormMenuRepository(db)
+	if cfg.CacheSize > 0 {
+		repo = repository.NewCachedMenuRepository(repo, cfg.CacheSize, cfg.CacheTTL)
+	}
+	menuv1.RegisterMenuServiceServer(s, grpc.NewMenuServer(repo))
+	healthpb.RegisterHealthServer(s, healthServer)
 
-	log.Printf("Menu service listening on port %s", port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	slog.Info("Menu service listening", "port", cfg.GRPCPort)
+	if err := serve.GRPC(ctx, s, lis, cfg.ShutdownTimeout); err != nil {
+		logging.Fatal("Failed to serve", "err", err)
 	}
+	slog.Info("Menu service stopped")
 }
@@ -1,10 +1,89 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type MenuItem struct {
 	gorm.Model
-	Name        string  `gorm:"not null"`
-	Description string
-	Price       float64 `gorm:"not null"`
+	CafeID       uint   `gorm:"not null;index"`
+	Name         string `gorm:"not null"`
+	Description  string
+	Price        float64              `gorm:"not null"`
+	Availability []AvailabilityWindow `gorm:"foreignKey:MenuItemID"`
+	PriceChanges []PriceChange        `gorm:"foreignKey:MenuItemID"`
+}
+
+// AvailabilityWindow restricts when a menu item can be ordered. Minutes are
+// counted from local midnight; the window covers [StartMinute, EndMinute).
+type AvailabilityWindow struct {
+	gorm.Model
+	MenuItemID  uint `gorm:"not null;index"`
+	Days        Weekdays
+	StartMinute int `gorm:"not null"`
+	EndMinute   int `gorm:"not null"`
+}
+
+// PriceChange sets a new price for a menu item from EffectiveFrom onwards.
+type PriceChange struct {
+	gorm.Model
+	MenuItemID    uint      `gorm:"not null;index"`
+	Price         float64   `gorm:"not null"`
+	EffectiveFrom time.Time `gorm:"not null;index"`
+}
+
+// Weekdays is a bit set of time.Weekday values. The zero value means every day.
+type Weekdays uint8
+
+func (d Weekdays) Has(day time.Weekday) bool {
+	return d == 0 || d&(1<<uint(day)) != 0
+}
+
+func (d Weekdays) With(day time.Weekday) Weekdays {
+	return d | 1<<uint(day)
+}
+
+// Contains reports whether the window covers t, using t's location.
+func (w AvailabilityWindow) Contains(t time.Time) bool {
+	if !w.Days.Has(t.Weekday()) {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	return minute >= w.StartMinute && minute < w.EndMinute
+}
+
+// AvailableAt reports whether the item can be ordered at t. Items without
+// windows are always available.
+func (m MenuItem) AvailableAt(t time.Time) bool {
+	if len(m.Availability) == 0 {
+		return true
+	}
+	for _, w := range m.Availability {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// PriceAt returns the price in effect at t: the latest scheduled change that
+// has started, or the base price if none has.
+func (m MenuItem) PriceAt(t time.Time) float64 {
+	changes := make([]PriceChange, len(m.PriceChanges))
+	copy(changes, m.PriceChanges)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].EffectiveFrom.Before(changes[j].EffectiveFrom)
+	})
+
+	price := m.Price
+	for _, c := range changes {
+		if c.EffectiveFrom.After(t) {
+			break
+		}
+		price = c.Price
+	}
+	return price
 }
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/practical6/menu-service/models"
+)
+
+// CachedMenuRepository keeps recently read items and cafe menus in memory in
+// front of another MenuRepository. Any write to a cafe invalidates everything
+// cached for it. Each process caches on its own, so a write through one
+// replica can take up to the TTL to show on the others.
+type CachedMenuRepository struct {
+	MenuRepository
+
+	size int
+	ttl  time.Duration
+	// Now defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // front is most recently used
+	// generations counts writes per cafe. Entries remember the generation
+	// they were read in and are stale once it moves on.
+	generations map[uint]uint64
+}
+
+// cacheKey identifies an item, or a cafe's whole menu when id is 0.
+type cacheKey struct {
+	cafeID, id uint
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	generation uint64
+	expires    time.Time
+	items      []models.MenuItem
+}
+
+// NewCachedMenuRepository caches up to size reads from inner for ttl each.
+func NewCachedMenuRepository(inner MenuRepository, size int, ttl time.Duration) *CachedMenuRepository {
+	return &CachedMenuRepository{
+		MenuRepository: inner,
+		size:           max(size, 1),
+		ttl:            ttl,
+		Now:            time.Now,
+		entries:        make(map[cacheKey]*list.Element),
+		lru:            list.New(),
+		generations:    make(map[uint]uint64),
+	}
+}
+
+func (r *CachedMenuRepository) GetMenuItem(ctx context.Context, cafeID, id uint) (*models.MenuItem, error) {
+	key := cacheKey{cafeID: cafeID, id: id}
+	if items, ok := r.get(key); ok {
+		return &items[0], nil
+	}
+
+	generation := r.generation(cafeID)
+	item, err := r.MenuRepository.GetMenuItem(ctx, cafeID, id)
+	if err != nil {
+		return nil, err
+	}
+	r.put(key, generation, []models.MenuItem{*item})
+	return item, nil
+}
+
+func (r *CachedMenuRepository) ListMenuItems(ctx context.Context, cafeID uint) ([]models.MenuItem, error) {
+	key := cacheKey{cafeID: cafeID}
+	if items, ok := r.get(key); ok {
+		return items, nil
+	}
+
+	generation := r.generation(cafeID)
+	items, err := r.MenuRepository.ListMenuItems(ctx, cafeID)
+	if err != nil {
+		return nil, err
+	}
+	r.put(key, generation, items)
+	return items, nil
+}
+
+func (r *CachedMenuRepository) CreateMenuItem(ctx context.Context, item *models.MenuItem) error {
+	defer r.invalidate(item.CafeID)
+	return r.MenuRepository.CreateMenuItem(ctx, item)
+}
+
+func (r *CachedMenuRepository) ReplaceAvailability(ctx context.Context, cafeID, id uint, windows []models.AvailabilityWindow) (*models.MenuItem, error) {
+	defer r.invalidate(cafeID)
+	return r.MenuRepository.ReplaceAvailability(ctx, cafeID, id, windows)
+}
+
+func (r *CachedMenuRepository) AddPriceChange(ctx context.Context, cafeID, id uint, change models.PriceChange) (*models.MenuItem, error) {
+	defer r.invalidate(cafeID)
+	return r.MenuRepository.AddPriceChange(ctx, cafeID, id, change)
+}
+
+// get returns a copy of the cached items at key, if they are still fresh.
+func (r *CachedMenuRepository) get(key cacheKey) ([]models.MenuItem, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.generation != r.generations[key.cafeID] || !r.Now().Before(entry.expires) {
+		r.lru.Remove(elem)
+		delete(r.entries, key)
+		return nil, false
+	}
+	r.lru.MoveToFront(elem)
+	return cloneAll(entry.items), true
+}
+
+func (r *CachedMenuRepository) generation(cafeID uint) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generations[cafeID]
+}
+
+// put caches a copy of items read during generation, unless the cafe has
+// been written to since: the read may have raced with the write and returned
+// what it replaced.
+func (r *CachedMenuRepository) put(key cacheKey, generation uint64, items []models.MenuItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != r.generations[key.cafeID] {
+		return
+	}
+	entry := &cacheEntry{
+		key:        key,
+		generation: generation,
+		expires:    r.Now().Add(r.ttl),
+		items:      cloneAll(items),
+	}
+	if elem, ok := r.entries[key]; ok {
+		elem.Value = entry
+		r.lru.MoveToFront(elem)
+		return
+	}
+	r.entries[key] = r.lru.PushFront(entry)
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops everything cached for the cafe. Entries are not removed
+// straight away; get discards them when it next sees them, and the LRU
+// evicts the rest.
+func (r *CachedMenuRepository) invalidate(cafeID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generations[cafeID]++
+}
+
+func cloneAll(items []models.MenuItem) []models.MenuItem {
+	if items == nil {
+		return nil
+	}
+	copies := make([]models.MenuItem, len(items))
+	for i, item := range items {
+		copies[i] = clone(item)
+	}
+	return copies
+}
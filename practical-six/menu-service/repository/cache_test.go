@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/practical6/menu-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// countingRepository counts the reads that get past the cache. beforeList,
+// if set, runs inside ListMenuItems before the inner read.
+type countingRepository struct {
+	MenuRepository
+	mu         sync.Mutex
+	gets       int
+	lists      int
+	beforeList func()
+}
+
+func (r *countingRepository) GetMenuItem(ctx context.Context, cafeID, id uint) (*models.MenuItem, error) {
+	r.mu.Lock()
+	r.gets++
+	r.mu.Unlock()
+	return r.MenuRepository.GetMenuItem(ctx, cafeID, id)
+}
+
+func (r *countingRepository) ListMenuItems(ctx context.Context, cafeID uint) ([]models.MenuItem, error) {
+	r.mu.Lock()
+	r.lists++
+	hook := r.beforeList
+	r.beforeList = nil
+	r.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	return r.MenuRepository.ListMenuItems(ctx, cafeID)
+}
+
+func setupCache(t *testing.T, size int) (*CachedMenuRepository, *countingRepository, *fakeClock, models.MenuItem) {
+	inner := &countingRepository{MenuRepository: NewMemoryMenuRepository()}
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)}
+	cache := NewCachedMenuRepository(inner, size, time.Minute)
+	cache.Now = clock.Now
+
+	latte := models.MenuItem{CafeID: 1, Name: "Latte", Price: 3.00}
+	require.NoError(t, cache.CreateMenuItem(context.Background(), &latte))
+	return cache, inner, clock, latte
+}
+
+func TestCacheServesRepeatedReads(t *testing.T) {
+	t.Parallel()
+
+	cache, inner, clock, latte := setupCache(t, 10)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		item, err := cache.GetMenuItem(ctx, 1, latte.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Latte", item.Name)
+
+		items, err := cache.ListMenuItems(ctx, 1)
+		require.NoError(t, err)
+		assert.Len(t, items, 1)
+	}
+	assert.Equal(t, 1, inner.gets)
+	assert.Equal(t, 1, inner.lists)
+
+	// Misses are not cached.
+	_, err := cache.GetMenuItem(ctx, 2, latte.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cache.GetMenuItem(ctx, 2, latte.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 3, inner.gets)
+
+	clock.Advance(time.Minute)
+	_, err = cache.GetMenuItem(ctx, 1, latte.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, inner.gets, "expired entries are read again")
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	t.Parallel()
+
+	cache, _, _, latte := setupCache(t, 10)
+	ctx := context.Background()
+
+	item, err := cache.GetMenuItem(ctx, 1, latte.ID)
+	require.NoError(t, err)
+	item.Name = "Flat white"
+	item.Availability = append(item.Availability, models.AvailabilityWindow{StartMinute: 0, EndMinute: 60})
+
+	item, err = cache.GetMenuItem(ctx, 1, latte.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Latte", item.Name)
+	assert.Empty(t, item.Availability)
+}
+
+func TestCacheInvalidatedByWrites(t *testing.T) {
+	t.Parallel()
+
+	cache, inner, _, latte := setupCache(t, 10)
+	ctx := context.Background()
+
+	cache.ListMenuItems(ctx, 1)
+	cache.ListMenuItems(ctx, 2)
+	cache.GetMenuItem(ctx, 1, latte.ID)
+
+	mocha := models.MenuItem{CafeID: 1, Name: "Mocha", Price: 3.50}
+	require.NoError(t, cache.CreateMenuItem(ctx, &mocha))
+	items, err := cache.ListMenuItems(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	_, err = cache.ReplaceAvailability(ctx, 1, latte.ID, []models.AvailabilityWindow{{StartMinute: 7 * 60, EndMinute: 11 * 60}})
+	require.NoError(t, err)
+	item, err := cache.GetMenuItem(ctx, 1, latte.ID)
+	require.NoError(t, err)
+	assert.Len(t, item.Availability, 1)
+
+	_, err = cache.AddPriceChange(ctx, 1, latte.ID, models.PriceChange{Price: 3.25, EffectiveFrom: time.Now()})
+	require.NoError(t, err)
+	item, err = cache.GetMenuItem(ctx, 1, latte.ID)
+	require.NoError(t, err)
+	assert.Len(t, item.PriceChanges, 1)
+
+	// Writes to cafe 1 leave cafe 2's menu cached.
+	cache.ListMenuItems(ctx, 2)
+	assert.Equal(t, 3, inner.lists)
+	assert.Equal(t, 3, inner.gets)
+}
+
+func TestCacheDropsReadsThatRaceWithWrites(t *testing.T) {
+	t.Parallel()
+
+	cache, inner, _, _ := setupCache(t, 10)
+	ctx := context.Background()
+
+	// The write lands after the list was started. What the list returns may
+	// or may not include the new item, so it must not be cached.
+	inner.beforeList = func() {
+		require.NoError(t, cache.CreateMenuItem(ctx, &models.MenuItem{CafeID: 1, Name: "Mocha", Price: 3.50}))
+	}
+	_, err := cache.ListMenuItems(ctx, 1)
+	require.NoError(t, err)
+
+	items, err := cache.ListMenuItems(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, 2, inner.lists)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	cache, inner, _, latte := setupCache(t, 2)
+	ctx := context.Background()
+
+	cache.GetMenuItem(ctx, 1, latte.ID)
+	cache.ListMenuItems(ctx, 1)
+	cache.GetMenuItem(ctx, 1, latte.ID) // latte is now more recent than the list
+	cache.ListMenuItems(ctx, 2)         // evicts cafe 1's list
+	require.Equal(t, 1, inner.gets)
+	require.Equal(t, 2, inner.lists)
+
+	cache.GetMenuItem(ctx, 1, latte.ID)
+	assert.Equal(t, 1, inner.gets)
+	cache.ListMenuItems(ctx, 1)
+	assert.Equal(t, 3, inner.lists)
+}
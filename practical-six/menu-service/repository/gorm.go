@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/menu-service/models"
+	"gorm.io/gorm"
+)
+
+type gormMenuRepository struct {
+	db *gorm.DB
+}
+
+func NewGormMenuRepository(db *gorm.DB) MenuRepository {
+	return &gormMenuRepository{db: db}
+}
+
+// forCafe scopes a query to a single cafe's menu.
+func (r *gormMenuRepository) forCafe(ctx context.Context, cafeID uint) *gorm.DB {
+	return r.db.WithContext(ctx).Where("cafe_id = ?", cafeID)
+}
+
+func withSchedule(db *gorm.DB) *gorm.DB {
+	return db.Preload("Availability").Preload("PriceChanges", func(db *gorm.DB) *gorm.DB {
+		return db.Order("effective_from")
+	})
+}
+
+func (r *gormMenuRepository) CreateMenuItem(ctx context.Context, item *models.MenuItem) error {
+	return r.db.WithContext(ctx).Create(item).Error
+}
+
+func (r *gormMenuRepository) GetMenuItem(ctx context.Context, cafeID, id uint) (*models.MenuItem, error) {
+	var item models.MenuItem
+	if err := withSchedule(r.forCafe(ctx, cafeID)).First(&item, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &item, nil
+}
+
+func (r *gormMenuRepository) ListMenuItems(ctx context.Context, cafeID uint) ([]models.MenuItem, error) {
+	var items []models.MenuItem
+	if err := withSchedule(r.forCafe(ctx, cafeID)).Find(&items).Error; err != nil {
+		return nil, translate(err)
+	}
+	return items, nil
+}
+
+func (r *gormMenuRepository) ReplaceAvailability(ctx context.Context, cafeID, id uint, windows []models.AvailabilityWindow) (*models.MenuItem, error) {
+	var item models.MenuItem
+	if err := r.forCafe(ctx, cafeID).First(&item, id).Error; err != nil {
+		return nil, translate(err)
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("menu_item_id = ?", item.ID).Delete(&models.AvailabilityWindow{}).Error; err != nil {
+			return err
+		}
+		if len(windows) == 0 {
+			return nil
+		}
+		for i := range windows {
+			windows[i].MenuItemID = item.ID
+		}
+		return tx.Create(&windows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetMenuItem(ctx, cafeID, id)
+}
+
+func (r *gormMenuRepository) AddPriceChange(ctx context.Context, cafeID, id uint, change models.PriceChange) (*models.MenuItem, error) {
+	var item models.MenuItem
+	if err := r.forCafe(ctx, cafeID).First(&item, id).Error; err != nil {
+		return nil, translate(err)
+	}
+
+	change.MenuItemID = item.ID
+	if err := r.db.WithContext(ctx).Create(&change).Error; err != nil {
+		return nil, err
+	}
+
+	return r.GetMenuItem(ctx, cafeID, id)
+}
+
+func translate(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
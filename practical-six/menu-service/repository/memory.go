@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/practical6/menu-service/models"
+)
+
+type memoryMenuRepository struct {
+	mu     sync.RWMutex
+	items  map[uint]models.MenuItem
+	nextID uint
+}
+
+// NewMemoryMenuRepository returns an empty repository that keeps everything
+// in memory. It is safe for concurrent use.
+func NewMemoryMenuRepository() MenuRepository {
+	return &memoryMenuRepository{items: make(map[uint]models.MenuItem)}
+}
+
+func (r *memoryMenuRepository) CreateMenuItem(ctx context.Context, item *models.MenuItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	item.ID = r.nextID
+	item.CreatedAt = time.Now()
+	item.UpdatedAt = item.CreatedAt
+	for i := range item.Availability {
+		item.Availability[i].MenuItemID = item.ID
+	}
+	for i := range item.PriceChanges {
+		item.PriceChanges[i].MenuItemID = item.ID
+	}
+
+	r.items[item.ID] = clone(*item)
+	return nil
+}
+
+func (r *memoryMenuRepository) GetMenuItem(ctx context.Context, cafeID, id uint) (*models.MenuItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	item, ok := r.items[id]
+	if !ok || item.CafeID != cafeID {
+		return nil, ErrNotFound
+	}
+	item = clone(item)
+	return &item, nil
+}
+
+func (r *memoryMenuRepository) ListMenuItems(ctx context.Context, cafeID uint) ([]models.MenuItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var items []models.MenuItem
+	for _, item := range r.items {
+		if item.CafeID == cafeID {
+			items = append(items, clone(item))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (r *memoryMenuRepository) ReplaceAvailability(ctx context.Context, cafeID, id uint, windows []models.AvailabilityWindow) (*models.MenuItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[id]
+	if !ok || item.CafeID != cafeID {
+		return nil, ErrNotFound
+	}
+
+	item.Availability = nil
+	for _, w := range windows {
+		w.MenuItemID = item.ID
+		item.Availability = append(item.Availability, w)
+	}
+	item.UpdatedAt = time.Now()
+	r.items[id] = item
+
+	item = clone(item)
+	return &item, nil
+}
+
+func (r *memoryMenuRepository) AddPriceChange(ctx context.Context, cafeID, id uint, change models.PriceChange) (*models.MenuItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[id]
+	if !ok || item.CafeID != cafeID {
+		return nil, ErrNotFound
+	}
+
+	change.MenuItemID = item.ID
+	item.PriceChanges = append(append([]models.PriceChange(nil), item.PriceChanges...), change)
+	item.UpdatedAt = time.Now()
+	r.items[id] = item
+
+	item = clone(item)
+	return &item, nil
+}
+
+// clone copies the item's slices so callers cannot mutate stored state, and
+// orders price changes by EffectiveFrom as the GORM implementation does.
+func clone(item models.MenuItem) models.MenuItem {
+	item.Availability = append([]models.AvailabilityWindow(nil), item.Availability...)
+	item.PriceChanges = append([]models.PriceChange(nil), item.PriceChanges...)
+	sort.SliceStable(item.PriceChanges, func(i, j int) bool {
+		return item.PriceChanges[i].EffectiveFrom.Before(item.PriceChanges[j].EffectiveFrom)
+	})
+	return item
+}
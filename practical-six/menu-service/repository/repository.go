@@ -0,0 +1,30 @@
+// Package repository defines the storage interface used by the menu service,
+// with a GORM implementation for production and an in-memory one for tests.
+// CachedMenuRepository can sit in front of either to serve repeated reads.
+//
+// Every lookup takes the owning cafe's ID: an item that exists but belongs to
+// another cafe is reported as ErrNotFound.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/menu-service/models"
+)
+
+// ErrNotFound is returned when the requested item does not exist in the cafe.
+var ErrNotFound = errors.New("record not found")
+
+type MenuRepository interface {
+	// CreateMenuItem stores item together with its availability windows and
+	// price changes. item.CafeID must be set.
+	CreateMenuItem(ctx context.Context, item *models.MenuItem) error
+	// GetMenuItem returns the item with its availability windows and price
+	// changes, the latter ordered by EffectiveFrom.
+	GetMenuItem(ctx context.Context, cafeID, id uint) (*models.MenuItem, error)
+	ListMenuItems(ctx context.Context, cafeID uint) ([]models.MenuItem, error)
+	// ReplaceAvailability swaps the item's windows for the given ones.
+	ReplaceAvailability(ctx context.Context, cafeID, id uint, windows []models.AvailabilityWindow) (*models.MenuItem, error)
+	AddPriceChange(ctx context.Context, cafeID, id uint, change models.PriceChange) (*models.MenuItem, error)
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/practical6/menu-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newGormRepository opens a private in-memory SQLite database so tests can
+// run in parallel without sharing tables.
+func newGormRepository(t *testing.T) MenuRepository {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&models.MenuItem{}, &models.AvailabilityWindow{}, &models.PriceChange{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		sqlDB.Close()
+	})
+	return NewGormMenuRepository(db)
+}
+
+var implementations = []struct {
+	name string
+	new  func(t *testing.T) MenuRepository
+}{
+	{"gorm", newGormRepository},
+	{"memory", func(t *testing.T) MenuRepository { return NewMemoryMenuRepository() }},
+	{"cached", func(t *testing.T) MenuRepository {
+		return NewCachedMenuRepository(NewMemoryMenuRepository(), 100, time.Minute)
+	}},
+}
+
+func TestMenuRepository(t *testing.T) {
+	t.Parallel()
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := impl.new(t)
+			ctx := context.Background()
+
+			later := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+			sooner := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+
+			latte := models.MenuItem{
+				CafeID: 1,
+				Name:   "Latte",
+				Price:  3.00,
+				Availability: []models.AvailabilityWindow{
+					{StartMinute: 7 * 60, EndMinute: 11 * 60},
+				},
+				PriceChanges: []models.PriceChange{
+					{Price: 3.50, EffectiveFrom: later},
+				},
+			}
+			require.NoError(t, repo.CreateMenuItem(ctx, &latte))
+			assert.NotZero(t, latte.ID)
+
+			smoothie := models.MenuItem{CafeID: 2, Name: "Smoothie", Price: 4.00}
+			require.NoError(t, repo.CreateMenuItem(ctx, &smoothie))
+
+			found, err := repo.GetMenuItem(ctx, 1, latte.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "Latte", found.Name)
+			assert.Len(t, found.Availability, 1)
+			assert.Len(t, found.PriceChanges, 1)
+
+			_, err = repo.GetMenuItem(ctx, 2, latte.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			items, err := repo.ListMenuItems(ctx, 2)
+			require.NoError(t, err)
+			require.Len(t, items, 1)
+			assert.Equal(t, "Smoothie", items[0].Name)
+
+			updated, err := repo.ReplaceAvailability(ctx, 1, latte.ID, []models.AvailabilityWindow{
+				{StartMinute: 12 * 60, EndMinute: 14 * 60},
+				{StartMinute: 17 * 60, EndMinute: 19 * 60},
+			})
+			require.NoError(t, err)
+			require.Len(t, updated.Availability, 2)
+
+			updated, err = repo.ReplaceAvailability(ctx, 1, latte.ID, nil)
+			require.NoError(t, err)
+			assert.Empty(t, updated.Availability)
+
+			_, err = repo.ReplaceAvailability(ctx, 2, latte.ID, nil)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			updated, err = repo.AddPriceChange(ctx, 1, latte.ID, models.PriceChange{Price: 3.25, EffectiveFrom: sooner})
+			require.NoError(t, err)
+			require.Len(t, updated.PriceChanges, 2)
+			assert.True(t, updated.PriceChanges[0].EffectiveFrom.Equal(sooner))
+			assert.True(t, updated.PriceChanges[1].EffectiveFrom.Equal(later))
+
+			_, err = repo.AddPriceChange(ctx, 2, latte.ID, models.PriceChange{Price: 1, EffectiveFrom: sooner})
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
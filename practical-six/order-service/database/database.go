@@ -1,44 +1,60 @@
 package database
 
 import (
-	"fmt"
+	"context"
+	"embed"
+	"io"
+	"io/fs"
 	"log"
-	"os"
+	"log/slog"
 
-	"github.com/practical6/order-service/models"
+	"github.com/practical6/pkg/config"
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/migrate"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-var DB *gorm.DB
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-func InitDB() {
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		getEnv("DB_HOST", "localhost"),
-		getEnv("DB_USER", "postgres"),
-		getEnv("DB_PASSWORD", "postgres"),
-		getEnv("DB_NAME", "orderdb"),
-		getEnv("DB_PORT", "5432"),
-	)
-
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Connect opens the database without touching the schema.
+func Connect(cfg config.Database) *gorm.DB {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		logging.Fatal("Failed to connect to database", "err", err)
 	}
+	return db
+}
 
-	err = DB.AutoMigrate(&models.Order{}, &models.OrderItem{})
-	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+// InitDB connects and applies any pending migrations.
+func InitDB(cfg config.Database) *gorm.DB {
+	db := Connect(cfg)
+
+	if err := Migrate(context.Background(), db, []string{"up"}, log.Writer()); err != nil {
+		logging.Fatal("Failed to migrate database", "err", err)
 	}
 
-	log.Println("Database connected and migrated successfully")
+	slog.Info("Database connected and migrated successfully")
+	return db
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Migrate runs a migrate subcommand ("up", "down", "status" or
+// "to <version>") against db.
+func Migrate(ctx context.Context, db *gorm.DB, args []string, out io.Writer) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	files, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate.New(sqlDB, files)
+	if err != nil {
+		return err
 	}
-	return defaultValue
+	m.Locker = migrate.AdvisoryLock("order-service")
+	return m.Run(ctx, args, out)
 }
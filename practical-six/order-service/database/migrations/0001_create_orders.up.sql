@@ -0,0 +1,27 @@
+-- IF NOT EXISTS lets databases created by the old AutoMigrate start out
+-- with this migration recorded as applied.
+CREATE TABLE IF NOT EXISTS orders (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    user_id    BIGINT NOT NULL,
+    status     TEXT DEFAULT 'pending'
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id             BIGSERIAL PRIMARY KEY,
+    created_at     TIMESTAMPTZ,
+    updated_at     TIMESTAMPTZ,
+    deleted_at     TIMESTAMPTZ,
+    order_id       BIGINT,
+    menu_item_id   BIGINT NOT NULL,
+    menu_item_name TEXT NOT NULL,
+    quantity       BIGINT NOT NULL,
+    price          DECIMAL NOT NULL,
+    CONSTRAINT fk_orders_order_items FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_deleted_at ON order_items (deleted_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_orders_cafe_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS cafe_id;
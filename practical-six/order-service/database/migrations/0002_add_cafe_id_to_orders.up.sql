@@ -0,0 +1,8 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cafe_id BIGINT;
+
+-- Orders placed before cafes existed belong to the default cafe, which the
+-- gateway serves on the legacy /api/orders routes (DEFAULT_CAFE_ID).
+UPDATE orders SET cafe_id = 1 WHERE cafe_id IS NULL;
+
+ALTER TABLE orders ALTER COLUMN cafe_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_orders_cafe_id ON orders (cafe_id);
@@ -0,0 +1,38 @@
+package grpc
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OrderMetrics counts the orders placed and their value.
+type OrderMetrics struct {
+	created *prometheus.CounterVec
+	value   *prometheus.HistogramVec
+}
+
+func NewOrderMetrics(reg prometheus.Registerer) *OrderMetrics {
+	m := &OrderMetrics{
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "orders_created_total",
+			Help: "Orders created, by cafe.",
+		}, []string{"cafe_id"}),
+		value: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "order_value",
+			Help:    "Total price of each order created, by cafe.",
+			Buckets: []float64{2, 5, 10, 20, 50, 100, 200, 500},
+		}, []string{"cafe_id"}),
+	}
+	reg.MustRegister(m.created, m.value)
+	return m
+}
+
+func (m *OrderMetrics) orderCreated(cafeID uint32, total float64) {
+	if m == nil {
+		return
+	}
+	cafe := strconv.FormatUint(uint64(cafeID), 10)
+	m.created.WithLabelValues(cafe).Inc()
+	m.value.WithLabelValues(cafe).Observe(total)
+}
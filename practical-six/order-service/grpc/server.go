@@ -2,32 +2,49 @@ package grpc
 
 import (
 	"context"
+	"time"
 
-	"github.com/practical6/order-service/database"
 	"github.com/practical6/order-service/models"
+	"github.com/practical6/order-service/repository"
 	menuv1 "github.com/practical6/proto/menu/v1"
 	orderv1 "github.com/practical6/proto/order/v1"
 	userv1 "github.com/practical6/proto/user/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
 )
 
 type OrderServer struct {
 	orderv1.UnimplementedOrderServiceServer
+	repo       repository.OrderRepository
 	UserClient userv1.UserServiceClient
 	MenuClient menuv1.MenuServiceClient
+	// Now returns the order timestamp; defaults to time.Now.
+	Now func() time.Time
+	// Metrics records created orders when set.
+	Metrics *OrderMetrics
 }
 
-func NewOrderServer(userClient userv1.UserServiceClient, menuClient menuv1.MenuServiceClient) *OrderServer {
+func NewOrderServer(repo repository.OrderRepository, userClient userv1.UserServiceClient, menuClient menuv1.MenuServiceClient) *OrderServer {
 	return &OrderServer{
+		repo:       repo,
 		UserClient: userClient,
 		MenuClient: menuClient,
+		Now:        time.Now,
 	}
 }
 
 func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.CreateOrderResponse, error) {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
 	// Validate user exists
 	_, err := s.UserClient.GetUser(ctx, &userv1.GetUserRequest{Id: req.UserId})
+	if isUpstreamFailure(err) {
+		return nil, status.Errorf(codes.Unavailable, "user service unavailable: %v", err)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "user not found: %v", err)
 	}
@@ -36,8 +53,15 @@ func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderR
 		return nil, status.Errorf(codes.InvalidArgument, "order must have at least one item")
 	}
 
+	orderedAt := time.Now()
+	if s.Now != nil {
+		orderedAt = s.Now()
+	}
+
 	// Create order
 	order := models.Order{
+		Model:  gorm.Model{CreatedAt: orderedAt},
+		CafeID: uint(req.CafeId),
 		UserID: uint(req.UserId),
 		Status: "pending",
 	}
@@ -49,11 +73,22 @@ func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderR
 			return nil, status.Errorf(codes.InvalidArgument, "quantity must be greater than 0")
 		}
 
-		// Get menu item details
-		menuResp, err := s.MenuClient.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{Id: item.MenuItemId})
+		// Get menu item details as of the order timestamp
+		menuResp, err := s.MenuClient.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{
+			Id:     item.MenuItemId,
+			At:     timestamppb.New(orderedAt),
+			CafeId: req.CafeId,
+		})
+		if isUpstreamFailure(err) {
+			return nil, status.Errorf(codes.Unavailable, "menu service unavailable: %v", err)
+		}
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "menu item %d not found: %v", item.MenuItemId, err)
 		}
+		if !menuResp.MenuItem.Available {
+			return nil, status.Errorf(codes.FailedPrecondition, "menu item %d is not available at %s",
+				item.MenuItemId, orderedAt.Format(time.RFC3339))
+		}
 
 		orderItem := models.OrderItem{
 			MenuItemID:   uint(item.MenuItemId),
@@ -66,14 +101,15 @@ func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderR
 
 	order.OrderItems = orderItems
 
-	result := database.DB.Create(&order)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create order: %v", result.Error)
+	if err := s.repo.CreateOrder(ctx, &order); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create order: %v", err)
 	}
 
 	// Build response
 	var protoItems []*orderv1.OrderItem
+	var total float64
 	for _, item := range order.OrderItems {
+		total += item.Price * float64(item.Quantity)
 		protoItems = append(protoItems, &orderv1.OrderItem{
 			Id:           uint32(item.ID),
 			MenuItemId:   uint32(item.MenuItemID),
@@ -82,10 +118,12 @@ func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderR
 			Price:        item.Price,
 		})
 	}
+	s.Metrics.orderCreated(req.CafeId, total)
 
 	return &orderv1.CreateOrderResponse{
 		Order: &orderv1.Order{
 			Id:         uint32(order.ID),
+			CafeId:     uint32(order.CafeID),
 			UserId:     uint32(order.UserID),
 			Status:     order.Status,
 			OrderItems: protoItems,
@@ -94,9 +132,12 @@ func (s *OrderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderR
 }
 
 func (s *OrderServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.GetOrderResponse, error) {
-	var order models.Order
-	result := database.DB.Preload("OrderItems").First(&order, req.Id)
-	if result.Error != nil {
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	order, err := s.repo.GetOrder(ctx, uint(req.CafeId), uint(req.Id))
+	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "order not found")
 	}
 
@@ -114,6 +155,7 @@ func (s *OrderServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest
 	return &orderv1.GetOrderResponse{
 		Order: &orderv1.Order{
 			Id:         uint32(order.ID),
+			CafeId:     uint32(order.CafeID),
 			UserId:     uint32(order.UserID),
 			Status:     order.Status,
 			OrderItems: protoItems,
@@ -122,10 +164,13 @@ func (s *OrderServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest
 }
 
 func (s *OrderServer) GetOrders(ctx context.Context, req *orderv1.GetOrdersRequest) (*orderv1.GetOrdersResponse, error) {
-	var orders []models.Order
-	result := database.DB.Preload("OrderItems").Find(&orders)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to fetch orders: %v", result.Error)
+	if req.CafeId == 0 {
+		return nil, errCafeRequired
+	}
+
+	orders, err := s.repo.ListOrders(ctx, uint(req.CafeId))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fetch orders: %v", err)
 	}
 
 	var protoOrders []*orderv1.Order
@@ -143,6 +188,7 @@ func (s *OrderServer) GetOrders(ctx context.Context, req *orderv1.GetOrdersReque
 
 		protoOrders = append(protoOrders, &orderv1.Order{
 			Id:         uint32(order.ID),
+			CafeId:     uint32(order.CafeID),
 			UserId:     uint32(order.UserID),
 			Status:     order.Status,
 			OrderItems: protoItems,
@@ -153,3 +199,17 @@ func (s *OrderServer) GetOrders(ctx context.Context, req *orderv1.GetOrdersReque
 		Orders: protoOrders,
 	}, nil
 }
+
+var errCafeRequired = status.Error(codes.InvalidArgument, "cafe_id is required")
+
+// isUpstreamFailure reports whether err means a dependency could not answer,
+// as opposed to answering that the user or menu item does not exist. The
+// caller may retry the former later.
+func isUpstreamFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
@@ -2,21 +2,23 @@ package grpc
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/practical6/order-service/database"
-	"github.com/practical6/order-service/models"
+	"github.com/practical6/order-service/repository"
 	menuv1 "github.com/practical6/proto/menu/v1"
 	orderv1 "github.com/practical6/proto/order/v1"
 	userv1 "github.com/practical6/proto/user/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"gorm.io/driver/sqlite"
-	gormDB "gorm.io/gorm"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // MockUserServiceClient simulates the user service
@@ -48,6 +50,38 @@ func (m *MockUserServiceClient) GetUsers(ctx context.Context, req *userv1.GetUse
 	return args.Get(0).(*userv1.GetUsersResponse), args.Error(1)
 }
 
+func (m *MockUserServiceClient) CreateCafe(ctx context.Context, req *userv1.CreateCafeRequest, opts ...grpc.CallOption) (*userv1.CreateCafeResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*userv1.CreateCafeResponse), args.Error(1)
+}
+
+func (m *MockUserServiceClient) GetCafe(ctx context.Context, req *userv1.GetCafeRequest, opts ...grpc.CallOption) (*userv1.GetCafeResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*userv1.GetCafeResponse), args.Error(1)
+}
+
+func (m *MockUserServiceClient) GetCafes(ctx context.Context, req *userv1.GetCafesRequest, opts ...grpc.CallOption) (*userv1.GetCafesResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*userv1.GetCafesResponse), args.Error(1)
+}
+
+func (m *MockUserServiceClient) AssignCafeOwner(ctx context.Context, req *userv1.AssignCafeOwnerRequest, opts ...grpc.CallOption) (*userv1.AssignCafeOwnerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*userv1.AssignCafeOwnerResponse), args.Error(1)
+}
+
 // MockMenuServiceClient simulates the menu service
 type MockMenuServiceClient struct {
 	mock.Mock
@@ -77,47 +111,57 @@ func (m *MockMenuServiceClient) GetMenuItems(ctx context.Context, req *menuv1.Ge
 	return args.Get(0).(*menuv1.GetMenuItemsResponse), args.Error(1)
 }
 
-func setupTestDB(t *testing.T) *gormDB.DB {
-	db, err := gormDB.Open(sqlite.Open("file::memory:?cache=shared"), &gormDB.Config{})
-	require.NoError(t, err)
+func (m *MockMenuServiceClient) SetAvailability(ctx context.Context, req *menuv1.SetAvailabilityRequest, opts ...grpc.CallOption) (*menuv1.SetAvailabilityResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*menuv1.SetAvailabilityResponse), args.Error(1)
+}
 
-	err = db.AutoMigrate(&models.Order{}, &models.OrderItem{})
-	require.NoError(t, err)
+func (m *MockMenuServiceClient) SchedulePriceChange(ctx context.Context, req *menuv1.SchedulePriceChangeRequest, opts ...grpc.CallOption) (*menuv1.SchedulePriceChangeResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*menuv1.SchedulePriceChangeResponse), args.Error(1)
+}
+
+const testCafeID = 1
 
-	return db
+var orderTime = time.Date(2025, 6, 2, 9, 30, 0, 0, time.UTC)
+
+func fixedNow() time.Time {
+	return orderTime
 }
 
-func teardownTestDB(t *testing.T, db *gormDB.DB) {
-	sqlDB, err := db.DB()
-	require.NoError(t, err)
-	sqlDB.Close()
+func menuItemRequest(id uint32) *menuv1.GetMenuItemRequest {
+	return &menuv1.GetMenuItemRequest{Id: id, At: timestamppb.New(orderTime), CafeId: testCafeID}
 }
 
 func TestCreateOrder_Success(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
+	repo := repository.NewMemoryOrderRepository()
 	mockUserClient := new(MockUserServiceClient)
 	mockMenuClient := new(MockMenuServiceClient)
 
-	server := &OrderServer{
-		UserClient: mockUserClient,
-		MenuClient: mockMenuClient,
-	}
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
 
 	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
 		Return(&userv1.GetUserResponse{
 			User: &userv1.User{Id: 1, Name: "Test User"},
 		}, nil)
 
-	mockMenuClient.On("GetMenuItem", mock.Anything, &menuv1.GetMenuItemRequest{Id: 1}).
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
 		Return(&menuv1.GetMenuItemResponse{
-			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.50},
+			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.50, Available: true},
 		}, nil)
 
 	ctx := context.Background()
 	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
 		UserId: 1,
 		Items: []*orderv1.OrderItemRequest{
 			{MenuItemId: 1, Quantity: 2},
@@ -135,23 +179,21 @@ func TestCreateOrder_Success(t *testing.T) {
 }
 
 func TestCreateOrder_InvalidUser(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
+	repo := repository.NewMemoryOrderRepository()
 	mockUserClient := new(MockUserServiceClient)
 	mockMenuClient := new(MockMenuServiceClient)
 
-	server := &OrderServer{
-		UserClient: mockUserClient,
-		MenuClient: mockMenuClient,
-	}
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
 
 	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 999}).
 		Return(nil, status.Errorf(codes.NotFound, "user not found"))
 
 	ctx := context.Background()
 	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
 		UserId: 999,
 		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
 	})
@@ -165,28 +207,26 @@ func TestCreateOrder_InvalidUser(t *testing.T) {
 }
 
 func TestCreateOrder_InvalidMenuItem(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
+	repo := repository.NewMemoryOrderRepository()
 	mockUserClient := new(MockUserServiceClient)
 	mockMenuClient := new(MockMenuServiceClient)
 
-	server := &OrderServer{
-		UserClient: mockUserClient,
-		MenuClient: mockMenuClient,
-	}
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
 
 	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
 		Return(&userv1.GetUserResponse{
 			User: &userv1.User{Id: 1, Name: "Test User"},
 		}, nil)
 
-	mockMenuClient.On("GetMenuItem", mock.Anything, &menuv1.GetMenuItemRequest{Id: 999}).
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(999)).
 		Return(nil, status.Errorf(codes.NotFound, "menu item not found"))
 
 	ctx := context.Background()
 	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
 		UserId: 1,
 		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 999, Quantity: 1}},
 	})
@@ -196,18 +236,111 @@ func TestCreateOrder_InvalidMenuItem(t *testing.T) {
 	assert.Contains(t, err.Error(), "menu item 999 not found")
 }
 
+func TestCreateOrder_RecordsMetrics(t *testing.T) {
+	t.Parallel()
+
+	repo := repository.NewMemoryOrderRepository()
+	mockUserClient := new(MockUserServiceClient)
+	mockMenuClient := new(MockMenuServiceClient)
+
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
+	server.Metrics = NewOrderMetrics(prometheus.NewRegistry())
+
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
+		Return(&userv1.GetUserResponse{User: &userv1.User{Id: 1}}, nil)
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
+		Return(&menuv1.GetMenuItemResponse{
+			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.50, Available: true},
+		}, nil)
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(999)).
+		Return(nil, status.Errorf(codes.NotFound, "menu item not found"))
+
+	ctx := context.Background()
+	_, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 3}},
+	})
+	require.NoError(t, err)
+	_, err = server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 999, Quantity: 1}},
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.created.WithLabelValues("1")))
+	expected := `
+# HELP order_value Total price of each order created, by cafe.
+# TYPE order_value histogram
+order_value_bucket{cafe_id="1",le="2"} 0
+order_value_bucket{cafe_id="1",le="5"} 0
+order_value_bucket{cafe_id="1",le="10"} 1
+order_value_bucket{cafe_id="1",le="20"} 1
+order_value_bucket{cafe_id="1",le="50"} 1
+order_value_bucket{cafe_id="1",le="100"} 1
+order_value_bucket{cafe_id="1",le="200"} 1
+order_value_bucket{cafe_id="1",le="500"} 1
+order_value_bucket{cafe_id="1",le="+Inf"} 1
+order_value_sum{cafe_id="1"} 7.5
+order_value_count{cafe_id="1"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(server.Metrics.value, strings.NewReader(expected)))
+}
+
+func TestCreateOrder_UpstreamUnavailable(t *testing.T) {
+	t.Parallel()
+
+	repo := repository.NewMemoryOrderRepository()
+	mockUserClient := new(MockUserServiceClient)
+	mockMenuClient := new(MockMenuServiceClient)
+
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
+
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
+		Return(&userv1.GetUserResponse{
+			User: &userv1.User{Id: 1, Name: "Test User"},
+		}, nil)
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 2}).
+		Return(nil, status.Errorf(codes.Unavailable, "user-service: circuit breaker open"))
+
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
+		Return(nil, status.Errorf(codes.DeadlineExceeded, "context deadline exceeded"))
+
+	ctx := context.Background()
+
+	_, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 2,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "user service unavailable")
+
+	_, err = server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "menu service unavailable")
+
+	orders, err := repo.ListOrders(ctx, testCafeID)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
 func TestCreateOrder_EmptyOrder(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
+	repo := repository.NewMemoryOrderRepository()
 	mockUserClient := new(MockUserServiceClient)
 	mockMenuClient := new(MockMenuServiceClient)
 
-	server := &OrderServer{
-		UserClient: mockUserClient,
-		MenuClient: mockMenuClient,
-	}
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
 
 	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
 		Return(&userv1.GetUserResponse{
@@ -216,6 +349,7 @@ func TestCreateOrder_EmptyOrder(t *testing.T) {
 
 	ctx := context.Background()
 	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
 		UserId: 1,
 		Items:  []*orderv1.OrderItemRequest{},
 	})
@@ -226,17 +360,14 @@ func TestCreateOrder_EmptyOrder(t *testing.T) {
 }
 
 func TestGetOrder(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
+	repo := repository.NewMemoryOrderRepository()
 	mockUserClient := new(MockUserServiceClient)
 	mockMenuClient := new(MockMenuServiceClient)
 
-	server := &OrderServer{
-		UserClient: mockUserClient,
-		MenuClient: mockMenuClient,
-	}
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
 
 	// Create test order
 	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
@@ -244,13 +375,14 @@ func TestGetOrder(t *testing.T) {
 			User: &userv1.User{Id: 1, Name: "Test User"},
 		}, nil)
 
-	mockMenuClient.On("GetMenuItem", mock.Anything, &menuv1.GetMenuItemRequest{Id: 1}).
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
 		Return(&menuv1.GetMenuItemResponse{
-			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.50},
+			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.50, Available: true},
 		}, nil)
 
 	ctx := context.Background()
 	createResp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
 		UserId: 1,
 		Items: []*orderv1.OrderItemRequest{
 			{MenuItemId: 1, Quantity: 2},
@@ -259,8 +391,148 @@ func TestGetOrder(t *testing.T) {
 	require.NoError(t, err)
 
 	// Test GetOrder
-	getResp, err := server.GetOrder(ctx, &orderv1.GetOrderRequest{Id: createResp.Order.Id})
+	getResp, err := server.GetOrder(ctx, &orderv1.GetOrderRequest{CafeId: testCafeID, Id: createResp.Order.Id})
 	require.NoError(t, err)
 	assert.Equal(t, createResp.Order.Id, getResp.Order.Id)
 	assert.Len(t, getResp.Order.OrderItems, 1)
 }
+
+func TestCreateOrder_UnavailableMenuItem(t *testing.T) {
+	t.Parallel()
+
+	repo := repository.NewMemoryOrderRepository()
+	mockUserClient := new(MockUserServiceClient)
+	mockMenuClient := new(MockMenuServiceClient)
+
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
+
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
+		Return(&userv1.GetUserResponse{
+			User: &userv1.User{Id: 1, Name: "Test User"},
+		}, nil)
+
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(2)).
+		Return(&menuv1.GetMenuItemResponse{
+			MenuItem: &menuv1.MenuItem{Id: 2, Name: "Pancakes", Price: 6.00, Available: false},
+		}, nil)
+
+	ctx := context.Background()
+	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 2, Quantity: 1}},
+	})
+
+	require.Error(t, err)
+	assert.Nil(t, resp)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Contains(t, st.Message(), "menu item 2 is not available")
+
+	orders, err := repo.ListOrders(ctx, testCafeID)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+func TestCreateOrder_UsesPriceAtOrderTime(t *testing.T) {
+	t.Parallel()
+
+	repo := repository.NewMemoryOrderRepository()
+	mockUserClient := new(MockUserServiceClient)
+	mockMenuClient := new(MockMenuServiceClient)
+
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
+
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
+		Return(&userv1.GetUserResponse{
+			User: &userv1.User{Id: 1, Name: "Test User"},
+		}, nil)
+
+	// The menu service is asked for the item as of the order timestamp and
+	// answers with the scheduled price in effect then.
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
+		Return(&menuv1.GetMenuItemResponse{
+			MenuItem: &menuv1.MenuItem{Id: 1, Name: "Coffee", Price: 2.75, Available: true},
+		}, nil)
+
+	ctx := context.Background()
+	resp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	require.NoError(t, err)
+	assert.InDelta(t, 2.75, resp.Order.OrderItems[0].Price, 0.001)
+
+	order, err := repo.GetOrder(ctx, testCafeID, uint(resp.Order.Id))
+	require.NoError(t, err)
+	assert.True(t, order.CreatedAt.Equal(orderTime))
+
+	mockMenuClient.AssertExpectations(t)
+}
+
+func TestOrdersAreScopedToCafe(t *testing.T) {
+	t.Parallel()
+
+	repo := repository.NewMemoryOrderRepository()
+	mockUserClient := new(MockUserServiceClient)
+	mockMenuClient := new(MockMenuServiceClient)
+
+	server := NewOrderServer(repo, mockUserClient, mockMenuClient)
+	server.Now = fixedNow
+
+	mockUserClient.On("GetUser", mock.Anything, &userv1.GetUserRequest{Id: 1}).
+		Return(&userv1.GetUserResponse{
+			User: &userv1.User{Id: 1, Name: "Test User"},
+		}, nil)
+
+	mockMenuClient.On("GetMenuItem", mock.Anything, menuItemRequest(1)).
+		Return(&menuv1.GetMenuItemResponse{
+			MenuItem: &menuv1.MenuItem{Id: 1, CafeId: testCafeID, Name: "Coffee", Price: 2.50, Available: true},
+		}, nil)
+
+	// Menu item 1 belongs to another cafe, so the menu service hides it.
+	otherCafeRequest := menuItemRequest(1)
+	otherCafeRequest.CafeId = 2
+	mockMenuClient.On("GetMenuItem", mock.Anything, otherCafeRequest).
+		Return(nil, status.Errorf(codes.NotFound, "menu item not found"))
+
+	ctx := context.Background()
+	createResp, err := server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: testCafeID,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(testCafeID), createResp.Order.CafeId)
+
+	_, err = server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: 2,
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "menu item 1 not found")
+
+	_, err = server.GetOrder(ctx, &orderv1.GetOrderRequest{CafeId: 2, Id: createResp.Order.Id})
+	st, _ := status.FromError(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+
+	ordersResp, err := server.GetOrders(ctx, &orderv1.GetOrdersRequest{CafeId: 2})
+	require.NoError(t, err)
+	assert.Empty(t, ordersResp.Orders)
+
+	ordersResp, err = server.GetOrders(ctx, &orderv1.GetOrdersRequest{CafeId: testCafeID})
+	require.NoError(t, err)
+	assert.Len(t, ordersResp.Orders, 1)
+
+	_, err = server.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		UserId: 1,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: 1, Quantity: 1}},
+	})
+	st, _ = status.FromError(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+}
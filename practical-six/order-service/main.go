@@ -1,63 +1,221 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/practical6/order-service/database"
 	"github.com/practical6/order-service/grpc"
+	"github.com/practical6/order-service/repository"
+	"github.com/practical6/pkg/config"
+	"github.com/practical6/pkg/discovery"
+	"github.com/practical6/pkg/healthcheck"
+	"github.com/practical6/pkg/lb"
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/metrics"
+	"github.com/practical6/pkg/mtls"
+	"github.com/practical6/pkg/rpcclient"
+	"github.com/practical6/pkg/serve"
+	"github.com/practical6/pkg/tracing"
 	menuv1 "github.com/practical6/proto/menu/v1"
 	orderv1 "github.com/practical6/proto/order/v1"
 	userv1 "github.com/practical6/proto/user/v1"
+	"go.opentelemetry.io/otel"
 	grpcClient "google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	grpcServer "google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// callers says which services may call which RPCs when mutual TLS is on.
+// Only the gateway calls order-service.
+var callers = mtls.Policy{
+	"/order.v1.OrderService/": {mtls.ID("api-gateway")},
+	"/grpc.health.v1.Health/": {mtls.ID("api-gateway")},
+}
+
+type Config struct {
+	GRPCPort        int              `yaml:"grpc_port" env:"GRPC_PORT" flag:"grpc-port" usage:"port to serve gRPC on" required:"true"`
+	UserServiceAddr string           `yaml:"user_service_addr" env:"USER_SERVICE_ADDR" flag:"user-service-addr" usage:"user-service host:port, dns:///host:port for every address it resolves to, consul:///user-service for its healthy instances in Consul, or a comma-separated list" required:"true"`
+	MenuServiceAddr string           `yaml:"menu_service_addr" env:"MENU_SERVICE_ADDR" flag:"menu-service-addr" usage:"menu-service host:port, dns:///host:port, consul:///menu-service or a comma-separated list" required:"true"`
+	RPCTimeout      time.Duration    `yaml:"rpc_timeout" env:"RPC_TIMEOUT" flag:"rpc-timeout" usage:"deadline for each call to the user and menu services, retries included"`
+	MetricsPort     int              `yaml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" usage:"admin port to serve /metrics and /loglevel on" required:"true"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to drain in-flight RPCs on shutdown"`
+	DB              config.Database  `yaml:"db"`
+	LogLevel        string           `yaml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error; PUT /loglevel on the admin port changes it at runtime"`
+	Tracing         tracing.Config   `yaml:"tracing"`
+	TLS             mtls.Config      `yaml:"tls"`
+	Discovery       discovery.Config `yaml:"discovery"`
+	LoadBalancing   lb.Config        `yaml:"load_balancing"`
+}
+
+func (c Config) Validate() error {
+	return c.LoadBalancing.Validate()
+}
+
 func main() {
-	database.InitDB()
+	cfg := Config{
+		GRPCPort:        50053,
+		RPCTimeout:      3 * time.Second,
+		MetricsPort:     9093,
+		ShutdownTimeout: 15 * time.Second,
+		DB:              config.DefaultDatabase("orderdb"),
+		LogLevel:        "info",
+		Tracing:         tracing.DefaultConfig(),
+		TLS:             mtls.DefaultConfig(),
+		Discovery:       discovery.DefaultConfig(),
+		LoadBalancing:   lb.DefaultConfig(),
+	}
+	logLevel := logging.Setup("order-service")
+	args := config.MustLoad("order-service", &cfg)
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		logging.Fatal("Invalid log level", "err", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := database.Migrate(context.Background(), database.Connect(cfg.DB), args[1:], os.Stdout); err != nil {
+			logging.Fatal("Migration failed", "err", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, "order-service", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "err", err)
+		}
+	}()
+	tracerProvider := otel.GetTracerProvider()
+
+	creds, err := mtls.Load(cfg.TLS)
+	if err != nil {
+		logging.Fatal("Failed to load TLS certificates", "err", err)
+	}
+	go creds.Run(ctx)
+
+	serviceRegistry, err := discovery.New(cfg.Discovery)
+	if err != nil {
+		logging.Fatal("Failed to set up service discovery", "err", err)
+	}
+
+	db := database.InitDB(cfg.DB)
+	if err := db.Use(tracing.GORM(tracerProvider)); err != nil {
+		logging.Fatal("Failed to instrument database", "err", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		logging.Fatal("Failed to get database handle", "err", err)
+	}
+	defer sqlDB.Close()
+
+	rpcConfig := rpcclient.DefaultConfig()
+	rpcConfig.Timeout = cfg.RPCTimeout
+	userUpstream := rpcclient.New("user-service", rpcConfig)
+	menuUpstream := rpcclient.New("menu-service", rpcConfig)
+
+	registry := metrics.NewRegistry()
+	registry.MustRegister(rpcclient.Collector(userUpstream, menuUpstream))
+	recorder := metrics.NewRecorder(registry)
 
 	// Connect to user service
 	userConn, err := grpcClient.Dial(
-		getEnv("USER_SERVICE_ADDR", "localhost:50051"),
-		grpcClient.WithTransportCredentials(insecure.NewCredentials()),
+		lb.Target(cfg.UserServiceAddr),
+		grpcClient.WithTransportCredentials(creds.Client()),
+		cfg.LoadBalancing.DialOption(userv1.UserService_ServiceDesc.ServiceName),
+		discovery.DialOption(serviceRegistry),
+		grpcClient.WithChainUnaryInterceptor(logging.UnaryClientInterceptor(), recorder.UnaryClientInterceptor()),
+		grpcClient.WithChainStreamInterceptor(logging.StreamClientInterceptor(), recorder.StreamClientInterceptor()),
+		userUpstream.DialOption(),
+		tracing.DialOption(tracerProvider),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to user service: %v", err)
+		logging.Fatal("Failed to connect to user service", "err", err)
 	}
 	defer userConn.Close()
 	userClient := userv1.NewUserServiceClient(userConn)
 
 	// Connect to menu service
 	menuConn, err := grpcClient.Dial(
-		getEnv("MENU_SERVICE_ADDR", "localhost:50052"),
-		grpcClient.WithTransportCredentials(insecure.NewCredentials()),
+		lb.Target(cfg.MenuServiceAddr),
+		grpcClient.WithTransportCredentials(creds.Client()),
+		cfg.LoadBalancing.DialOption(menuv1.MenuService_ServiceDesc.ServiceName),
+		discovery.DialOption(serviceRegistry),
+		grpcClient.WithChainUnaryInterceptor(logging.UnaryClientInterceptor(), recorder.UnaryClientInterceptor()),
+		grpcClient.WithChainStreamInterceptor(logging.StreamClientInterceptor(), recorder.StreamClientInterceptor()),
+		menuUpstream.DialOption(),
+		tracing.DialOption(tracerProvider),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to menu service: %v", err)
+		logging.Fatal("Failed to connect to menu service", "err", err)
 	}
 	defer menuConn.Close()
 	menuClient := menuv1.NewMenuServiceClient(menuConn)
 
-	port := getEnv("GRPC_PORT", "50053")
-	lis, err := net.Listen("tcp", ":"+port)
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to listen", "err", err)
 	}
 
-	s := grpcServer.NewServer()
-	orderv1.RegisterOrderServiceServer(s, grpc.NewOrderServer(userClient, menuClient))
+	// Orders can only be placed while the user and menu services are up, so
+	// they count towards this service's readiness.
+	healthServer := health.NewServer()
+	checker := healthcheck.NewChecker(healthServer, orderv1.OrderService_ServiceDesc.ServiceName)
+	checker.Add("database", healthcheck.DB(sqlDB))
+	checker.Add("user-service", healthcheck.GRPC(userConn, userv1.UserService_ServiceDesc.ServiceName))
+	checker.Add("menu-service", healthcheck.GRPC(menuConn, menuv1.MenuService_ServiceDesc.ServiceName))
+	go checker.Run(ctx)
 
-	log.Printf("Order service listening on port %s", port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	// Registered only once listening, and withdrawn as soon as shutdown
+	// starts; Consul hides the instance while its health checks fail.
+	if serviceRegistry != nil {
+		instance, err := cfg.Discovery.Instance("order-service", cfg.GRPCPort)
+		if err != nil {
+			logging.Fatal("Failed to describe this instance", "err", err)
+		}
+		if err := discovery.Announce(ctx, serviceRegistry, instance, checker.Err); err != nil {
+			logging.Fatal("Failed to register with Consul", "err", err)
+		}
 	}
-}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	admin := http.NewServeMux()
+	admin.Handle("/metrics", metrics.Handler(registry))
+	admin.Handle("/loglevel", logging.LevelHandler(logLevel))
+	go func() {
+		if err := serve.HTTP(ctx, serve.AdminServer(cfg.MetricsPort, admin), cfg.ShutdownTimeout); err != nil {
+			slog.Error("Admin server failed", "err", err)
+		}
+	}()
+
+	orderServer := grpc.NewOrderServer(repository.NewGormOrderRepository(db), userClient, menuClient)
+	orderServer.Metrics = grpc.NewOrderMetrics(registry)
+
+	s := grpcServer.NewServer(
+		grpcServer.Creds(creds.Server()),
+		tracing.ServerOption(tracerProvider),
+		grpcServer.ChainUnaryInterceptor(logging.UnaryServerInterceptor(), recorder.UnaryServerInterceptor(), creds.UnaryServerInterceptor(callers)),
+		grpcServer.ChainStreamInterceptor(logging.StreamServerInterceptor(), recorder.StreamServerInterceptor(), creds.StreamServerInterceptor(callers)),
+	)
+	orderv1.RegisterOrderServiceServer(s, orderServer)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	slog.Info("Order service listening", "port", cfg.GRPCPort)
+	if err := serve.GRPC(ctx, s, lis, cfg.ShutdownTimeout); err != nil {
+		logging.Fatal("Failed to serve", "err", err)
 	}
-	return defaultValue
+	slog.Info("Order service stopped")
 }
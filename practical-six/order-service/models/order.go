@@ -4,6 +4,7 @@ import "gorm.io/gorm"
 
 type Order struct {
 	gorm.Model
+	CafeID     uint        `gorm:"not null;index"`
 	UserID     uint        `gorm:"not null"`
 	Status     string      `gorm:"default:'pending'"`
 	OrderItems []OrderItem `gorm:"foreignKey:OrderID"`
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/order-service/models"
+	"gorm.io/gorm"
+)
+
+type gormOrderRepository struct {
+	db *gorm.DB
+}
+
+func NewGormOrderRepository(db *gorm.DB) OrderRepository {
+	return &gormOrderRepository{db: db}
+}
+
+// forCafe scopes a query to a single cafe's orders.
+func (r *gormOrderRepository) forCafe(ctx context.Context, cafeID uint) *gorm.DB {
+	return r.db.WithContext(ctx).Where("cafe_id = ?", cafeID)
+}
+
+func (r *gormOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	return r.db.WithContext(ctx).Create(order).Error
+}
+
+func (r *gormOrderRepository) GetOrder(ctx context.Context, cafeID, id uint) (*models.Order, error) {
+	var order models.Order
+	if err := r.forCafe(ctx, cafeID).Preload("OrderItems").First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (r *gormOrderRepository) ListOrders(ctx context.Context, cafeID uint) ([]models.Order, error) {
+	var orders []models.Order
+	if err := r.forCafe(ctx, cafeID).Preload("OrderItems").Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/practical6/order-service/models"
+)
+
+type memoryOrderRepository struct {
+	mu         sync.RWMutex
+	orders     map[uint]models.Order
+	nextID     uint
+	nextItemID uint
+}
+
+// NewMemoryOrderRepository returns an empty repository that keeps everything
+// in memory. It is safe for concurrent use.
+func NewMemoryOrderRepository() OrderRepository {
+	return &memoryOrderRepository{orders: make(map[uint]models.Order)}
+}
+
+func (r *memoryOrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.nextID++
+	order.ID = r.nextID
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = now
+	}
+	order.UpdatedAt = now
+	for i := range order.OrderItems {
+		r.nextItemID++
+		order.OrderItems[i].ID = r.nextItemID
+		order.OrderItems[i].OrderID = order.ID
+		order.OrderItems[i].CreatedAt = now
+		order.OrderItems[i].UpdatedAt = now
+	}
+
+	r.orders[order.ID] = clone(*order)
+	return nil
+}
+
+func (r *memoryOrderRepository) GetOrder(ctx context.Context, cafeID, id uint) (*models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	order, ok := r.orders[id]
+	if !ok || order.CafeID != cafeID {
+		return nil, ErrNotFound
+	}
+	order = clone(order)
+	return &order, nil
+}
+
+func (r *memoryOrderRepository) ListOrders(ctx context.Context, cafeID uint) ([]models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var orders []models.Order
+	for _, order := range r.orders {
+		if order.CafeID == cafeID {
+			orders = append(orders, clone(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders, nil
+}
+
+// clone copies the order's items so callers cannot mutate stored state.
+func clone(order models.Order) models.Order {
+	order.OrderItems = append([]models.OrderItem(nil), order.OrderItems...)
+	return order
+}
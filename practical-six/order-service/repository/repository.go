@@ -0,0 +1,24 @@
+// Package repository defines the storage interface used by the order service,
+// with a GORM implementation for production and an in-memory one for tests.
+//
+// Every lookup takes the owning cafe's ID: an order that exists but belongs to
+// another cafe is reported as ErrNotFound.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/order-service/models"
+)
+
+// ErrNotFound is returned when the requested order does not exist in the cafe.
+var ErrNotFound = errors.New("record not found")
+
+type OrderRepository interface {
+	// CreateOrder stores the order and its items. order.CafeID must be set.
+	CreateOrder(ctx context.Context, order *models.Order) error
+	// GetOrder returns the order with its items.
+	GetOrder(ctx context.Context, cafeID, id uint) (*models.Order, error)
+	ListOrders(ctx context.Context, cafeID uint) ([]models.Order, error)
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/practical6/order-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newGormRepository opens a private in-memory SQLite database so tests can
+// run in parallel without sharing tables.
+func newGormRepository(t *testing.T) OrderRepository {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&models.Order{}, &models.OrderItem{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		sqlDB.Close()
+	})
+	return NewGormOrderRepository(db)
+}
+
+var implementations = []struct {
+	name string
+	new  func(t *testing.T) OrderRepository
+}{
+	{"gorm", newGormRepository},
+	{"memory", func(t *testing.T) OrderRepository { return NewMemoryOrderRepository() }},
+}
+
+func TestOrderRepository(t *testing.T) {
+	t.Parallel()
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := impl.new(t)
+			ctx := context.Background()
+
+			orderedAt := time.Date(2025, 6, 2, 9, 30, 0, 0, time.UTC)
+			order := models.Order{
+				Model:  gorm.Model{CreatedAt: orderedAt},
+				CafeID: 1,
+				UserID: 7,
+				Status: "pending",
+				OrderItems: []models.OrderItem{
+					{MenuItemID: 1, MenuItemName: "Latte", Quantity: 2, Price: 3.50},
+					{MenuItemID: 2, MenuItemName: "Muffin", Quantity: 1, Price: 2.25},
+				},
+			}
+			require.NoError(t, repo.CreateOrder(ctx, &order))
+			assert.NotZero(t, order.ID)
+
+			other := models.Order{CafeID: 2, UserID: 8, Status: "pending"}
+			require.NoError(t, repo.CreateOrder(ctx, &other))
+
+			found, err := repo.GetOrder(ctx, 1, order.ID)
+			require.NoError(t, err)
+			assert.Equal(t, uint(7), found.UserID)
+			assert.True(t, found.CreatedAt.Equal(orderedAt))
+			require.Len(t, found.OrderItems, 2)
+			assert.Equal(t, order.ID, found.OrderItems[0].OrderID)
+
+			_, err = repo.GetOrder(ctx, 2, order.ID)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			orders, err := repo.ListOrders(ctx, 2)
+			require.NoError(t, err)
+			require.Len(t, orders, 1)
+			assert.Equal(t, other.ID, orders[0].ID)
+
+			orders, err = repo.ListOrders(ctx, 3)
+			require.NoError(t, err)
+			assert.Empty(t, orders)
+		})
+	}
+}
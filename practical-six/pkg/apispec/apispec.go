@@ -0,0 +1,422 @@
+// Package apispec checks HTTP requests against an OpenAPI v2 document, such
+// as the one generated from the protos, before they are passed on.
+//
+// Validation is strict: bodies may only hold the fields the document
+// declares, required fields must be present, and values must have the
+// declared type and meet its constraints. Every offending field is reported,
+// not just the first one.
+package apispec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxBodySize is the largest request body Parse allows by default.
+const DefaultMaxBodySize = 1 << 20
+
+// ErrBodyTooLarge is returned for a request body over Spec.MaxBodySize.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Violation describes a field that does not match the document. Field is a
+// path such as "items[0].quantity"; it is empty for the body as a whole.
+type Violation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError lists everything wrong with a request.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Description
+		if v.Field != "" {
+			msgs[i] = v.Field + ": " + v.Description
+		}
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// Spec is a parsed OpenAPI v2 document.
+type Spec struct {
+	// MaxBodySize caps request bodies. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+
+	operations  []*Operation
+	definitions map[string]*schema
+	patterns    map[string]*regexp.Regexp
+}
+
+// Operation is one method on one path of the document.
+type Operation struct {
+	Method string
+	// Path is the path template, e.g. "/api/users/{id}".
+	Path string
+	Tags []string
+
+	spec       *Spec
+	parameters []*parameter
+}
+
+// schema is the subset of an OpenAPI v2 schema object that requests are
+// checked against.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Enum                 []string           `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+}
+
+// parameter is a path, query or body parameter. Path and query parameters
+// describe their value inline; the body has a schema.
+type parameter struct {
+	schema
+	Name             string  `json:"name"`
+	In               string  `json:"in"`
+	Required         bool    `json:"required"`
+	Schema           *schema `json:"schema"`
+	CollectionFormat string  `json:"collectionFormat"`
+}
+
+// Parse reads an OpenAPI v2 document.
+func Parse(doc []byte) (*Spec, error) {
+	var raw struct {
+		Paths map[string]map[string]struct {
+			Tags       []string     `json:"tags"`
+			Parameters []*parameter `json:"parameters"`
+		} `json:"paths"`
+		Definitions map[string]*schema `json:"definitions"`
+	}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+
+	s := &Spec{
+		MaxBodySize: DefaultMaxBodySize,
+		definitions: raw.Definitions,
+		patterns:    make(map[string]*regexp.Regexp),
+	}
+	for _, path := range slices.Sorted(maps.Keys(raw.Paths)) {
+		methods := raw.Paths[path]
+		for _, method := range slices.Sorted(maps.Keys(methods)) {
+			op := methods[method]
+			s.operations = append(s.operations, &Operation{
+				Method:     strings.ToUpper(method),
+				Path:       path,
+				Tags:       op.Tags,
+				spec:       s,
+				parameters: op.Parameters,
+			})
+			for _, param := range op.Parameters {
+				if err := s.compile(&param.schema); err != nil {
+					return nil, err
+				}
+				if err := s.compile(param.Schema); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, def := range raw.Definitions {
+		if err := s.compile(def); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// compile checks the patterns in sch up front, so a bad one fails Parse
+// rather than a request.
+func (s *Spec) compile(sch *schema) error {
+	if sch == nil {
+		return nil
+	}
+	if sch.Pattern != "" && s.patterns[sch.Pattern] == nil {
+		re, err := regexp.Compile(sch.Pattern)
+		if err != nil {
+			return fmt.Errorf("parse OpenAPI document: pattern %q: %w", sch.Pattern, err)
+		}
+		s.patterns[sch.Pattern] = re
+	}
+	for _, prop := range sch.Properties {
+		if err := s.compile(prop); err != nil {
+			return err
+		}
+	}
+	return s.compile(sch.Items)
+}
+
+// Operations returns every operation in the document, ordered by path and
+// then method.
+func (s *Spec) Operations() []*Operation {
+	return s.operations
+}
+
+// resolve follows sch's $ref, if it has one, to the definition it names.
+func (s *Spec) resolve(sch *schema) *schema {
+	for sch != nil && sch.Ref != "" {
+		sch = s.definitions[strings.TrimPrefix(sch.Ref, "#/definitions/")]
+	}
+	return sch
+}
+
+// Validate checks r against the operation. vars holds the path parameters
+// the router matched; parameters it lacks are not checked. The body is read
+// and replaced, so the next handler can read it again.
+//
+// The error is a *ValidationError for a request that breaks the document,
+// ErrBodyTooLarge, or describes a body that is not JSON.
+func (op *Operation) Validate(r *http.Request, vars map[string]string) error {
+	var violations []Violation
+	query := r.URL.Query()
+	for _, param := range op.parameters {
+		switch param.In {
+		case "path":
+			if value, ok := vars[param.Name]; ok {
+				op.spec.validateString(value, &param.schema, param.Name, &violations)
+			}
+		case "query":
+			op.validateQuery(query[param.Name], param, &violations)
+		case "body":
+			if err := op.validateBody(r, param, &violations); err != nil {
+				return err
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func (op *Operation) validateQuery(values []string, param *parameter, violations *[]Violation) {
+	switch {
+	case len(values) == 0:
+		if param.Required {
+			*violations = append(*violations, Violation{param.Name, "is required"})
+		}
+	case param.Type == "array" && param.CollectionFormat == "multi":
+		for i, value := range values {
+			op.spec.validateString(value, param.Items, fmt.Sprintf("%s[%d]", param.Name, i), violations)
+		}
+	case len(values) > 1:
+		*violations = append(*violations, Violation{param.Name, "must be given once"})
+	default:
+		op.spec.validateString(values[0], &param.schema, param.Name, violations)
+	}
+}
+
+func (op *Operation) validateBody(r *http.Request, param *parameter, violations *[]Violation) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, op.spec.MaxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	if int64(len(body)) > op.spec.MaxBodySize {
+		return ErrBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if param.Required {
+			*violations = append(*violations, Violation{"", "body is required"})
+		}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("request body is not valid JSON: %w", err)
+	}
+	if dec.More() {
+		return errors.New("request body is not valid JSON: data after the top-level value")
+	}
+	op.spec.validateValue(value, param.Schema, "", violations)
+	return nil
+}
+
+// validateString checks a path or query parameter, which arrives as text
+// whatever its type.
+func (s *Spec) validateString(value string, sch *schema, field string, violations *[]Violation) {
+	sch = s.resolve(sch)
+	if sch == nil {
+		return
+	}
+	switch sch.Type {
+	case "integer", "number":
+		s.validateValue(json.Number(value), sch, field, violations)
+	case "boolean":
+		if value != "true" && value != "false" {
+			*violations = append(*violations, Violation{field, "must be true or false"})
+		}
+	default:
+		s.validateValue(value, sch, field, violations)
+	}
+}
+
+// validateValue checks a decoded JSON value against sch. JSON null stands
+// for a field left unset, so it is only an error where the field is required.
+func (s *Spec) validateValue(value interface{}, sch *schema, field string, violations *[]Violation) {
+	sch = s.resolve(sch)
+	if sch == nil || value == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{field, fmt.Sprintf(format, args...)})
+	}
+
+	switch sch.Type {
+	case "object", "":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			if sch.Type == "object" {
+				fail("must be an object")
+			}
+			return
+		}
+		for _, name := range sch.Required {
+			if obj[name] == nil {
+				*violations = append(*violations, Violation{join(field, name), "is required"})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			prop, ok := sch.Properties[name]
+			if !ok {
+				if sch.AdditionalProperties == nil {
+					*violations = append(*violations, Violation{join(field, name), "is not a known field"})
+				}
+				continue
+			}
+			s.validateValue(obj[name], prop, join(field, name), violations)
+		}
+
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if sch.MinItems != nil && len(arr) < *sch.MinItems {
+			fail("must have at least %d items", *sch.MinItems)
+		}
+		if sch.MaxItems != nil && len(arr) > *sch.MaxItems {
+			fail("must have at most %d items", *sch.MaxItems)
+		}
+		for i, item := range arr {
+			s.validateValue(item, sch.Items, fmt.Sprintf("%s[%d]", field, i), violations)
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		s.validateText(str, sch, fail)
+
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := strconv.ParseFloat(string(num), 64)
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if sch.Type == "integer" {
+			if f != math.Trunc(f) {
+				fail("must be an integer")
+				return
+			}
+			if sch.Format == "int32" && (f < math.MinInt32 || f > math.MaxInt32) {
+				fail("must fit in 32 bits")
+				return
+			}
+		}
+		if sch.Minimum != nil && f < *sch.Minimum {
+			fail("must be at least %s", formatBound(*sch.Minimum))
+		}
+		if sch.Maximum != nil && f > *sch.Maximum {
+			fail("must be at most %s", formatBound(*sch.Maximum))
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+		}
+	}
+}
+
+func (s *Spec) validateText(str string, sch *schema, fail func(string, ...interface{})) {
+	if len(sch.Enum) > 0 && !slices.Contains(sch.Enum, str) {
+		fail("must be one of %s", strings.Join(sch.Enum, ", "))
+		return
+	}
+	length := utf8.RuneCountInString(str)
+	if sch.MinLength != nil && length < *sch.MinLength {
+		if *sch.MinLength == 1 {
+			fail("must not be empty")
+		} else {
+			fail("must be at least %d characters", *sch.MinLength)
+		}
+	}
+	if sch.MaxLength != nil && length > *sch.MaxLength {
+		fail("must be at most %d characters", *sch.MaxLength)
+	}
+	if sch.Pattern != "" && !s.patterns[sch.Pattern].MatchString(str) {
+		fail("must match %s", sch.Pattern)
+	}
+	switch sch.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			fail("must be an RFC 3339 timestamp")
+		}
+	case "email":
+		if addr, err := mail.ParseAddress(str); err != nil || addr.Address != str {
+			fail("must be an email address")
+		}
+	}
+}
+
+// join appends a property name to a field path.
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,297 @@
+package apispec
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSpec is shaped like the document protoc-gen-openapiv2 generates: body
+// schemas are definitions reached through $ref.
+const testSpec = `{
+  "swagger": "2.0",
+  "paths": {
+    "/api/cafes/{cafe_id}/orders": {
+      "post": {
+        "tags": ["OrderService"],
+        "parameters": [
+          {"name": "cafe_id", "in": "path", "required": true, "type": "integer", "format": "int64"},
+          {"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/CreateOrderBody"}}
+        ]
+      },
+      "get": {
+        "tags": ["OrderService"],
+        "parameters": [
+          {"name": "cafe_id", "in": "path", "required": true, "type": "integer", "format": "int64"},
+          {"name": "at", "in": "query", "required": false, "type": "string", "format": "date-time"},
+          {"name": "days", "in": "query", "required": false, "type": "array", "collectionFormat": "multi",
+           "items": {"type": "string", "enum": ["MONDAY", "TUESDAY"]}}
+        ]
+      }
+    },
+    "/api/users": {
+      "post": {
+        "tags": ["UserService"],
+        "parameters": [
+          {"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/CreateUserRequest"}}
+        ]
+      }
+    }
+  },
+  "definitions": {
+    "CreateOrderBody": {
+      "type": "object",
+      "properties": {
+        "user_id": {"type": "integer", "format": "int64", "minimum": 1},
+        "items": {"type": "array", "items": {"type": "object", "$ref": "#/definitions/OrderItemRequest"}, "minItems": 1},
+        "note": {"type": "string", "maxLength": 10},
+        "pickup": {"type": "string", "pattern": "^[0-2][0-9]:[0-5][0-9]$"},
+        "extra": {"$ref": "#/definitions/Any"}
+      },
+      "required": ["user_id", "items"]
+    },
+    "OrderItemRequest": {
+      "type": "object",
+      "properties": {
+        "menu_item_id": {"type": "integer", "format": "int64", "minimum": 1},
+        "quantity": {"type": "integer", "format": "int32", "minimum": 1, "maximum": 99}
+      },
+      "required": ["menu_item_id", "quantity"]
+    },
+    "CreateUserRequest": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "email": {"type": "string", "format": "email"},
+        "is_cafe_owner": {"type": "boolean"}
+      },
+      "required": ["name", "email"]
+    },
+    "Any": {
+      "type": "object",
+      "properties": {"@type": {"type": "string"}},
+      "additionalProperties": {}
+    }
+  }
+}`
+
+func parse(t *testing.T) *Spec {
+	spec, err := Parse([]byte(testSpec))
+	require.NoError(t, err)
+	return spec
+}
+
+func operation(t *testing.T, spec *Spec, method, path string) *Operation {
+	for _, op := range spec.Operations() {
+		if op.Method == method && op.Path == path {
+			return op
+		}
+	}
+	t.Fatalf("no operation %s %s", method, path)
+	return nil
+}
+
+func violations(t *testing.T, err error) []Violation {
+	var invalid *ValidationError
+	require.ErrorAs(t, err, &invalid)
+	return invalid.Violations
+}
+
+func TestOperations(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	for _, op := range parse(t).Operations() {
+		got = append(got, op.Method+" "+op.Path+" "+strings.Join(op.Tags, ","))
+	}
+	assert.Equal(t, []string{
+		"GET /api/cafes/{cafe_id}/orders OrderService",
+		"POST /api/cafes/{cafe_id}/orders OrderService",
+		"POST /api/users UserService",
+	}, got)
+}
+
+func TestValidateBody(t *testing.T) {
+	t.Parallel()
+
+	createOrder := operation(t, parse(t), "POST", "/api/cafes/{cafe_id}/orders")
+	vars := map[string]string{"cafe_id": "1"}
+
+	for _, tc := range []struct {
+		name string
+		body string
+		want []Violation
+	}{
+		{
+			name: "valid",
+			body: `{"user_id": 7, "items": [{"menu_item_id": 1, "quantity": 2}], "note": "no sugar", "pickup": "08:30"}`,
+		},
+		{
+			name: "null is unset",
+			body: `{"user_id": 7, "items": [{"menu_item_id": 1, "quantity": 2}], "note": null}`,
+		},
+		{
+			name: "unknown fields are free in an Any",
+			body: `{"user_id": 7, "items": [{"menu_item_id": 1, "quantity": 2}], "extra": {"@type": "x", "value": 1}}`,
+		},
+		{
+			name: "misspelt field",
+			body: `{"userId": 1, "items": [{"menu_item_id": 1, "quantity": 1}]}`,
+			want: []Violation{
+				{"user_id", "is required"},
+				{"userId", "is not a known field"},
+			},
+		},
+		{
+			name: "every violation is reported",
+			body: `{"user_id": 0, "items": [{"menu_item_id": 1.5, "quantity": 0}, {"quantity": "2", "size": "L"}], "note": "far too long", "pickup": "noon"}`,
+			want: []Violation{
+				{"items[0].menu_item_id", "must be an integer"},
+				{"items[0].quantity", "must be at least 1"},
+				{"items[1].menu_item_id", "is required"},
+				{"items[1].quantity", "must be a number"},
+				{"items[1].size", "is not a known field"},
+				{"note", "must be at most 10 characters"},
+				{"pickup", "must match ^[0-2][0-9]:[0-5][0-9]$"},
+				{"user_id", "must be at least 1"},
+			},
+		},
+		{
+			name: "wrong shapes",
+			body: `{"user_id": 1, "items": {"menu_item_id": 1}}`,
+			want: []Violation{{"items", "must be an array"}},
+		},
+		{
+			name: "empty list",
+			body: `{"user_id": 1, "items": []}`,
+			want: []Violation{{"items", "must have at least 1 items"}},
+		},
+		{
+			name: "out of range",
+			body: `{"user_id": 1, "items": [{"menu_item_id": 1, "quantity": 100}, {"menu_item_id": 1, "quantity": 1e10}]}`,
+			want: []Violation{
+				{"items[0].quantity", "must be at most 99"},
+				{"items[1].quantity", "must fit in 32 bits"},
+			},
+		},
+		{
+			name: "not an object",
+			body: `[1, 2]`,
+			want: []Violation{{"", "must be an object"}},
+		},
+		{
+			name: "no body",
+			body: ``,
+			want: []Violation{{"", "body is required"}},
+		},
+	} {
+		r := httptest.NewRequest("POST", "/api/cafes/1/orders", strings.NewReader(tc.body))
+		err := createOrder.Validate(r, vars)
+		if tc.want == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.Equal(t, tc.want, violations(t, err), tc.name)
+		}
+
+		body, readErr := io.ReadAll(r.Body)
+		require.NoError(t, readErr)
+		assert.Equal(t, tc.body, string(body), "%s: the body can be read again", tc.name)
+	}
+}
+
+func TestValidateFormats(t *testing.T) {
+	t.Parallel()
+
+	createUser := operation(t, parse(t), "POST", "/api/users")
+
+	err := createUser.Validate(httptest.NewRequest("POST", "/api/users", strings.NewReader(
+		`{"name": "Ada", "email": "ada@example.com", "is_cafe_owner": true}`)), nil)
+	assert.NoError(t, err)
+
+	err = createUser.Validate(httptest.NewRequest("POST", "/api/users", strings.NewReader(
+		`{"name": "", "email": "Ada <ada@example.com>", "is_cafe_owner": "yes"}`)), nil)
+	assert.Equal(t, []Violation{
+		{"email", "must be an email address"},
+		{"is_cafe_owner", "must be true or false"},
+		{"name", "must not be empty"},
+	}, violations(t, err))
+	assert.EqualError(t, err, "invalid request: email: must be an email address; is_cafe_owner: must be true or false; name: must not be empty")
+}
+
+func TestValidateParameters(t *testing.T) {
+	t.Parallel()
+
+	getOrders := operation(t, parse(t), "GET", "/api/cafes/{cafe_id}/orders")
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		vars   map[string]string
+		want   []Violation
+	}{
+		{
+			name:   "valid",
+			target: "/api/cafes/1/orders?at=2024-05-01T09:00:00Z&days=MONDAY&days=TUESDAY&unrelated=1",
+			vars:   map[string]string{"cafe_id": "1"},
+		},
+		{
+			name:   "path variable the route does not have",
+			target: "/api/orders",
+		},
+		{
+			name:   "bad values",
+			target: "/api/cafes/one/orders?at=yesterday&days=MONDAY&days=SUNDAY",
+			vars:   map[string]string{"cafe_id": "one"},
+			want: []Violation{
+				{"cafe_id", "must be a number"},
+				{"at", "must be an RFC 3339 timestamp"},
+				{"days[1]", "must be one of MONDAY, TUESDAY"},
+			},
+		},
+		{
+			name:   "repeated scalar",
+			target: "/api/cafes/1/orders?at=2024-05-01T09:00:00Z&at=2024-05-02T09:00:00Z",
+			vars:   map[string]string{"cafe_id": "1"},
+			want:   []Violation{{"at", "must be given once"}},
+		},
+	} {
+		err := getOrders.Validate(httptest.NewRequest("GET", tc.target, nil), tc.vars)
+		if tc.want == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.Equal(t, tc.want, violations(t, err), tc.name)
+		}
+	}
+}
+
+func TestRejectUnreadableBodies(t *testing.T) {
+	t.Parallel()
+
+	spec := parse(t)
+	spec.MaxBodySize = 64
+	createUser := operation(t, spec, "POST", "/api/users")
+
+	err := createUser.Validate(httptest.NewRequest("POST", "/api/users", strings.NewReader(
+		`{"name": "`+strings.Repeat("a", 64)+`", "email": "ada@example.com"}`)), nil)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	for _, body := range []string{`{"name": `, `{"name": "Ada", "email": "ada@example.com"} {}`} {
+		err := createUser.Validate(httptest.NewRequest("POST", "/api/users", strings.NewReader(body)), nil)
+		require.Error(t, err, body)
+		assert.Contains(t, err.Error(), "request body is not valid JSON", body)
+		var invalid *ValidationError
+		assert.False(t, errors.As(err, &invalid), "%s: not a field violation", body)
+	}
+}
+
+func TestParseRejectsBadPatterns(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse([]byte(`{"definitions": {"Bad": {"type": "string", "pattern": "("}}}`))
+	assert.ErrorContains(t, err, `pattern "("`)
+}
@@ -0,0 +1,47 @@
+// Command devcerts creates a local CA and a certificate for every
+// practical-six binary, for running the services with mutual TLS on a
+// development machine:
+//
+//	cd pkg && go run ./cmd/devcerts -dir ../certs
+//
+// Each run starts a new CA, so the files it writes only trust each other.
+// Running services pick up the new files without a restart.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/mtls"
+)
+
+// services are the binaries that get a certificate, each valid for its
+// docker compose host name as well as for localhost.
+var services = []string{"user-service", "menu-service", "order-service", "api-gateway"}
+
+func main() {
+	dir := flag.String("dir", "certs", "directory to write the CA and certificates to")
+	validity := flag.Duration("validity", 90*24*time.Hour, "how long the certificates are valid")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		logging.Fatal("Failed to create certificate directory", "err", err)
+	}
+	ca, err := mtls.NewCA(*validity)
+	if err != nil {
+		logging.Fatal("Failed to create CA", "err", err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, "ca.pem"), ca.CertPEM, 0o644); err != nil {
+		logging.Fatal("Failed to write CA certificate", "err", err)
+	}
+	for _, service := range services {
+		if err := ca.WriteFiles(*dir, service, *validity, service, "localhost", "127.0.0.1"); err != nil {
+			logging.Fatal("Failed to write certificate", "service", service, "err", err)
+		}
+		slog.Info("Wrote certificate", "service", service, "id", mtls.ID(service))
+	}
+}
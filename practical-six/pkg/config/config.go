@@ -0,0 +1,375 @@
+// Package config loads a service's typed configuration.
+//
+// A configuration is a struct whose fields carry tags:
+//
+//	yaml:"grpc_port"     key in the optional YAML file (nested structs nest)
+//	env:"GRPC_PORT"      environment variable
+//	flag:"grpc-port"     command-line flag
+//	usage:"..."          flag help text
+//	required:"true"      the value must not be left empty or zero
+//	secret:"true"        the value is redacted when the config is printed
+//
+// Values already in the struct act as defaults. They are overridden by the
+// YAML file (given by --config or CONFIG_FILE), then the environment, then
+// flags. If the struct has a Validate() error method it runs after loading.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPrinted is returned by Loader.Load after --print-config has written the
+// effective configuration. The program should exit successfully.
+var ErrPrinted = errors.New("configuration printed")
+
+const redacted = "******"
+
+// Loader reads configuration from a set of sources. Load uses the process's
+// arguments and environment; tests set the fields directly.
+type Loader struct {
+	// Name is the program name shown in flag usage.
+	Name string
+	// Args are the command-line arguments, without the program name.
+	Args      []string
+	LookupEnv func(key string) (string, bool)
+	// Output receives --print-config and usage output.
+	Output io.Writer
+}
+
+type field struct {
+	path     []string
+	env      string
+	flag     string
+	usage    string
+	required bool
+	secret   bool
+	value    reflect.Value
+}
+
+func (f field) name() string {
+	return strings.Join(f.path, ".")
+}
+
+// Load fills cfg, which must be a pointer to a struct, and validates it. It
+// returns the positional arguments left after the flags, such as a
+// subcommand.
+func (l Loader) Load(cfg any) ([]string, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: expected a pointer to a struct, got %T", cfg)
+	}
+	fields, err := collect(v.Elem(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = func(string) (string, bool) { return "", false }
+	}
+	output := l.Output
+	if output == nil {
+		output = io.Discard
+	}
+
+	// Flags are parsed first so --config is known, but applied last so they
+	// win over the file and the environment.
+	fs := flag.NewFlagSet(l.Name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	file := fs.String("config", "", "path to a YAML config file (or CONFIG_FILE)")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		if f.flag != "" {
+			fs.Var(&rawFlag{name: f.flag, values: flagValues, isBool: f.value.Kind() == reflect.Bool}, f.flag, usage(f))
+		}
+	}
+	if err := fs.Parse(l.Args); err != nil {
+		return nil, err
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *file != "" {
+		if err := loadFile(*file, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if raw, ok := lookupEnv(f.env); ok && raw != "" {
+			if err := set(f.value, raw); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", f.env, err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if raw, ok := flagValues[f.flag]; ok {
+			if err := set(f.value, raw); err != nil {
+				return nil, fmt.Errorf("config: --%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	if err := validate(cfg, fields); err != nil {
+		return nil, err
+	}
+
+	if *printConfig {
+		fmt.Fprint(output, Describe(cfg))
+		return nil, ErrPrinted
+	}
+	return fs.Args(), nil
+}
+
+// MustLoad loads cfg from the process's arguments and environment and logs
+// the redacted result. It exits after --print-config or --help, and on any
+// error.
+func MustLoad(name string, cfg any) []string {
+	args, err := Loader{
+		Name:      name,
+		Args:      os.Args[1:],
+		LookupEnv: os.LookupEnv,
+		Output:    os.Stdout,
+	}.Load(cfg)
+	if errors.Is(err, ErrPrinted) || errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Loaded configuration", "config", Describe(cfg))
+	return args
+}
+
+// Describe renders cfg as YAML with secret values redacted.
+func Describe(cfg any) string {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	node, err := describe(v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
+
+func describe(v reflect.Value) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: yamlKey(sf)}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}) {
+			child, err := describe(fv)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, child)
+			continue
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(fv.Interface())}
+		if sf.Tag.Get("secret") == "true" && !fv.IsZero() {
+			value.Value = redacted
+		}
+		if fv.Kind() == reflect.String || value.Value == redacted {
+			value.Style = yaml.DoubleQuotedStyle
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node, nil
+}
+
+func collect(v reflect.Value, path []string) ([]field, error) {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), yamlKey(sf))
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			nested, err := collect(fv, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+		if err := set(reflect.New(fv.Type()).Elem(), zeroText(fv.Kind())); err != nil {
+			return nil, fmt.Errorf("config: field %s has unsupported type %s", sf.Name, fv.Type())
+		}
+
+		fields = append(fields, field{
+			path:     fieldPath,
+			env:      sf.Tag.Get("env"),
+			flag:     sf.Tag.Get("flag"),
+			usage:    sf.Tag.Get("usage"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+			value:    fv,
+		})
+	}
+	return fields, nil
+}
+
+func yamlKey(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ","); name != "" {
+		return name
+	}
+	return strings.ToLower(sf.Name)
+}
+
+func zeroText(kind reflect.Kind) string {
+	if kind == reflect.Bool {
+		return "false"
+	}
+	if kind == reflect.String {
+		return ""
+	}
+	return "0"
+}
+
+func usage(f field) string {
+	text := f.usage
+	if text == "" {
+		text = f.name()
+	}
+	if f.env != "" {
+		text += " (env " + f.env + ")"
+	}
+	return text
+}
+
+func loadFile(path string, cfg any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// set parses raw into v according to v's type.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func validate(cfg any, fields []field) error {
+	var errs []error
+	for _, f := range fields {
+		if !f.required || !f.value.IsZero() {
+			continue
+		}
+		var sources []string
+		if f.env != "" {
+			sources = append(sources, f.env)
+		}
+		if f.flag != "" {
+			sources = append(sources, "--"+f.flag)
+		}
+		errs = append(errs, fmt.Errorf("%s is required (set %s)", f.name(), strings.Join(append(sources, "the config file"), ", ")))
+	}
+	if v, ok := cfg.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rawFlag records a flag's value as text so it can be applied after the
+// file and environment.
+type rawFlag struct {
+	name   string
+	values map[string]string
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil || f.values == nil {
+		return ""
+	}
+	return f.values[f.name]
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.values[f.name] = s
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Database is the Postgres connection shared by the services. The password has
+// no default and must be configured.
+type Database struct {
+	Host     string `yaml:"host" env:"DB_HOST" flag:"db-host" usage:"database host" required:"true"`
+	Port     int    `yaml:"port" env:"DB_PORT" flag:"db-port" usage:"database port" required:"true"`
+	User     string `yaml:"user" env:"DB_USER" flag:"db-user" usage:"database user" required:"true"`
+	Password string `yaml:"password" env:"DB_PASSWORD" flag:"db-password" usage:"database password" required:"true" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME" flag:"db-name" usage:"database name" required:"true"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE" flag:"db-sslmode" usage:"Postgres sslmode"`
+}
+
+// DefaultDatabase returns the settings for a local Postgres holding the named
+// database, leaving the password unset.
+func DefaultDatabase(name string) Database {
+	return Database{
+		Host:    "localhost",
+		Port:    5432,
+		User:    "postgres",
+		Name:    name,
+		SSLMode: "disable",
+	}
+}
+
+// DSN returns the connection string for the Postgres driver.
+func (d Database) DSN() string {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:   d.Name,
+	}
+	if d.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {d.SSLMode}}.Encode()
+	}
+	return dsn.String()
+}
@@ -0,0 +1,81 @@
+// Package dataloader batches the lookups a GraphQL query makes, so that
+// resolving a field on N objects costs one backend call instead of N.
+//
+// Resolvers call Load, which only queues the key, and hand the returned thunk
+// to the executor. The executor resolves a whole level of the query before
+// calling any thunks, so by the time the first one runs every key of that
+// level is queued and one call to the batch function fetches them all.
+//
+// A Loader remembers every key it has fetched. Make one per request so a
+// query sees a consistent view and nothing outlives it.
+package dataloader
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc fetches the values for keys. Keys missing from the returned map
+// load as the zero value without an error; an error fails every key in the
+// batch.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+type Loader[K comparable, V any] struct {
+	batch BatchFunc[K, V]
+
+	mu      sync.Mutex
+	pending []K
+	results map[K]result[V]
+}
+
+type result[V any] struct {
+	value V
+	err   error
+}
+
+func New[K comparable, V any](batch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		batch:   batch,
+		results: make(map[K]result[V]),
+	}
+}
+
+// Load queues key and returns a thunk that yields its value. The first thunk
+// to run fetches every key queued so far in one batch; keys loaded before
+// are not fetched again.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) func() (V, error) {
+	l.mu.Lock()
+	if _, done := l.results[key]; !done {
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, done := l.results[key]; !done {
+			l.dispatch(ctx)
+		}
+		r := l.results[key]
+		return r.value, r.err
+	}
+}
+
+// dispatch fetches the pending keys. It runs with l.mu held, which keeps
+// concurrent thunks from fetching the same keys twice.
+func (l *Loader[K, V]) dispatch(ctx context.Context) {
+	keys := make([]K, 0, len(l.pending))
+	seen := make(map[K]bool, len(l.pending))
+	for _, key := range l.pending {
+		if _, done := l.results[key]; !done && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	l.pending = nil
+
+	values, err := l.batch(ctx, keys)
+	for _, key := range keys {
+		l.results[key] = result[V]{value: values[key], err: err}
+	}
+}
@@ -0,0 +1,92 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// squares records every batch it is asked for and knows the squares of the
+// positive numbers.
+type squares struct {
+	batches [][]int
+	err     error
+}
+
+func (s *squares) fetch(ctx context.Context, keys []int) (map[int]int, error) {
+	s.batches = append(s.batches, keys)
+	if s.err != nil {
+		return nil, s.err
+	}
+	values := make(map[int]int)
+	for _, key := range keys {
+		if key > 0 {
+			values[key] = key * key
+		}
+	}
+	return values, nil
+}
+
+func TestLoadBatchesQueuedKeys(t *testing.T) {
+	t.Parallel()
+
+	backend := &squares{}
+	loader := New(backend.fetch)
+	ctx := context.Background()
+
+	thunks := []func() (int, error){
+		loader.Load(ctx, 2),
+		loader.Load(ctx, 3),
+		loader.Load(ctx, 2),
+		loader.Load(ctx, -1),
+	}
+	assert.Empty(t, backend.batches, "nothing is fetched until a thunk runs")
+
+	var got []int
+	for _, thunk := range thunks {
+		value, err := thunk()
+		require.NoError(t, err)
+		got = append(got, value)
+	}
+	assert.Equal(t, []int{4, 9, 4, 0}, got, "missing keys load as the zero value")
+	assert.Equal(t, [][]int{{2, 3, -1}}, backend.batches)
+}
+
+func TestLoadRemembersFetchedKeys(t *testing.T) {
+	t.Parallel()
+
+	backend := &squares{}
+	loader := New(backend.fetch)
+	ctx := context.Background()
+
+	loader.Load(ctx, 2)()
+	value, err := loader.Load(ctx, 2)()
+	require.NoError(t, err)
+	assert.Equal(t, 4, value)
+
+	// Keys queued after a batch go out in the next one, without the keys
+	// that are already known.
+	first := loader.Load(ctx, 3)
+	loader.Load(ctx, 2)
+	loader.Load(ctx, 4)
+	first()
+	assert.Equal(t, [][]int{{2}, {3, 4}}, backend.batches)
+}
+
+func TestLoadSharesBatchErrors(t *testing.T) {
+	t.Parallel()
+
+	backend := &squares{err: errors.New("backend down")}
+	loader := New(backend.fetch)
+	ctx := context.Background()
+
+	first, second := loader.Load(ctx, 2), loader.Load(ctx, 3)
+	_, err := first()
+	assert.EqualError(t, err, "backend down")
+	_, err = second()
+	assert.EqualError(t, err, "backend down")
+	assert.Len(t, backend.batches, 1)
+}
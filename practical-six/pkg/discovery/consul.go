@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Consul is a Registry backed by a Consul agent. Registered instances carry
+// a TTL check that a heartbeat keeps passing for as long as their own check
+// does, and watches are blocking queries on the health endpoint.
+type Consul struct {
+	client *api.Client
+	cfg    Config
+
+	mu         sync.Mutex
+	heartbeats map[string]context.CancelFunc
+
+	// WaitTime bounds each blocking query. Defaults to 5 minutes.
+	WaitTime time.Duration
+	// RetryInterval is the first wait after a failed query; it doubles up to
+	// MaxRetryInterval. They default to 1 and 30 seconds.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+}
+
+// NewConsul returns a Registry talking to the agent at cfg.ConsulAddr.
+func NewConsul(cfg Config) (*Consul, error) {
+	apiConfig := api.DefaultConfig()
+	apiConfig.Address = cfg.ConsulAddr
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Consul{
+		client:           client,
+		cfg:              cfg,
+		heartbeats:       make(map[string]context.CancelFunc),
+		WaitTime:         5 * time.Minute,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: 30 * time.Second,
+	}, nil
+}
+
+func checkID(id string) string { return "service:" + id }
+
+func (c *Consul) register(ctx context.Context, inst Instance) error {
+	registration := &api.AgentServiceRegistration{
+		ID:      inst.ID,
+		Name:    inst.Service,
+		Address: inst.Host,
+		Port:    inst.Port,
+		Check: &api.AgentServiceCheck{
+			CheckID:                        checkID(inst.ID),
+			TTL:                            c.cfg.TTL.String(),
+			DeregisterCriticalServiceAfter: c.cfg.DeregisterAfter.String(),
+		},
+	}
+	opts := api.ServiceRegisterOpts{ReplaceExistingChecks: true}.WithContext(ctx)
+	return c.client.Agent().ServiceRegisterOpts(registration, opts)
+}
+
+// Register registers inst and starts its heartbeat. The first heartbeat is
+// sent before Register returns, so a healthy instance is visible at once
+// rather than after a TTL.
+func (c *Consul) Register(ctx context.Context, inst Instance, check func() error) error {
+	if err := c.register(ctx, inst); err != nil {
+		return err
+	}
+	if err := c.heartbeat(ctx, inst, check); err != nil {
+		return err
+	}
+
+	beatCtx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	if stop, ok := c.heartbeats[inst.ID]; ok {
+		stop()
+	}
+	c.heartbeats[inst.ID] = cancel
+	c.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(c.cfg.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beatCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := c.heartbeat(beatCtx, inst, check); err == nil || beatCtx.Err() != nil {
+				continue
+			}
+			// The agent may have restarted and forgotten the instance.
+			slog.Warn("Consul heartbeat failed, registering again", "service", inst.Service, "id", inst.ID)
+			if err := c.register(beatCtx, inst); err != nil {
+				slog.Error("Failed to register with Consul", "service", inst.Service, "id", inst.ID, "err", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Consul) heartbeat(ctx context.Context, inst Instance, check func() error) error {
+	status, output := api.HealthPassing, ""
+	if check != nil {
+		if err := check(); err != nil {
+			status, output = api.HealthCritical, err.Error()
+		}
+	}
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	return c.client.Agent().UpdateTTLOpts(checkID(inst.ID), output, status, q)
+}
+
+// Deregister stops the instance's heartbeat and removes it from the agent.
+func (c *Consul) Deregister(ctx context.Context, id string) error {
+	c.mu.Lock()
+	if stop, ok := c.heartbeats[id]; ok {
+		stop()
+		delete(c.heartbeats, id)
+	}
+	c.mu.Unlock()
+	return c.client.Agent().ServiceDeregisterOpts(id, (&api.QueryOptions{}).WithContext(ctx))
+}
+
+// Watch follows the passing instances of service with blocking queries.
+// Failed queries are logged and retried with backoff; the last instances
+// sent stay in use meanwhile.
+func (c *Consul) Watch(ctx context.Context, service string) <-chan []Instance {
+	updates := make(chan []Instance, 1)
+	go func() {
+		defer close(updates)
+		var (
+			index uint64
+			last  []Instance
+			sent  bool
+		)
+		retry := c.RetryInterval
+		for {
+			q := (&api.QueryOptions{WaitIndex: index, WaitTime: c.WaitTime}).WithContext(ctx)
+			entries, meta, err := c.client.Health().Service(service, "", true, q)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Warn("Consul query failed", "service", service, "retry_in", retry, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retry):
+				}
+				retry = min(2*retry, c.MaxRetryInterval)
+				continue
+			}
+			retry = c.RetryInterval
+
+			// An index that goes backwards means the agent's state was reset;
+			// start over rather than wait on an index that will not come.
+			if meta.LastIndex < index {
+				index = 0
+			} else {
+				index = max(meta.LastIndex, 1)
+			}
+
+			instances := make([]Instance, 0, len(entries))
+			for _, entry := range entries {
+				host := entry.Service.Address
+				if host == "" {
+					host = entry.Node.Address
+				}
+				instances = append(instances, Instance{
+					ID:      entry.Service.ID,
+					Service: entry.Service.Service,
+					Host:    host,
+					Port:    entry.Service.Port,
+				})
+			}
+			sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+			if sent && slices.Equal(instances, last) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case updates <- instances:
+			}
+			last, sent = instances, true
+		}
+	}()
+	return updates
+}
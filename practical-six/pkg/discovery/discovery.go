@@ -0,0 +1,102 @@
+// Package discovery lets services find each other through a registry
+// instead of fixed addresses.
+//
+// A service announces itself on startup with Announce and is withdrawn as
+// soon as it starts shutting down. Clients dial "consul:///<service>" with
+// DialOption, and their connections follow the service's healthy instances
+// as they come and go.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Instance is one running copy of a service.
+type Instance struct {
+	ID      string
+	Service string
+	Host    string
+	Port    int
+}
+
+// Addr returns the host:port the instance serves on.
+func (i Instance) Addr() string {
+	return net.JoinHostPort(i.Host, strconv.Itoa(i.Port))
+}
+
+// Registry keeps track of which instances of each service are up.
+type Registry interface {
+	// Register adds inst. Until it is deregistered, check is asked
+	// periodically whether the instance can take calls, and the instance is
+	// hidden from watchers while it returns an error. A nil check always
+	// passes.
+	Register(ctx context.Context, inst Instance, check func() error) error
+	// Deregister removes the instance with the given ID.
+	Deregister(ctx context.Context, id string) error
+	// Watch sends the healthy instances of service, sorted by ID, and again
+	// every time they change. The channel is closed once ctx is done.
+	Watch(ctx context.Context, service string) <-chan []Instance
+}
+
+type Config struct {
+	ConsulAddr string `yaml:"consul_addr" env:"CONSUL_HTTP_ADDR" flag:"consul-addr" usage:"Consul agent host:port to register with and to resolve consul:/// addresses through; unset turns discovery off"`
+	// AdvertiseHost is what other services dial to reach this one.
+	AdvertiseHost string `yaml:"advertise_host" env:"ADVERTISE_HOST" flag:"advertise-host" usage:"host other services reach this one at; defaults to the host name"`
+	// TTL is how long an instance stays healthy without a heartbeat.
+	TTL time.Duration `yaml:"ttl" env:"DISCOVERY_TTL" flag:"discovery-ttl" usage:"how long a registered instance stays healthy without a heartbeat"`
+	// DeregisterAfter removes instances that stopped without deregistering.
+	DeregisterAfter time.Duration `yaml:"deregister_after" env:"DISCOVERY_DEREGISTER_AFTER" flag:"discovery-deregister-after" usage:"how long an instance may go without a heartbeat before Consul forgets it"`
+}
+
+// DefaultConfig has discovery off. Set ConsulAddr to turn it on.
+func DefaultConfig() Config {
+	return Config{TTL: 15 * time.Second, DeregisterAfter: time.Minute}
+}
+
+// New returns the registry cfg describes, or nil when discovery is off.
+func New(cfg Config) (Registry, error) {
+	if cfg.ConsulAddr == "" {
+		return nil, nil
+	}
+	return NewConsul(cfg)
+}
+
+// Instance describes this process serving service on port.
+func (c Config) Instance(service string, port int) (Instance, error) {
+	host := c.AdvertiseHost
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			return Instance{}, fmt.Errorf("discovery: advertise host: %w", err)
+		}
+	}
+	return Instance{
+		ID:      fmt.Sprintf("%s-%s-%d", service, host, port),
+		Service: service,
+		Host:    host,
+		Port:    port,
+	}, nil
+}
+
+// Announce registers inst with r and deregisters it as soon as ctx is done,
+// so that callers stop picking it while it drains.
+func Announce(ctx context.Context, r Registry, inst Instance, check func() error) error {
+	if err := r.Register(ctx, inst, check); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.Deregister(ctx, inst.ID); err != nil {
+			slog.Error("Failed to deregister", "service", inst.Service, "id", inst.ID, "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,299 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/practical6/pkg/lb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func instance(id string, port int) Instance {
+	return Instance{ID: id, Service: "menu-service", Host: "127.0.0.1", Port: port}
+}
+
+// next returns the next update from a watch, failing the test if none comes.
+func next(t *testing.T, updates <-chan []Instance) []Instance {
+	t.Helper()
+	select {
+	case instances, ok := <-updates:
+		require.True(t, ok, "watch ended")
+		return instances
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update from watch")
+		return nil
+	}
+}
+
+// fakeCheck is a health check whose result the test sets.
+type fakeCheck struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (c *fakeCheck) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *fakeCheck) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func TestMemoryWatch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := instance("menu-a", 1), instance("menu-b", 2)
+	registry := NewMemory(a, Instance{ID: "user-a", Service: "user-service", Host: "127.0.0.1", Port: 3})
+	updates := registry.Watch(ctx, "menu-service")
+	assert.Equal(t, []Instance{a}, next(t, updates))
+
+	bCheck := &fakeCheck{err: errors.New("database down")}
+	require.NoError(t, registry.Register(ctx, b, bCheck.check))
+	registry.Recheck()
+
+	// b only shows up once its check passes.
+	bCheck.set(nil)
+	registry.Recheck()
+	assert.Equal(t, []Instance{a, b}, next(t, updates))
+
+	require.NoError(t, registry.Deregister(ctx, a.ID))
+	assert.Equal(t, []Instance{b}, next(t, updates))
+
+	cancel()
+	for range updates {
+	}
+}
+
+func TestAnnounceDeregistersOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	registry := NewMemory()
+	watch, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	updates := registry.Watch(watch, "menu-service")
+	assert.Empty(t, next(t, updates))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	a := instance("menu-a", 1)
+	require.NoError(t, Announce(ctx, registry, a, nil))
+	assert.Equal(t, []Instance{a}, next(t, updates))
+
+	shutdown()
+	assert.Empty(t, next(t, updates))
+}
+
+func TestConfigInstance(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig()
+	cfg.AdvertiseHost = "menu-1"
+	inst, err := cfg.Instance("menu-service", 50052)
+	require.NoError(t, err)
+	assert.Equal(t, Instance{ID: "menu-service-menu-1-50052", Service: "menu-service", Host: "menu-1", Port: 50052}, inst)
+	assert.Equal(t, "menu-1:50052", inst.Addr())
+
+	registry, err := New(DefaultConfig())
+	require.NoError(t, err)
+	assert.Nil(t, registry, "no Consul address turns discovery off")
+}
+
+// startServer serves the health service with only name SERVING, so a Check
+// for name tells which server answered.
+func startServer(t *testing.T, name string) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestResolverFollowsRegistry(t *testing.T) {
+	t.Parallel()
+
+	a, b := instance("menu-a", startServer(t, "a")), instance("menu-b", startServer(t, "b"))
+	registry := NewMemory(a)
+	conn, err := grpc.NewClient(lb.Target(Scheme+":///menu-service"),
+		DialOption(registry),
+		lb.Config{Policy: lb.RoundRobin}.DialOption(""),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	answers := func(name string) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: name})
+		return err == nil
+	}
+
+	assert.True(t, answers("a"))
+
+	ctx := context.Background()
+	require.NoError(t, registry.Register(ctx, b, nil))
+	require.NoError(t, registry.Deregister(ctx, a.ID))
+	assert.Eventually(t, func() bool { return answers("b") && !answers("a") }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, registry.Deregister(ctx, b.ID))
+	assert.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "b"}, grpc.WaitForReady(false))
+		return status.Code(err) == codes.Unavailable
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// fakeAgent implements the parts of the Consul agent and health APIs the
+// Consul registry uses, with blocking queries.
+type fakeAgent struct {
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]*api.AgentServiceRegistration
+	status   map[string]string // by check ID
+}
+
+func newFakeAgent(t *testing.T) (*fakeAgent, string) {
+	f := &fakeAgent{index: 1, changed: make(chan struct{})}
+	f.reset()
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/agent/service/register", func(w http.ResponseWriter, r *http.Request) {
+		var reg api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.update(func() {
+			f.services[reg.ID] = &reg
+			f.status[reg.Check.CheckID] = api.HealthCritical
+		})
+	})
+	mux.HandleFunc("PUT /v1/agent/service/deregister/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.update(func() {
+			delete(f.services, r.PathValue("id"))
+			delete(f.status, checkID(r.PathValue("id")))
+		})
+	})
+	mux.HandleFunc("PUT /v1/agent/check/update/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var update struct{ Status string }
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		_, ok := f.status[r.PathValue("id")]
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "Unknown check ID", http.StatusNotFound)
+			return
+		}
+		f.update(func() { f.status[r.PathValue("id")] = update.Status })
+	})
+	mux.HandleFunc("GET /v1/health/service/{name}", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		f.mu.Lock()
+		for f.index <= index {
+			changed := f.changed
+			f.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			f.mu.Lock()
+		}
+		var entries []*api.ServiceEntry
+		for _, reg := range f.services {
+			if reg.Name == r.PathValue("name") && f.status[reg.Check.CheckID] == api.HealthPassing {
+				entries = append(entries, &api.ServiceEntry{
+					Node:    &api.Node{Address: "10.0.0.1"},
+					Service: &api.AgentService{ID: reg.ID, Service: reg.Name, Address: reg.Address, Port: reg.Port},
+				})
+			}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(entries)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, srv.Listener.Addr().String()
+}
+
+func (f *fakeAgent) update(change func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// reset forgets everything, as an agent that restarted would.
+func (f *fakeAgent) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.services = make(map[string]*api.AgentServiceRegistration)
+	f.status = make(map[string]string)
+}
+
+func TestConsul(t *testing.T) {
+	t.Parallel()
+
+	agent, addr := newFakeAgent(t)
+	cfg := DefaultConfig()
+	cfg.ConsulAddr = addr
+	cfg.TTL = 30 * time.Millisecond
+	registry, err := NewConsul(cfg)
+	require.NoError(t, err)
+	registry.RetryInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := registry.Watch(ctx, "menu-service")
+	assert.Empty(t, next(t, updates))
+
+	a := instance("menu-a", 1)
+	var check fakeCheck
+	require.NoError(t, registry.Register(ctx, a, check.check))
+	assert.Equal(t, []Instance{a}, next(t, updates), "visible on the first heartbeat")
+
+	check.set(errors.New("database down"))
+	assert.Empty(t, next(t, updates))
+	check.set(nil)
+	assert.Equal(t, []Instance{a}, next(t, updates))
+
+	agent.reset()
+	agent.update(func() {})
+	assert.Empty(t, next(t, updates))
+	assert.Equal(t, []Instance{a}, next(t, updates), "registered again after the agent forgot it")
+
+	require.NoError(t, registry.Deregister(ctx, a.ID))
+	assert.Empty(t, next(t, updates))
+}
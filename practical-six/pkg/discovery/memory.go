@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Memory is a Registry that lives in the process, for tests and for fixed
+// sets of instances. Checks are asked whenever watchers are told about a
+// change; call Recheck to ask them again.
+type Memory struct {
+	mu        sync.Mutex
+	instances map[string]registered
+	// changed is closed and replaced whenever the instances change.
+	changed chan struct{}
+}
+
+type registered struct {
+	Instance
+	check func() error
+}
+
+// NewMemory returns a Memory with instances registered and always healthy.
+func NewMemory(instances ...Instance) *Memory {
+	m := &Memory{instances: make(map[string]registered), changed: make(chan struct{})}
+	for _, inst := range instances {
+		m.instances[inst.ID] = registered{Instance: inst}
+	}
+	return m
+}
+
+func (m *Memory) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *Memory) Register(_ context.Context, inst Instance, check func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.instances[inst.ID] = registered{Instance: inst, check: check}
+	m.notify()
+	return nil
+}
+
+func (m *Memory) Deregister(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.instances, id)
+	m.notify()
+	return nil
+}
+
+// Recheck asks every instance's check again and tells watchers of any
+// change in which instances are healthy.
+func (m *Memory) Recheck() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notify()
+}
+
+// healthy returns the healthy instances of service, and a channel closed on
+// the next change.
+func (m *Memory) healthy(service string) ([]Instance, <-chan struct{}) {
+	m.mu.Lock()
+	var candidates []registered
+	for _, r := range m.instances {
+		if r.Service == service {
+			candidates = append(candidates, r)
+		}
+	}
+	changed := m.changed
+	m.mu.Unlock()
+
+	instances := make([]Instance, 0, len(candidates))
+	for _, r := range candidates {
+		if r.check == nil || r.check() == nil {
+			instances = append(instances, r.Instance)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, changed
+}
+
+func (m *Memory) Watch(ctx context.Context, service string) <-chan []Instance {
+	updates := make(chan []Instance, 1)
+	go func() {
+		defer close(updates)
+		var last []Instance
+		sent := false
+		for {
+			instances, changed := m.healthy(service)
+			if !sent || !slices.Equal(instances, last) {
+				select {
+				case <-ctx.Done():
+					return
+				case updates <- instances:
+				}
+				last, sent = instances, true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+	}()
+	return updates
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the resolver scheme for services found through a Registry, as
+// in "consul:///menu-service". The service name is also the name TLS checks
+// the instances' certificates against.
+const Scheme = "consul"
+
+// DialOption lets a connection dial consul:/// targets through r. With r nil
+// (discovery off) it changes nothing.
+func DialOption(r Registry) grpc.DialOption {
+	if r == nil {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithResolvers(&builder{registry: r})
+}
+
+type builder struct {
+	registry Registry
+}
+
+func (*builder) Scheme() string { return Scheme }
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service := target.Endpoint()
+	if service == "" {
+		return nil, fmt.Errorf("%s target %q names no service", Scheme, target.URL.String())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := b.registry.Watch(ctx, service)
+	go func() {
+		for instances := range updates {
+			// An empty list is passed on too, so that calls fail instead of
+			// going to instances the registry no longer lists.
+			var state resolver.State
+			for _, inst := range instances {
+				state.Addresses = append(state.Addresses, resolver.Address{Addr: inst.Addr()})
+			}
+			if err := cc.UpdateState(state); err != nil {
+				slog.Warn("No usable instances", "service", service, "err", err)
+			}
+		}
+	}()
+	return watchResolver{cancel: cancel}, nil
+}
+
+// watchResolver is pushed updates by its watch, so there is nothing to do
+// on ResolveNow.
+type watchResolver struct {
+	cancel context.CancelFunc
+}
+
+func (watchResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (r watchResolver) Close()                              { r.cancel() }
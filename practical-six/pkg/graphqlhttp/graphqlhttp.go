@@ -0,0 +1,113 @@
+// Package graphqlhttp serves a graphql-go schema over HTTP. Queries come as
+// GET parameters or a JSON POST body, and are checked against Limits before
+// any resolver runs.
+package graphqlhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// maxBodySize caps POST bodies. Queries that need more are over the limits
+// anyway.
+const maxBodySize = 1 << 20
+
+type Handler struct {
+	Schema graphql.Schema
+	Limits Limits
+	// Context, if set, derives the context resolvers run with from the
+	// request's, e.g. to give every request its own data loaders.
+	Context func(ctx context.Context) context.Context
+}
+
+func New(schema graphql.Schema, limits Limits) *Handler {
+	return &Handler{Schema: schema, Limits: limits}
+}
+
+// request is a GraphQL request as sent in a POST body.
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// ServeHTTP answers 200 with the result of every query it executes, field
+// errors included. Requests that cannot be executed at all (malformed,
+// invalid or over the limits) get 400 and only errors.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if variables := q.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeErrors(w, http.StatusBadRequest, errors.New("variables must be a JSON object"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+			writeErrors(w, http.StatusBadRequest, errors.New("body must be a JSON object with a query"))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeErrors(w, http.StatusMethodNotAllowed, errors.New("use GET or POST"))
+		return
+	}
+	if req.Query == "" {
+		writeErrors(w, http.StatusBadRequest, errors.New("query is required"))
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, err)
+		return
+	}
+	if validation := graphql.ValidateDocument(&h.Schema, doc, nil); !validation.IsValid {
+		writeJSON(w, http.StatusBadRequest, &graphql.Result{Errors: validation.Errors})
+		return
+	}
+	if err := h.Limits.Check(&h.Schema, doc, req.OperationName); err != nil {
+		writeErrors(w, http.StatusBadRequest, err)
+		return
+	}
+	// GET requests may be replayed by caches and crawlers, so they only read.
+	if op := operation(doc, req.OperationName); r.Method == http.MethodGet && op != nil && op.Operation != ast.OperationTypeQuery {
+		w.Header().Set("Allow", "POST")
+		writeErrors(w, http.StatusMethodNotAllowed, errors.New("only queries can be sent with GET"))
+		return
+	}
+
+	ctx := r.Context()
+	if h.Context != nil {
+		ctx = h.Context(ctx)
+	}
+	writeJSON(w, http.StatusOK, graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.Schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	}))
+}
+
+func writeErrors(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,297 @@
+package graphqlhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/practical6/pkg/dataloader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name    string
+	Related string
+}
+
+type loaderKey struct{}
+
+// testSchema serves a shop whose items point at each other, so queries can
+// nest as deep as a test likes. Item.related goes through the loader setup
+// puts in the request's context.
+func testSchema(t *testing.T) graphql.Schema {
+	items := map[string]item{
+		"latte":     {Name: "latte", Related: "croissant"},
+		"croissant": {Name: "croissant", Related: "latte"},
+		"tea":       {Name: "tea", Related: "scone"},
+		"scone":     {Name: "scone", Related: "tea"},
+	}
+
+	itemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Item",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.String},
+		},
+	})
+	itemType.AddFieldConfig("related", &graphql.Field{
+		Type: itemType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			loader := p.Context.Value(loaderKey{}).(*dataloader.Loader[string, *item])
+			thunk := loader.Load(p.Context, p.Source.(*item).Related)
+			return func() (interface{}, error) { return thunk() }, nil
+		},
+	})
+	categoryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Category",
+		Fields: graphql.Fields{
+			"name":  &graphql.Field{Type: graphql.String},
+			"items": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(itemType)))},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"categories": &graphql.Field{
+					Type: graphql.NewList(categoryType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return []map[string]interface{}{
+							{"name": "coffee", "items": []*item{ptr(items["latte"]), ptr(items["croissant"])}},
+							{"name": "tea", "items": []*item{ptr(items["tea"]), ptr(items["scone"])}},
+						}, nil
+					},
+				},
+				"item": &graphql.Field{
+					Type: itemType,
+					Args: graphql.FieldConfigArgument{
+						"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if found, ok := items[p.Args["name"].(string)]; ok {
+							return &found, nil
+						}
+						return nil, nil
+					},
+				},
+			},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"reset": &graphql.Field{
+					Type:    graphql.Boolean,
+					Resolve: func(graphql.ResolveParams) (interface{}, error) { return true, nil },
+				},
+			},
+		}),
+	})
+	require.NoError(t, err)
+	return schema
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// setup returns a handler for testSchema and the batches its loaders have
+// fetched.
+func setup(t *testing.T, limits Limits) (*Handler, *[][]string) {
+	schema := testSchema(t)
+	var batches [][]string
+	handler := New(schema, limits)
+	handler.Context = func(ctx context.Context) context.Context {
+		loader := dataloader.New(func(ctx context.Context, names []string) (map[string]*item, error) {
+			batches = append(batches, names)
+			found := make(map[string]*item)
+			for _, name := range names {
+				found[name] = &item{Name: name}
+			}
+			return found, nil
+		})
+		return context.WithValue(ctx, loaderKey{}, loader)
+	}
+	return handler, &batches
+}
+
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func post(t *testing.T, handler http.Handler, body string) (int, response) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/graphql", strings.NewReader(body)))
+	return decode(t, rec)
+}
+
+func get(t *testing.T, handler http.Handler, query url.Values) (int, response) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/graphql?"+query.Encode(), nil))
+	return decode(t, rec)
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) (int, response) {
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), rec.Body.String())
+	return rec.Code, resp
+}
+
+func TestServeQueries(t *testing.T) {
+	t.Parallel()
+
+	handler, _ := setup(t, DefaultLimits())
+
+	code, resp := post(t, handler, `{"query": "query Find($name: String!) { item(name: $name) { name } }", "variables": {"name": "latte"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, map[string]interface{}{"item": map[string]interface{}{"name": "latte"}}, resp.Data)
+
+	code, resp = get(t, handler, url.Values{
+		"query":         {`query A { item(name: "tea") { name } } query B { item(name: "scone") { name } }`},
+		"operationName": {"B"},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"item": map[string]interface{}{"name": "scone"}}, resp.Data)
+
+	code, resp = post(t, handler, `{"query": "mutation { reset }"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"reset": true}, resp.Data)
+}
+
+func TestRejectUnexecutableRequests(t *testing.T) {
+	t.Parallel()
+
+	handler, _ := setup(t, DefaultLimits())
+
+	for name, body := range map[string]string{
+		"malformed JSON": `{"query": `,
+		"no query":       `{}`,
+		"syntax error":   `{"query": "{ item(name: "}`,
+		"unknown field":  `{"query": "{ item(name: \"latte\") { colour } }"}`,
+	} {
+		code, resp := post(t, handler, body)
+		assert.Equal(t, http.StatusBadRequest, code, name)
+		assert.NotEmpty(t, resp.Errors, name)
+		assert.Nil(t, resp.Data, name)
+	}
+
+	code, resp := get(t, handler, url.Values{"query": {"mutation { reset }"}})
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.Equal(t, "only queries can be sent with GET", resp.Errors[0].Message)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/graphql", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, POST", rec.Header().Get("Allow"))
+}
+
+func TestRelatedFieldsLoadInOneBatch(t *testing.T) {
+	t.Parallel()
+
+	handler, batches := setup(t, DefaultLimits())
+
+	code, resp := post(t, handler, `{"query": "{ categories { items { name related { name } } } }"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, resp.Errors)
+	assert.Len(t, *batches, 1, "one batch for the related items of every category")
+	assert.ElementsMatch(t, []string{"croissant", "latte", "scone", "tea"}, (*batches)[0])
+
+	categories := resp.Data["categories"].([]interface{})
+	coffee := categories[0].(map[string]interface{})["items"].([]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"name":    "latte",
+		"related": map[string]interface{}{"name": "croissant"},
+	}, coffee[0])
+}
+
+func TestRejectQueriesOverLimits(t *testing.T) {
+	t.Parallel()
+
+	handler, batches := setup(t, Limits{MaxComplexity: 100, ListFactor: 10})
+
+	code, resp := post(t, handler, `{"query": "{ categories { items { name related { name } } } }"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "query complexity is 311, more than the limit of 100", resp.Errors[0].Message)
+	assert.Empty(t, *batches, "nothing was resolved")
+}
+
+func TestLimitsCheck(t *testing.T) {
+	t.Parallel()
+
+	schema := testSchema(t)
+	limits := Limits{MaxDepth: 4, MaxComplexity: 100, ListFactor: 10}
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		operation string
+		err       string
+	}{
+		{
+			name:  "within limits",
+			query: `{ categories { name } item(name: "latte") { related { related { name } } } }`,
+		},
+		{
+			name:  "too deep",
+			query: `{ item(name: "latte") { related { related { related { name } } } } }`,
+			err:   "query is 5 levels deep, more than the limit of 4",
+		},
+		{
+			name:  "lists multiply",
+			query: `{ categories { items { name } } }`,
+			err:   "query complexity is 111, more than the limit of 100",
+		},
+		{
+			name:  "fragment spreads count",
+			query: `{ item(name: "latte") { ...deep } } fragment deep on Item { related { related { related { name } } } }`,
+			err:   "query is 5 levels deep, more than the limit of 4",
+		},
+		{
+			name:  "inline fragments count",
+			query: `{ item(name: "latte") { ... on Item { related { related { related { name } } } } } }`,
+			err:   "query is 5 levels deep, more than the limit of 4",
+		},
+		{
+			name:  "fragments spread twice count twice",
+			query: `{ categories { ...names ...names } } fragment names on Category { a: name b: name c: name d: name e: name }`,
+			err:   "query complexity is 101, more than the limit of 100",
+		},
+		{
+			name:  "introspection is free",
+			query: `{ __schema { types { name fields { type { ofType { ofType { name } } } } } } }`,
+		},
+		{
+			name:      "only the requested operation counts",
+			query:     `query Small { categories { name } } query Big { categories { items { name } } }`,
+			operation: "Small",
+		},
+		{
+			name:      "the other one",
+			query:     `query Small { categories { name } } query Big { categories { items { name } } }`,
+			operation: "Big",
+			err:       "query complexity is 111, more than the limit of 100",
+		},
+	} {
+		doc, err := parser.Parse(parser.ParseParams{Source: tc.query})
+		require.NoError(t, err, tc.name)
+
+		err = limits.Check(&schema, doc, tc.operation)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.name)
+		}
+
+		assert.NoError(t, Limits{}.Check(&schema, doc, tc.operation), "%s: zero limits are no limits", tc.name)
+	}
+}
@@ -0,0 +1,196 @@
+package graphqlhttp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Limits bound the work a single query can ask for. They are checked against
+// the parsed query before anything is resolved.
+//
+// Depth counts the levels of nested fields: { orders { user { name } } } is 3
+// deep. Complexity counts the fields the response will hold, assuming every
+// list has ListFactor elements, so the same query costs 1 + 10*(1 + 1) = 21
+// with the default factor. Introspection fields are free.
+type Limits struct {
+	MaxDepth      int `yaml:"max_depth" env:"GRAPHQL_MAX_DEPTH" flag:"graphql-max-depth" usage:"deepest nesting of fields a GraphQL query may have; 0 disables the limit"`
+	MaxComplexity int `yaml:"max_complexity" env:"GRAPHQL_MAX_COMPLEXITY" flag:"graphql-max-complexity" usage:"estimated number of fields a GraphQL response may hold; 0 disables the limit"`
+	ListFactor    int `yaml:"list_factor" env:"GRAPHQL_LIST_FACTOR" flag:"graphql-list-factor" usage:"number of elements each list is assumed to have when estimating complexity"`
+}
+
+// DefaultLimits let a client fetch every order with its items, menu items
+// and user in one query, and not much more.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxDepth:      8,
+		MaxComplexity: 5000,
+		ListFactor:    10,
+	}
+}
+
+// Check measures the named operation in doc, which must have passed
+// validation against schema, and reports whether it is within the limits. An
+// unknown operation passes; executing it fails anyway.
+func (l Limits) Check(schema *graphql.Schema, doc *ast.Document, operationName string) error {
+	op := operation(doc, operationName)
+	if op == nil {
+		return nil
+	}
+
+	m := &measurer{
+		schema:     schema,
+		fragments:  make(map[string]*ast.FragmentDefinition),
+		measured:   make(map[string]measure),
+		listFactor: max(l.ListFactor, 1),
+	}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	root := schema.QueryType()
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	}
+	if root == nil {
+		return nil
+	}
+	got := m.selections(op.SelectionSet, root)
+
+	if l.MaxDepth > 0 && got.depth > l.MaxDepth {
+		return fmt.Errorf("query is %d levels deep, more than the limit of %d", got.depth, l.MaxDepth)
+	}
+	if l.MaxComplexity > 0 && got.complexity > l.MaxComplexity {
+		return fmt.Errorf("query complexity is %d, more than the limit of %d", got.complexity, l.MaxComplexity)
+	}
+	return nil
+}
+
+// operation returns the operation a request for name would execute, or nil
+// if there is no such operation.
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil // ambiguous without a name
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+type measure struct {
+	depth      int
+	complexity int
+}
+
+// maxComplexity keeps the arithmetic from overflowing on absurd queries;
+// anything that large is over any sensible limit.
+const maxComplexity = math.MaxInt32
+
+type measurer struct {
+	schema     *graphql.Schema
+	fragments  map[string]*ast.FragmentDefinition
+	measured   map[string]measure // by fragment name
+	listFactor int
+}
+
+func (m *measurer) selections(set *ast.SelectionSet, parent graphql.Type) measure {
+	var total measure
+	if set == nil {
+		return total
+	}
+	add := func(got measure) {
+		total.depth = max(total.depth, got.depth)
+		total.complexity = min(total.complexity+got.complexity, maxComplexity)
+	}
+
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			fieldType := fieldType(parent, s.Name.Value)
+			got := m.selections(s.SelectionSet, named(fieldType))
+			if isList(fieldType) {
+				got.complexity = min(got.complexity*m.listFactor, maxComplexity)
+			}
+			add(measure{depth: got.depth + 1, complexity: got.complexity + 1})
+		case *ast.InlineFragment:
+			typ := parent
+			if s.TypeCondition != nil {
+				typ = m.schema.Type(s.TypeCondition.Name.Value)
+			}
+			add(m.selections(s.SelectionSet, typ))
+		case *ast.FragmentSpread:
+			add(m.fragment(s.Name.Value))
+		}
+	}
+	return total
+}
+
+// fragment measures a named fragment once, however often it is spread, so
+// fragments spreading each other cannot make the check itself expensive.
+func (m *measurer) fragment(name string) measure {
+	if got, ok := m.measured[name]; ok {
+		return got
+	}
+	var got measure
+	if fragment := m.fragments[name]; fragment != nil {
+		got = m.selections(fragment.SelectionSet, m.schema.Type(fragment.TypeCondition.Name.Value))
+	}
+	m.measured[name] = got
+	return got
+}
+
+func fieldType(parent graphql.Type, name string) graphql.Type {
+	fielded, ok := parent.(interface {
+		Fields() graphql.FieldDefinitionMap
+	})
+	if !ok {
+		return nil
+	}
+	if field := fielded.Fields()[name]; field != nil {
+		return field.Type
+	}
+	return nil
+}
+
+func isList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+// named strips the list and non-null wrappers off t.
+func named(t graphql.Type) graphql.Type {
+	for {
+		switch wrapper := t.(type) {
+		case *graphql.List:
+			t = wrapper.OfType
+		case *graphql.NonNull:
+			t = wrapper.OfType
+		default:
+			return t
+		}
+	}
+}
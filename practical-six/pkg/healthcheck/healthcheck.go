@@ -0,0 +1,203 @@
+// Package healthcheck runs a service's dependency checks and publishes the
+// result through the standard grpc.health.v1 service.
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check returns an error when a dependency is unusable.
+type Check func(ctx context.Context) error
+
+// Report holds the outcome of each named check; nil means healthy.
+type Report map[string]error
+
+func (r Report) Healthy() bool {
+	for _, err := range r {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Names returns the check names in sorted order.
+func (r Report) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Err joins the failing checks into one error, or returns nil if none fail.
+func (r Report) Err() error {
+	var failing []string
+	for _, name := range r.Names() {
+		if err := r[name]; err != nil {
+			failing = append(failing, name+": "+err.Error())
+		}
+	}
+	if len(failing) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failing, "; "))
+}
+
+type Checker struct {
+	server   *health.Server
+	services []string
+
+	mu     sync.Mutex
+	checks map[string]Check
+	last   Report
+
+	// Interval is how often Run re-checks. Defaults to 10 seconds.
+	Interval time.Duration
+	// Timeout bounds each check. Defaults to 2 seconds.
+	Timeout time.Duration
+}
+
+// NewChecker returns a Checker that sets the overall ("") status and each of
+// services on server. server may be nil when only Check's report is needed.
+func NewChecker(server *health.Server, services ...string) *Checker {
+	return &Checker{
+		server:   server,
+		services: services,
+		checks:   make(map[string]Check),
+		Interval: 10 * time.Second,
+		Timeout:  2 * time.Second,
+	}
+}
+
+// Err returns why the last check failed, or nil if it passed or none has
+// run yet.
+func (c *Checker) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last.Err()
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Check runs every check concurrently and updates the health server. The
+// services are SERVING only if all checks pass.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	report := make(Report, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			err := check(checkCtx)
+			mu.Lock()
+			report[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	c.publish(report)
+	return report
+}
+
+func (c *Checker) publish(report Report) {
+	c.mu.Lock()
+	previous := c.last
+	c.last = report
+	c.mu.Unlock()
+
+	for _, name := range report.Names() {
+		err := report[name]
+		if wasErr, seen := previous[name]; !seen || (wasErr == nil) != (err == nil) {
+			if err != nil {
+				slog.Warn("Health check failing", "check", name, "err", err)
+			} else if seen {
+				slog.Info("Health check recovered", "check", name)
+			}
+		}
+	}
+
+	if c.server == nil {
+		return
+	}
+	status := healthpb.HealthCheckResponse_SERVING
+	if !report.Healthy() {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	c.server.SetServingStatus("", status)
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
+
+// Run checks immediately and then every Interval until ctx is done. It then
+// marks every service NOT_SERVING so clients stop routing to this instance
+// while it drains.
+func (c *Checker) Run(ctx context.Context) {
+	c.Check(ctx)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if c.server != nil {
+				c.server.Shutdown()
+			}
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
+
+// DB checks that the database answers a ping.
+func DB(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// GRPC checks that the server behind conn reports service as SERVING. An
+// empty service asks about the server as a whole.
+func GRPC(conn grpc.ClientConnInterface, service string) Check {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const service = "test.v1.TestService"
+
+func status(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	return resp.Status
+}
+
+func TestCheckerPublishesAggregateStatus(t *testing.T) {
+	t.Parallel()
+
+	server := health.NewServer()
+	checker := NewChecker(server, service)
+	var dbErr error
+	checker.Add("database", func(ctx context.Context) error { return dbErr })
+	checker.Add("cache", func(ctx context.Context) error { return nil })
+
+	assert.NoError(t, checker.Err(), "nothing has run yet")
+	report := checker.Check(context.Background())
+	assert.True(t, report.Healthy())
+	assert.NoError(t, checker.Err())
+	assert.Equal(t, []string{"cache", "database"}, report.Names())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(t, server, service))
+
+	dbErr = errors.New("connection refused")
+	report = checker.Check(context.Background())
+	assert.False(t, report.Healthy())
+	assert.EqualError(t, report["database"], "connection refused")
+	assert.NoError(t, report["cache"])
+	assert.EqualError(t, checker.Err(), "database: connection refused")
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, service))
+}
+
+func TestCheckerTimesOutSlowChecks(t *testing.T) {
+	t.Parallel()
+
+	checker := NewChecker(nil)
+	checker.Timeout = 10 * time.Millisecond
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := checker.Check(context.Background())
+	assert.ErrorIs(t, report["slow"], context.DeadlineExceeded)
+}
+
+func TestRunMarksNotServingOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	server := health.NewServer()
+	checker := NewChecker(server, service)
+	checker.Add("ok", func(ctx context.Context) error { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return status(t, server, service) == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, service))
+}
+
+func TestDB(t *testing.T) {
+	t.Parallel()
+
+	db, err := sql.Open("sqlite3", "file:healthcheck_db?mode=memory")
+	require.NoError(t, err)
+	check := DB(db)
+	assert.NoError(t, check(context.Background()))
+
+	db.Close()
+	assert.Error(t, check(context.Background()))
+}
+
+func TestGRPC(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1024 * 1024)
+	upstream := health.NewServer()
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, upstream)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	ctx := context.Background()
+	upstream.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	assert.NoError(t, GRPC(conn, service)(ctx))
+	assert.NoError(t, GRPC(conn, "")(ctx))
+
+	upstream.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	assert.EqualError(t, GRPC(conn, service)(ctx), "status NOT_SERVING")
+
+	assert.Error(t, GRPC(conn, "unknown.Service")(ctx))
+}
@@ -0,0 +1,86 @@
+// Package httpcache lets clients revalidate responses with ETags instead of
+// downloading them again.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Handler serves next with the given Cache-Control and a strong ETag derived
+// from the body of each 200 response. If the request's If-None-Match already
+// names that ETag, it answers 304 Not Modified without the body instead.
+func Handler(cacheControl string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		etag := ETag(rec.body.Bytes())
+		h := w.Header()
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", cacheControl)
+		if noneMatch(r.Header.Get("If-None-Match"), etag) {
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(rec.body.Bytes())
+	})
+}
+
+// recorder holds back the response so Handler can hash it before anything
+// is sent. Headers go straight to the underlying writer's map.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+// ETag returns a quoted entity tag that changes whenever body does.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// noneMatch reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 asks for: W/ prefixes are ignored.
+func noneMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return false
+		}
+		if header[0] == '*' {
+			return true
+		}
+		header = strings.TrimPrefix(header, "W/")
+		if header == "" || header[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if header[:end+2] == etag {
+			return true
+		}
+		header = header[end+2:]
+	}
+}
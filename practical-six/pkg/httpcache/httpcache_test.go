@@ -0,0 +1,87 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	menu := `[{"id":1,"name":"Latte","price":3.5}]`
+	handler := Handler("public, no-cache", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("missing") != "" {
+			http.Error(w, "cafe not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(menu)))
+		w.Write([]byte(menu))
+	}))
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/menu", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, menu, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "public, no-cache", rec.Header().Get("Cache-Control"))
+	etag := rec.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	rec = get("/api/menu", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Length"))
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Equal(t, "public, no-cache", rec.Header().Get("Cache-Control"))
+
+	// A changed menu gets a new ETag, so the old one no longer matches.
+	menu = `[{"id":1,"name":"Latte","price":3.75}]`
+	rec = get("/api/menu", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// Errors pass through untouched.
+	rec = get("/api/menu?missing=1", "*")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "cafe not found\n", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Header().Get("Cache-Control"))
+}
+
+func TestNoneMatch(t *testing.T) {
+	t.Parallel()
+
+	etag := `"abc"`
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz",W/"abc"`, true},
+		{`"a,b", "abc"`, true},
+		{`*`, true},
+		{`"abcd"`, false},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{`"abc`, false},
+	} {
+		assert.Equal(t, tc.want, noneMatch(tc.header, etag), tc.header)
+	}
+}
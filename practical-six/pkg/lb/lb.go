@@ -0,0 +1,119 @@
+// Package lb spreads a client's calls over every replica of a service.
+//
+// A service's address setting names its replicas in one of three ways:
+//
+//	menu-service:50052                  one address
+//	dns:///menu-service:50052           every address the name resolves to
+//	menu-1:50052,menu-2:50052           a fixed list
+//
+// Calls are balanced over the replicas round-robin or to the one with the
+// fewest calls in flight. Replicas whose grpc.health.v1 service reports them
+// not serving are ejected until they report serving again.
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/roundrobin"
+	_ "google.golang.org/grpc/health" // client-side health checking
+	"google.golang.org/grpc/resolver"
+)
+
+// Load balancing policies for Config.Policy.
+const (
+	RoundRobin   = "round_robin"
+	LeastRequest = "least_request"
+)
+
+type Config struct {
+	Policy string `yaml:"policy" env:"LB_POLICY" flag:"lb-policy" usage:"how calls are spread over a service's replicas: round_robin or least_request"`
+	// HealthCheck ejects replicas that report themselves not serving.
+	HealthCheck bool `yaml:"health_check" env:"LB_HEALTH_CHECK" flag:"lb-health-check" usage:"stop calling replicas whose health service reports them not serving"`
+}
+
+func DefaultConfig() Config {
+	return Config{Policy: RoundRobin, HealthCheck: true}
+}
+
+// Validate reports a policy this package does not know.
+func (c Config) Validate() error {
+	switch c.Policy {
+	case RoundRobin, LeastRequest, "":
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing policy %q (want %s or %s)", c.Policy, RoundRobin, LeastRequest)
+}
+
+// DialOption configures a connection to the replicas of the gRPC service
+// named service, e.g. "menu.v1.MenuService", whose health is what the
+// replicas report under that name. Policies Validate rejects are taken as
+// round_robin.
+func (c Config) DialOption(service string) grpc.DialOption {
+	policy := map[string]interface{}{roundrobin.Name: struct{}{}}
+	if c.Policy == LeastRequest {
+		policy = map[string]interface{}{leastrequest.Name: map[string]int{"choiceCount": 2}}
+	}
+	serviceConfig := map[string]interface{}{
+		"loadBalancingConfig": []interface{}{policy},
+	}
+	if c.HealthCheck {
+		serviceConfig["healthCheckConfig"] = map[string]string{"serviceName": service}
+	}
+	js, _ := json.Marshal(serviceConfig)
+	return grpc.WithDefaultServiceConfig(string(js))
+}
+
+// Target turns an address setting into a target to dial: a comma-separated
+// list becomes a static target, anything else is left as it is.
+func Target(addrs string) string {
+	if strings.Contains(addrs, ",") {
+		return Scheme + ":///" + addrs
+	}
+	return addrs
+}
+
+// Scheme is the resolver scheme for a fixed list of addresses.
+const Scheme = "static"
+
+func init() {
+	resolver.Register(staticBuilder{})
+}
+
+type staticBuilder struct{}
+
+func (staticBuilder) Scheme() string { return Scheme }
+
+func (staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	var state resolver.State
+	for _, addr := range strings.Split(target.Endpoint(), ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("static target %q: %w", target.Endpoint(), err)
+		}
+		// The list is no host name, so each replica's certificate is checked
+		// against its own.
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr, ServerName: host})
+	}
+	if len(state.Addresses) == 0 {
+		return nil, fmt.Errorf("static target %q has no addresses", target.Endpoint())
+	}
+	if err := cc.UpdateState(state); err != nil {
+		return nil, err
+	}
+	return staticResolver{}, nil
+}
+
+// staticResolver has nothing to re-resolve.
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (staticResolver) Close()                                {}
@@ -0,0 +1,130 @@
+package lb
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const service = "test.v1.TestService"
+
+// replica is a server that counts the Check calls it answers.
+type replica struct {
+	addr   string
+	health *health.Server
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (r *replica) served() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func startReplica(t *testing.T) *replica {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &replica{addr: lis.Addr().String(), health: health.NewServer()}
+	r.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+
+	s := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r.mu.Lock()
+		r.calls++
+		r.mu.Unlock()
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(s, r.health)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return r
+}
+
+func dial(t *testing.T, cfg Config, replicas ...*replica) healthpb.HealthClient {
+	addrs := make([]string, len(replicas))
+	for i, r := range replicas {
+		addrs[i] = r.addr
+	}
+	conn, err := grpc.NewClient(Target(strings.Join(addrs, ",")), cfg.DialOption(service), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func call(t *testing.T, client healthpb.HealthClient, n int) {
+	for range n {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+	}
+}
+
+func TestPoliciesSpreadCalls(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{RoundRobin, LeastRequest} {
+		a, b := startReplica(t), startReplica(t)
+		client := dial(t, Config{Policy: policy, HealthCheck: true}, a, b)
+
+		// Both replicas are connected once each has answered a call.
+		require.Eventually(t, func() bool {
+			call(t, client, 1)
+			return a.served() > 0 && b.served() > 0
+		}, 5*time.Second, time.Millisecond, policy)
+	}
+}
+
+func TestHealthCheckEjectsReplicas(t *testing.T) {
+	t.Parallel()
+
+	a, b := startReplica(t), startReplica(t)
+	client := dial(t, DefaultConfig(), a, b)
+	require.Eventually(t, func() bool {
+		call(t, client, 1)
+		return a.served() > 0 && b.served() > 0
+	}, 5*time.Second, time.Millisecond)
+
+	a.health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	// Let the picker catch up: b has to answer two calls in a row.
+	require.Eventually(t, func() bool {
+		before := a.served()
+		call(t, client, 2)
+		return a.served() == before
+	}, 5*time.Second, time.Millisecond)
+
+	before := a.served()
+	call(t, client, 20)
+	assert.Equal(t, before, a.served(), "no calls for the ejected replica")
+
+	a.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	require.Eventually(t, func() bool {
+		call(t, client, 1)
+		return a.served() > before
+	}, 5*time.Second, time.Millisecond, "the replica is back once it serves again")
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, DefaultConfig().Validate())
+	assert.NoError(t, Config{Policy: LeastRequest}.Validate())
+	assert.EqualError(t, Config{Policy: "random"}.Validate(), `unknown load balancing policy "random" (want round_robin or least_request)`)
+}
+
+func TestTarget(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "menu-service:50052", Target("menu-service:50052"))
+	assert.Equal(t, "dns:///menu-service:50052", Target("dns:///menu-service:50052"))
+	assert.Equal(t, "static:///menu-1:50052,menu-2:50052", Target("menu-1:50052,menu-2:50052"))
+}
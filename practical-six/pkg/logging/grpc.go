@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadata is the gRPC metadata key that carries the request ID.
+const requestIDMetadata = "x-request-id"
+
+// UnaryServerInterceptor takes the request ID from the incoming metadata, or
+// makes one up, and writes an access log line once the RPC returns.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = incomingRequestID(ctx)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPC(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := incomingRequestID(ss.Context())
+		start := time.Now()
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		logRPC(ctx, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor forwards the caller's request ID to the server.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+	}
+}
+
+func incomingRequestID(ctx context.Context) context.Context {
+	if ids := metadata.ValueFromIncomingContext(ctx, requestIDMetadata); len(ids) > 0 && validRequestID(ids[0]) {
+		return WithRequestID(ctx, ids[0])
+	}
+	return WithRequestID(ctx, NewRequestID())
+}
+
+func outgoingRequestID(ctx context.Context) context.Context {
+	id := RequestID(ctx)
+	if id == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestIDMetadata, id)
+}
+
+// logRPC writes the access log line for an RPC. Failures that point at the
+// server are errors; health checks are debug, so probes don't drown out
+// real traffic.
+func logRPC(ctx context.Context, fullMethod string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch {
+	case serverError(code):
+		level = slog.LevelError
+	case strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/"):
+		level = slog.LevelDebug
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", fullMethod),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("err", status.Convert(err).Message()))
+	}
+	slog.LogAttrs(ctx, level, "Handled RPC", attrs...)
+}
+
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DeadlineExceeded, codes.DataLoss, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// serverStream overrides the stream's context with one carrying the
+// request ID.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
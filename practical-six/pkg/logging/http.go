@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+)
+
+// Middleware keeps the caller's X-Request-ID, or assigns one, echoes it in
+// the response and writes an access log line per request. Install it with
+// Router.Use.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		m := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		level := slog.LevelInfo
+		if m.Code >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(ctx, level, "Served HTTP request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", m.Code),
+			slog.Int64("bytes", m.Written),
+			slog.Float64("duration_ms", float64(m.Duration.Microseconds())/1000),
+		)
+	})
+}
@@ -0,0 +1,185 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The access logs go to slog's default logger, so tests that read them
+// replace it and must not run in parallel with each other.
+var defaultLogger sync.Mutex
+
+// captureLogs sends the default logger to a buffer for the rest of the test
+// and returns a function that decodes the lines written so far.
+func captureLogs(t *testing.T) func() []map[string]any {
+	defaultLogger.Lock()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(&buf, "test-service", slog.LevelDebug))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		defaultLogger.Unlock()
+	})
+
+	return func() []map[string]any {
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+			lines = append(lines, entry)
+		}
+		return lines
+	}
+}
+
+func TestNewAddsRequestAndTraceIDs(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := New(&buf, "order-service", slog.LevelInfo)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9},
+		SpanID:  trace.SpanID{0x01},
+	}))
+	logger.With("cafe_id", 3).InfoContext(ctx, "Order created")
+	logger.DebugContext(ctx, "dropped below the level")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Order created", entry["msg"])
+	assert.Equal(t, "order-service", entry["service"])
+	assert.Equal(t, "req-1", entry["request_id"])
+	assert.Equal(t, "4bf90000000000000000000000000000", entry["trace_id"])
+	assert.Equal(t, 3.0, entry["cafe_id"])
+}
+
+func TestMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+
+	var seen string
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/api/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		http.Error(w, "order service unavailable", http.StatusServiceUnavailable)
+	}).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/orders/7", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+
+	// A missing or unusable ID is replaced.
+	req = httptest.NewRequest("GET", "/api/orders/8", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, rec.Header().Get(RequestIDHeader))
+
+	lines := logs()
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Served HTTP request", lines[0]["msg"])
+	assert.Equal(t, "ERROR", lines[0]["level"])
+	assert.Equal(t, "abc-123", lines[0]["request_id"])
+	assert.Equal(t, "GET", lines[0]["method"])
+	assert.Equal(t, "/api/orders/7", lines[0]["path"])
+	assert.Equal(t, "/api/orders/{id}", lines[0]["route"])
+	assert.Equal(t, 503.0, lines[0]["status"])
+	assert.Contains(t, lines[0], "duration_ms")
+}
+
+func TestRequestIDCrossesGRPC(t *testing.T) {
+	logs := captureLogs(t)
+
+	// The client side puts the ID in the outgoing metadata...
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	ctx := WithRequestID(context.Background(), "abc-123")
+	require.NoError(t, UnaryClientInterceptor()(ctx, "/order.v1.OrderService/CreateOrder", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"abc-123"}, md.Get("x-request-id"))
+
+	// ...and the server side picks it up from there.
+	var seen string
+	handler := func(ctx context.Context, req any) (any, error) {
+		seen = RequestID(ctx)
+		return nil, status.Error(codes.NotFound, "order 7 not found")
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.v1.OrderService/GetOrder"}
+	_, err := UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), md), nil, info, handler)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "abc-123", seen)
+
+	// Health checks without an ID get a fresh one and log at debug.
+	health := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	UnaryServerInterceptor()(context.Background(), nil, health, func(ctx context.Context, req any) (any, error) {
+		seen = RequestID(ctx)
+		return nil, nil
+	})
+	assert.Len(t, seen, 32)
+
+	lines := logs()
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Handled RPC", lines[0]["msg"])
+	assert.Equal(t, "INFO", lines[0]["level"])
+	assert.Equal(t, "abc-123", lines[0]["request_id"])
+	assert.Equal(t, "/order.v1.OrderService/GetOrder", lines[0]["method"])
+	assert.Equal(t, "NotFound", lines[0]["code"])
+	assert.Equal(t, "order 7 not found", lines[0]["err"])
+	assert.Equal(t, "DEBUG", lines[1]["level"])
+}
+
+func TestLevelHandler(t *testing.T) {
+	logs := captureLogs(t)
+
+	level := new(slog.LevelVar)
+	handler := LevelHandler(level)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/loglevel", nil))
+	assert.JSONEq(t, `{"level": "INFO"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"level": "debug"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level": "DEBUG"}`, rec.Body.String())
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"level": "chatty"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/loglevel", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	lines := logs()
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Log level changed", lines[0]["msg"])
+	assert.Equal(t, "DEBUG", lines[0]["to"])
+}
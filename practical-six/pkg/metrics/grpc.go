@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	unary        = "unary"
+	clientStream = "client_stream"
+	serverStream = "server_stream"
+	bidiStream   = "bidi_stream"
+)
+
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		r.observe(r.grpcServerHandled, r.grpcServerDuration, info.FullMethod, unary, start, err)
+		return resp, err
+	}
+}
+
+func (r *Recorder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		r.observe(r.grpcServerHandled, r.grpcServerDuration, info.FullMethod, streamType(info.IsClientStream, info.IsServerStream), start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor records outgoing calls. Install it before
+// rpcclient's interceptor so a call is counted once, however many times it
+// was retried.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		r.observe(r.grpcClientHandled, r.grpcClientDuration, method, unary, start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor records how long it took to open a stream and
+// whether that succeeded.
+func (r *Recorder) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		r.observe(r.grpcClientHandled, r.grpcClientDuration, method, streamType(desc.ClientStreams, desc.ServerStreams), start, err)
+		return stream, err
+	}
+}
+
+func (r *Recorder) observe(handled *prometheus.CounterVec, duration *prometheus.HistogramVec, fullMethod, rpcType string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	handled.WithLabelValues(service, method, rpcType, status.Code(err).String()).Inc()
+	duration.WithLabelValues(service, method, rpcType).Observe(time.Since(start).Seconds())
+}
+
+// splitMethod splits "/pkg.Service/Method" into its service and method.
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+func streamType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return bidiStream
+	case clientStreams:
+		return clientStream
+	case serverStreams:
+		return serverStream
+	default:
+		return unary
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware records each request under its gorilla/mux route template, so
+// /api/users/1 and /api/users/2 share a series. Install it with Router.Use,
+// and wrap the router's NotFoundHandler and MethodNotAllowedHandler with it
+// too, which Router.Use skips, to count requests no route matches under
+// route "unmatched".
+func (r *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(req); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		r.httpRequests.WithLabelValues(req.Method, route, strconv.Itoa(rec.status)).Inc()
+		r.httpDuration.WithLabelValues(req.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
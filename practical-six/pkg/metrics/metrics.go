@@ -0,0 +1,78 @@
+// Package metrics records rate, errors and duration (RED) for the gRPC and
+// HTTP traffic of a practical-six binary and serves them to Prometheus.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Recorder holds the request counters and latency histograms. A binary
+// creates one and installs its interceptors and middleware.
+type Recorder struct {
+	grpcServerHandled  *prometheus.CounterVec
+	grpcServerDuration *prometheus.HistogramVec
+	grpcClientHandled  *prometheus.CounterVec
+	grpcClientDuration *prometheus.HistogramVec
+	httpRequests       *prometheus.CounterVec
+	httpDuration       *prometheus.HistogramVec
+}
+
+// NewRegistry returns a registry with the Go runtime and process collectors
+// already registered.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// NewRecorder creates the RED metrics and registers them with reg.
+func NewRecorder(reg prometheus.Registerer) *Recorder {
+	grpcLabels := []string{"grpc_service", "grpc_method", "grpc_type"}
+	r := &Recorder{
+		grpcServerHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by method and status code.",
+		}, append(grpcLabels, "grpc_code")),
+		grpcServerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time the server took to handle an RPC.",
+			Buckets: prometheus.DefBuckets,
+		}, grpcLabels),
+		grpcClientHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_client_handled_total",
+			Help: "RPCs completed by the client, by method and status code.",
+		}, append(grpcLabels, "grpc_code")),
+		grpcClientDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_client_handling_seconds",
+			Help:    "Time until the client received a response, retries included.",
+			Buckets: prometheus.DefBuckets,
+		}, grpcLabels),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by route and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve an HTTP request.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+	reg.MustRegister(
+		r.grpcServerHandled, r.grpcServerDuration,
+		r.grpcClientHandled, r.grpcClientDuration,
+		r.httpRequests, r.httpDuration,
+	)
+	return r
+}
+
+// Handler serves g in the Prometheus text format.
+func Handler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
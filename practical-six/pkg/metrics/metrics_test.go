@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder(prometheus.NewRegistry())
+	intercept := r.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.v1.UserService/GetUser"}
+
+	ok := func(ctx context.Context, req any) (any, error) { return "user", nil }
+	notFound := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	resp, err := intercept(context.Background(), nil, info, ok)
+	require.NoError(t, err)
+	assert.Equal(t, "user", resp)
+	intercept(context.Background(), nil, info, ok)
+	_, err = intercept(context.Background(), nil, info, notFound)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.grpcServerHandled.WithLabelValues("user.v1.UserService", "GetUser", "unary", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.grpcServerHandled.WithLabelValues("user.v1.UserService", "GetUser", "unary", "NotFound")))
+	assert.Equal(t, 1, testutil.CollectAndCount(r.grpcServerDuration))
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder(prometheus.NewRegistry())
+	info := &grpc.StreamServerInfo{FullMethod: "/menu.v1.MenuService/WatchMenu", IsServerStream: true}
+	r.StreamServerInterceptor()(nil, nil, info, func(srv any, ss grpc.ServerStream) error {
+		return status.Error(codes.Unavailable, "draining")
+	})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.grpcServerHandled.WithLabelValues("menu.v1.MenuService", "WatchMenu", "server_stream", "Unavailable")))
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder(prometheus.NewRegistry())
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.DeadlineExceeded, "too slow")
+	}
+	err := r.UnaryClientInterceptor()(context.Background(), "/menu.v1.MenuService/GetMenuItem", nil, nil, nil, invoker)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.grpcClientHandled.WithLabelValues("menu.v1.MenuService", "GetMenuItem", "unary", "DeadlineExceeded")))
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder(prometheus.NewRegistry())
+	desc := &grpc.StreamDesc{StreamName: "WatchOrders", ServerStreams: true}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, status.Error(codes.Unavailable, "no healthy upstream")
+	}
+	_, err := r.StreamClientInterceptor()(context.Background(), desc, nil, "/order.v1.OrderService/WatchOrders", streamer)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.grpcClientHandled.WithLabelValues("order.v1.OrderService", "WatchOrders", "server_stream", "Unavailable")))
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	r := NewRecorder(prometheus.NewRegistry())
+	router := mux.NewRouter()
+	router.Use(r.Middleware)
+	router.HandleFunc("/api/users/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if mux.Vars(req)["id"] == "0" {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	}).Methods("GET")
+	router.NotFoundHandler = r.Middleware(http.NotFoundHandler())
+
+	for _, path := range []string{"/api/users/1", "/api/users/2", "/api/users/0", "/wp-admin", "/.env"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.httpRequests.WithLabelValues("GET", "/api/users/{id}", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.httpRequests.WithLabelValues("GET", "/api/users/{id}", "400")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.httpRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	r := NewRecorder(reg)
+	r.httpRequests.WithLabelValues("GET", "/healthz", "200").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `http_requests_total{code="200",method="GET",route="/healthz"} 1`)
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
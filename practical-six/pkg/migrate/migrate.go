@@ -0,0 +1,410 @@
+// Package migrate applies versioned SQL migrations to a service database.
+//
+// Migrations are read from an fs.FS (normally an embed.FS) holding pairs of
+// files named "<version>_<name>.up.sql" and "<version>_<name>.down.sql", for
+// example "0003_add_cafe_id.up.sql". Applied versions are recorded in the
+// schema_migrations table. Each migration runs in its own transaction.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	// Down is empty for migrations that cannot be rolled back.
+	Down string
+}
+
+// String returns the migration's file prefix, e.g. "0003_add_cafe_id".
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status describes a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Locker serialises migration runs across processes, so replicas starting at
+// the same time do not apply the same migration twice.
+type Locker interface {
+	Lock(ctx context.Context, conn *sql.Conn) error
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+type advisoryLock int64
+
+// AdvisoryLock returns a Locker that holds a Postgres session-level advisory
+// lock for the duration of a run. The lock key is derived from name, which
+// should identify the database's owner, e.g. "user-service".
+func AdvisoryLock(name string) Locker {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return advisoryLock(h.Sum64())
+}
+
+func (l advisoryLock) Lock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(l))
+	return err
+}
+
+func (l advisoryLock) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", int64(l))
+	return err
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// Locker guards every run. Nil means no locking, which is only safe when
+	// a single process migrates the database (e.g. in tests).
+	Locker Locker
+}
+
+// New loads the migrations in fsys and returns a Migrator for db.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads the migrations in the root of fsys, sorted by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		version, name, direction, err := parseFilename(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// parseFilename splits "0001_create_users.up.sql" into 1, "create_users", "up".
+func parseFilename(filename string) (uint, string, string, error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	direction := path.Ext(base)
+	if direction != ".up" && direction != ".down" {
+		return 0, "", "", fmt.Errorf("migration %s: expected .up.sql or .down.sql", filename)
+	}
+	base = strings.TrimSuffix(base, direction)
+
+	prefix, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", "", fmt.Errorf("migration %s: expected <version>_<name>", filename)
+	}
+	version, err := strconv.ParseUint(prefix, 10, 32)
+	if err != nil || version == 0 {
+		return 0, "", "", fmt.Errorf("migration %s: version must be a positive integer", filename)
+	}
+	return uint(version), name, direction[1:], nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.run(ctx, func(conn *sql.Conn, done map[uint]time.Time) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the most recently applied migration. It returns nil if no
+// migration has been applied.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+	err := m.run(ctx, func(conn *sql.Conn, done map[uint]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if err := rollback(ctx, conn, migration); err != nil {
+				return err
+			}
+			rolledBack = &migration
+			return nil
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// To applies or rolls back migrations until exactly the migrations up to and
+// including version are applied. Version 0 rolls back everything.
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.run(ctx, func(conn *sql.Conn, done map[uint]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok || migration.Version <= version {
+				continue
+			}
+			if err := rollback(ctx, conn, migration); err != nil {
+				return err
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status reports every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.run(ctx, func(conn *sql.Conn, done map[uint]time.Time) error {
+		for _, migration := range m.migrations {
+			appliedAt, ok := done[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) find(version uint) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// run takes the lock, makes sure schema_migrations exists and calls fn with
+// the applied versions. Everything runs on one connection so a session-level
+// lock covers the whole run.
+func (m *Migrator) run(ctx context.Context, fn func(conn *sql.Conn, done map[uint]time.Time) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Locker != nil {
+		if err := m.Locker.Lock(ctx, conn); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer func() {
+			if unlockErr := m.Locker.Unlock(context.WithoutCancel(ctx), conn); unlockErr != nil && err == nil {
+				err = fmt.Errorf("release migration lock: %w", unlockErr)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	done, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[uint]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[uint]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("read schema_migrations: %w", err)
+		}
+		done[uint(version)] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("apply migration %s: %w", migration, err)
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			int64(migration.Version), migration.Name, time.Now().UTC())
+		return err
+	})
+}
+
+func rollback(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %s cannot be rolled back: no down file", migration)
+	}
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("roll back migration %s: %w", migration, err)
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", int64(migration.Version))
+		return err
+	})
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ErrUsage is returned by Run when the arguments are not a valid command.
+var ErrUsage = errors.New("usage: migrate up | down | status | to <version>")
+
+// Run executes a migrate subcommand ("up", "down", "status" or "to <version>")
+// and writes a human-readable report to out.
+func (m *Migrator) Run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return ErrUsage
+		}
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %s\n", migration)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case "down":
+		if len(args) != 1 {
+			return ErrUsage
+		}
+		migration, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Fprintln(out, "no migrations to roll back")
+			return nil
+		}
+		fmt.Fprintf(out, "rolled back %s\n", migration)
+		return nil
+	case "status":
+		if len(args) != 1 {
+			return ErrUsage
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	case "to":
+		if len(args) != 2 {
+			return ErrUsage
+		}
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], ErrUsage)
+		}
+		if err := m.To(ctx, uint(version)); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "migrated to version %d\n", version)
+		return nil
+	default:
+		return ErrUsage
+	}
+}
@@ -0,0 +1,234 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_items.up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+	"0001_create_items.down.sql": {Data: []byte("DROP TABLE items;")},
+	"0002_add_price.up.sql": {Data: []byte(`
+		ALTER TABLE items ADD COLUMN price REAL;
+		UPDATE items SET price = 0 WHERE price IS NULL;`)},
+	"0002_add_price.down.sql":   {Data: []byte("ALTER TABLE items DROP COLUMN price;")},
+	"0003_create_tags.up.sql":   {Data: []byte("CREATE TABLE tags (name TEXT);")},
+	"0003_create_tags.down.sql": {Data: []byte("DROP TABLE tags;")},
+	"README.md":                 {Data: []byte("ignored")},
+}
+
+func openDB(t *testing.T) *sql.DB {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newMigrator(t *testing.T) (*Migrator, *sql.DB) {
+	db := openDB(t)
+	m, err := New(db, testMigrations)
+	require.NoError(t, err)
+	return m, db
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1", table).Scan(&count)
+	require.NoError(t, err)
+	return count > 0
+}
+
+func appliedCount(t *testing.T, m *Migrator) int {
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	n := 0
+	for _, s := range statuses {
+		if s.Applied {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	migrations, err := Load(testMigrations)
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.Equal(t, uint(1), migrations[0].Version)
+	assert.Equal(t, "create_items", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE items;", migrations[0].Down)
+	assert.Equal(t, "add_price", migrations[1].Name)
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"missing version", fstest.MapFS{"create.up.sql": {}}},
+		{"zero version", fstest.MapFS{"0000_create.up.sql": {}}},
+		{"unknown direction", fstest.MapFS{"0001_create.sideways.sql": {}}},
+		{"down without up", fstest.MapFS{"0001_create.down.sql": {Data: []byte("DROP TABLE x;")}}},
+		{"mismatched names", fstest.MapFS{
+			"0001_create.up.sql":  {Data: []byte("SELECT 1;")},
+			"0001_other.down.sql": {Data: []byte("SELECT 1;")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.files)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	t.Parallel()
+
+	m, db := newMigrator(t)
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, 3)
+	assert.True(t, tableExists(t, db, "items"))
+	assert.True(t, tableExists(t, db, "tags"))
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	rolledBack, err := m.Down(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, rolledBack)
+	assert.Equal(t, uint(3), rolledBack.Version)
+	assert.False(t, tableExists(t, db, "tags"))
+	assert.Equal(t, 2, appliedCount(t, m))
+}
+
+func TestTo(t *testing.T) {
+	t.Parallel()
+
+	m, db := newMigrator(t)
+	ctx := context.Background()
+
+	require.NoError(t, m.To(ctx, 2))
+	assert.Equal(t, 2, appliedCount(t, m))
+	assert.False(t, tableExists(t, db, "tags"))
+
+	_, err := db.Exec("INSERT INTO items (name, price) VALUES ('latte', 3.5)")
+	require.NoError(t, err)
+
+	require.NoError(t, m.To(ctx, 3))
+	assert.Equal(t, 3, appliedCount(t, m))
+
+	require.NoError(t, m.To(ctx, 1))
+	assert.Equal(t, 1, appliedCount(t, m))
+	var name string
+	require.NoError(t, db.QueryRow("SELECT name FROM items").Scan(&name))
+	assert.Equal(t, "latte", name)
+
+	require.NoError(t, m.To(ctx, 0))
+	assert.Zero(t, appliedCount(t, m))
+	assert.False(t, tableExists(t, db, "items"))
+
+	assert.Error(t, m.To(ctx, 9))
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	m, err := New(db, fstest.MapFS{
+		"0001_create_items.up.sql": {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);")},
+		"0002_broken.up.sql":       {Data: []byte("CREATE TABLE broken (id INTEGER); SELECT * FROM missing;")},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0002_broken")
+	assert.Len(t, applied, 1)
+	assert.Equal(t, 1, appliedCount(t, m))
+	assert.False(t, tableExists(t, db, "broken"))
+
+	_, err = m.Down(ctx)
+	assert.ErrorContains(t, err, "no down file")
+}
+
+type recordingLocker struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *recordingLocker) Lock(ctx context.Context, conn *sql.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, "lock")
+	return nil
+}
+
+func (l *recordingLocker) Unlock(ctx context.Context, conn *sql.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, "unlock")
+	return nil
+}
+
+func TestLockerWrapsEveryRun(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newMigrator(t)
+	locker := &recordingLocker{}
+	m.Locker = locker
+	ctx := context.Background()
+
+	_, err := m.Up(ctx)
+	require.NoError(t, err)
+	_, err = m.Status(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"lock", "unlock", "lock", "unlock"}, locker.calls)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newMigrator(t)
+	ctx := context.Background()
+	var out bytes.Buffer
+
+	require.NoError(t, m.Run(ctx, []string{"to", "1"}, &out))
+	assert.Equal(t, "migrated to version 1\n", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Run(ctx, []string{"status"}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.NotContains(t, lines[1], "pending")
+	assert.Contains(t, lines[2], "pending")
+
+	out.Reset()
+	require.NoError(t, m.Run(ctx, []string{"up"}, &out))
+	assert.Equal(t, "applied 0002_add_price\napplied 0003_create_tags\n", out.String())
+
+	out.Reset()
+	require.NoError(t, m.Run(ctx, []string{"down"}, &out))
+	assert.Equal(t, "rolled back 0003_create_tags\n", out.String())
+
+	for _, args := range [][]string{nil, {"sideways"}, {"to"}, {"to", "x"}, {"up", "now"}} {
+		assert.ErrorIs(t, m.Run(ctx, args, &out), ErrUsage, "args %v", args)
+	}
+}
@@ -0,0 +1,97 @@
+package mtls
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TrustDomain is the SPIFFE trust domain practical-six certificates are
+// issued in.
+const TrustDomain = "practical6.local"
+
+// ID returns the SPIFFE ID of a service, e.g.
+// "spiffe://practical6.local/order-service".
+func ID(service string) string {
+	return "spiffe://" + TrustDomain + "/" + service
+}
+
+// Policy says which callers may call which methods. Keys are full method
+// names ("/menu.v1.MenuService/GetMenuItem") or service names with a
+// trailing slash ("/menu.v1.MenuService/"), which cover the methods of the
+// service without a key of their own. Values are SPIFFE IDs. Methods the
+// policy does not cover cannot be called at all.
+type Policy map[string][]string
+
+// Allows reports whether the caller with SPIFFE ID id may call fullMethod.
+func (p Policy) Allows(fullMethod, id string) bool {
+	callers, ok := p[fullMethod]
+	if !ok {
+		callers = p[fullMethod[:strings.LastIndex(fullMethod, "/")+1]]
+	}
+	for _, caller := range callers {
+		if caller == id {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerID returns the SPIFFE ID in the verified client certificate of the
+// call ctx belongs to.
+func PeerID(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return "", false
+	}
+	for _, uri := range info.State.VerifiedChains[0][0].URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String(), true
+		}
+	}
+	return "", false
+}
+
+func (c *Credentials) authorize(ctx context.Context, policy Policy, fullMethod string) error {
+	if !c.Mutual() {
+		return nil
+	}
+	id, ok := PeerID(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "client certificate has no SPIFFE ID")
+	}
+	if !policy.Allows(fullMethod, id) {
+		return status.Errorf(codes.PermissionDenied, "%s may not call %s", id, fullMethod)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor refuses calls policy does not allow. Without mutual
+// TLS there are no verified callers to check, and every call is let through.
+func (c *Credentials) UnaryServerInterceptor(policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := c.authorize(ctx, policy, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls.
+func (c *Credentials) StreamServerInterceptor(policy Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := c.authorize(ss.Context(), policy, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
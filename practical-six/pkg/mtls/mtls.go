@@ -0,0 +1,227 @@
+// Package mtls secures the gRPC connections between practical-six services
+// with TLS and, when both ends present certificates, mutual TLS.
+//
+// Certificates and the CA bundle are read from files and read again when
+// they change, so they can be rotated without a restart. Each service's
+// certificate names it with a SPIFFE ID (see ID), which Policy uses to decide
+// which services may call which methods.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type Config struct {
+	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate this binary presents; unset with tls-ca-file unset means plaintext"`
+	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key for tls-cert-file"`
+	CAFile   string `yaml:"ca_file" env:"TLS_CA_FILE" flag:"tls-ca-file" usage:"PEM CA bundle peers are verified against; with a certificate set as well, servers require client certificates"`
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"how often to check the certificate files for changes"`
+}
+
+// DefaultConfig is plaintext. Set the files to turn TLS on.
+func DefaultConfig() Config {
+	return Config{ReloadInterval: 10 * time.Second}
+}
+
+// Credentials hands out transport credentials backed by the files in a
+// Config. Connections made after a reload use the new files; established
+// ones keep what they were set up with.
+type Credentials struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	roots  *x509.CertPool
+	stamps map[string]stamp
+}
+
+// stamp tells whether a file has changed since it was read.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Load reads the files cfg names. Without any, the returned Credentials are
+// plaintext.
+func Load(cfg Config) (*Credentials, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("tls: cert_file and key_file must be set together")
+	}
+	c := &Credentials{cfg: cfg}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Enabled reports whether connections use TLS.
+func (c *Credentials) Enabled() bool {
+	return c.cfg.CertFile != "" || c.cfg.CAFile != ""
+}
+
+// Mutual reports whether servers require and verify client certificates.
+func (c *Credentials) Mutual() bool {
+	return c.cfg.CertFile != "" && c.cfg.CAFile != ""
+}
+
+func (c *Credentials) files() []string {
+	var files []string
+	for _, f := range []string{c.cfg.CertFile, c.cfg.KeyFile, c.cfg.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Reload reads the files again if any of them has changed, and reports
+// whether it did. On error the files in use are kept.
+func (c *Credentials) Reload() (bool, error) {
+	stamps := make(map[string]stamp)
+	for _, f := range c.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		stamps[f] = stamp{info.ModTime(), info.Size()}
+	}
+	c.mu.RLock()
+	changed := len(stamps) != len(c.stamps)
+	for f, s := range stamps {
+		changed = changed || c.stamps[f] != s
+	}
+	c.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if c.cfg.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: load key pair: %w", err)
+		}
+		cert = &pair
+	}
+	var roots *x509.CertPool
+	if c.cfg.CAFile != "" {
+		pem, err := os.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("tls: no certificates in %s", c.cfg.CAFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.roots, c.stamps = cert, roots, stamps
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Run reloads the files whenever they change until ctx is done. A file that
+// cannot be read is logged and the previous one kept, so a rotation caught
+// halfway through is picked up on the next check.
+func (c *Credentials) Run(ctx context.Context) {
+	if !c.Enabled() || c.cfg.ReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				slog.Error("Failed to reload TLS certificates", "err", err)
+			} else if reloaded {
+				slog.Info("Reloaded TLS certificates")
+			}
+		}
+	}
+}
+
+func (c *Credentials) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, c.roots
+}
+
+// Server returns credentials for a grpc.Server.
+func (c *Credentials) Server() credentials.TransportCredentials {
+	if !c.Enabled() {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Every handshake gets the files as they are now.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, roots := c.current()
+			if cert == nil {
+				return nil, errors.New("tls: no server certificate configured")
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2"},
+			}
+			if c.Mutual() {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = roots
+			}
+			return cfg, nil
+		},
+	})
+}
+
+// Client returns credentials for dialing a server.
+func (c *Credentials) Client() credentials.TransportCredentials {
+	if !c.Enabled() {
+		return insecure.NewCredentials()
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := c.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if c.cfg.CAFile != "" {
+		// crypto/tls only takes roots up front, so the server's chain is
+		// verified here against the CA bundle as it is now. Skipping the
+		// built-in check is what makes room for this one, not a way round it.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, roots := c.current()
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			return err
+		}
+	}
+	return credentials.NewTLS(cfg)
+}
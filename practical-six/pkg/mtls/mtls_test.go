@@ -0,0 +1,182 @@
+package mtls
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const day = 24 * time.Hour
+
+// writeCerts writes a new CA and certificates for services to dir.
+func writeCerts(t *testing.T, dir string, services ...string) {
+	ca, err := NewCA(day)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), ca.CertPEM, 0o644))
+	for _, service := range services {
+		require.NoError(t, ca.WriteFiles(dir, service, day, "localhost", "127.0.0.1"))
+	}
+}
+
+func configFor(dir, service string) Config {
+	return Config{
+		CertFile: filepath.Join(dir, service+".pem"),
+		KeyFile:  filepath.Join(dir, service+"-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+}
+
+func load(t *testing.T, cfg Config) *Credentials {
+	creds, err := Load(cfg)
+	require.NoError(t, err)
+	return creds
+}
+
+// serve starts a health server with creds and policy, and returns its
+// address.
+func serve(t *testing.T, creds *Credentials, policy Policy) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer(
+		grpc.Creds(creds.Server()),
+		grpc.ChainUnaryInterceptor(creds.UnaryServerInterceptor(policy)),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// check calls Health.Check on addr as creds and returns the status code.
+func check(t *testing.T, addr string, creds *Credentials) codes.Code {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds.Client()))
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return status.Code(err)
+}
+
+func TestMutualTLSAuthorizesByIdentity(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeCerts(t, dir, "menu-service", "order-service", "api-gateway")
+	addr := serve(t, load(t, configFor(dir, "menu-service")), Policy{
+		"/grpc.health.v1.Health/Check": {ID("order-service")},
+	})
+
+	assert.Equal(t, codes.OK, check(t, addr, load(t, configFor(dir, "order-service"))))
+	assert.Equal(t, codes.PermissionDenied, check(t, addr, load(t, configFor(dir, "api-gateway"))))
+
+	noCert := load(t, Config{CAFile: filepath.Join(dir, "ca.pem")})
+	assert.Equal(t, codes.Unavailable, check(t, addr, noCert), "servers require a client certificate")
+
+	other := t.TempDir()
+	writeCerts(t, other, "order-service")
+	assert.Equal(t, codes.Unavailable, check(t, addr, load(t, configFor(other, "order-service"))), "another CA is not trusted")
+}
+
+func TestServerOnlyTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeCerts(t, dir, "menu-service")
+	server := configFor(dir, "menu-service")
+	server.CAFile = ""
+	creds := load(t, server)
+	assert.True(t, creds.Enabled())
+	assert.False(t, creds.Mutual())
+	addr := serve(t, creds, Policy{})
+
+	assert.Equal(t, codes.OK, check(t, addr, load(t, Config{CAFile: filepath.Join(dir, "ca.pem")})), "no policy without client certificates")
+	assert.Equal(t, codes.Unavailable, check(t, addr, load(t, Config{})), "plaintext clients cannot connect")
+}
+
+func TestReloadPicksUpRotatedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeCerts(t, dir, "menu-service", "order-service")
+	server := load(t, configFor(dir, "menu-service"))
+	addr := serve(t, server, Policy{"/grpc.health.v1.Health/": {ID("order-service")}})
+	oldClient := load(t, configFor(dir, "order-service"))
+
+	reloaded, err := server.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "nothing changed")
+
+	writeCerts(t, dir, "menu-service", "order-service")
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{"ca.pem", "menu-service.pem", "menu-service-key.pem"} {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, f), later, later))
+	}
+	reloaded, err = server.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	assert.Equal(t, codes.OK, check(t, addr, load(t, configFor(dir, "order-service"))))
+	assert.Equal(t, codes.Unavailable, check(t, addr, oldClient), "the old CA is no longer trusted")
+}
+
+func TestReloadKeepsFilesInUseOnError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeCerts(t, dir, "menu-service")
+	creds := load(t, configFor(dir, "menu-service"))
+	cert, _ := creds.current()
+
+	later := time.Now().Add(time.Minute)
+	keyFile := filepath.Join(dir, "menu-service-key.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte("half a key"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	_, err := creds.Reload()
+	assert.ErrorContains(t, err, "load key pair")
+
+	current, _ := creds.current()
+	assert.Same(t, cert, current)
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	creds := load(t, DefaultConfig())
+	assert.False(t, creds.Enabled())
+	assert.Equal(t, "insecure", creds.Server().Info().SecurityProtocol)
+	assert.Equal(t, "insecure", creds.Client().Info().SecurityProtocol)
+
+	_, err := Load(Config{CertFile: "cert.pem"})
+	assert.EqualError(t, err, "tls: cert_file and key_file must be set together")
+
+	_, err = Load(Config{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "missing.pem")
+}
+
+func TestPolicyAllows(t *testing.T) {
+	t.Parallel()
+
+	gateway, orders := ID("api-gateway"), ID("order-service")
+	policy := Policy{
+		"/menu.v1.MenuService/":            {gateway},
+		"/menu.v1.MenuService/GetMenuItem": {gateway, orders},
+	}
+
+	assert.Equal(t, "spiffe://practical6.local/order-service", orders)
+	assert.True(t, policy.Allows("/menu.v1.MenuService/GetMenuItem", orders))
+	assert.True(t, policy.Allows("/menu.v1.MenuService/CreateMenuItem", gateway))
+	assert.False(t, policy.Allows("/menu.v1.MenuService/CreateMenuItem", orders), "the service key covers the rest")
+	assert.False(t, policy.Allows("/order.v1.OrderService/GetOrder", gateway), "uncovered methods are refused")
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many Take calls pass between sweeps for idle buckets.
+const sweepEvery = 1024
+
+// MemoryStore keeps buckets in the process, so each gateway replica limits
+// clients on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	burst := float64(max(limit.Burst, 1))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*limit.Rate)
+		b.last = now
+	}
+
+	result := Result{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = refill(1-b.tokens, limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = refill(burst-b.tokens, limit.Rate)
+	return result, nil
+}
+
+// sweep forgets buckets that have refilled completely: a new bucket would
+// behave the same.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		burst := float64(max(b.limit.Burst, 1))
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= burst {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// refill returns how long it takes to gain tokens at rate per second.
+func refill(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
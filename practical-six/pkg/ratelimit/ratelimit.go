@@ -0,0 +1,159 @@
+// Package ratelimit throttles HTTP clients with token buckets. Every client
+// gets a bucket per route, keyed by its user when the request is
+// authenticated and by its IP address otherwise. Writes draw from a smaller,
+// slower bucket than reads.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Limit is a token bucket: it holds up to Burst requests and refills at Rate
+// requests per second. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the state of a bucket after a request has been charged to it.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests the bucket would allow right now.
+	Remaining int
+	// RetryAfter is how long a rejected client has to wait for a token.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take refills the bucket at key for the time since
+// it was last used, then takes a token from it if it has one. Implementations
+// shared between gateway replicas, such as Redis, must do that atomically.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+type Config struct {
+	ReadRate   float64 `yaml:"read_rate" env:"RATE_LIMIT_READ_RATE" flag:"rate-limit-read-rate" usage:"GET requests per second each client may make to a route; 0 disables the limit"`
+	ReadBurst  int     `yaml:"read_burst" env:"RATE_LIMIT_READ_BURST" flag:"rate-limit-read-burst" usage:"GET requests a client may make to a route at once"`
+	WriteRate  float64 `yaml:"write_rate" env:"RATE_LIMIT_WRITE_RATE" flag:"rate-limit-write-rate" usage:"POST, PUT, PATCH and DELETE requests per second each client may make to a route; 0 disables the limit"`
+	WriteBurst int     `yaml:"write_burst" env:"RATE_LIMIT_WRITE_BURST" flag:"rate-limit-write-burst" usage:"writes a client may make to a route at once"`
+}
+
+// DefaultConfig is generous enough for a person clicking through the UI and
+// stops a script from flooding the write endpoints.
+func DefaultConfig() Config {
+	return Config{
+		ReadRate:   10,
+		ReadBurst:  20,
+		WriteRate:  1,
+		WriteBurst: 5,
+	}
+}
+
+type Limiter struct {
+	Store Store
+	Read  Limit
+	Write Limit
+	// ReadRoutes are route templates whose requests are charged as reads
+	// whatever their method, such as a query endpoint that takes POSTs.
+	ReadRoutes []string
+	// User returns the authenticated user making the request, or "" for an
+	// anonymous one. Defaults to treating every request as anonymous.
+	User func(r *http.Request) string
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func New(cfg Config, store Store) *Limiter {
+	return &Limiter{
+		Store: store,
+		Read:  Limit{Rate: cfg.ReadRate, Burst: cfg.ReadBurst},
+		Write: Limit{Rate: cfg.WriteRate, Burst: cfg.WriteBurst},
+		Now:   time.Now,
+	}
+}
+
+// Middleware charges each request to its client's bucket for the matched
+// gorilla/mux route and answers 429 Too Many Requests once it is empty.
+// Install it with Router.Use.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := route(r)
+		limit := l.Read
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !slices.Contains(l.ReadRoutes, route) {
+				limit = l.Write
+			}
+		}
+		if limit.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		if l.Now != nil {
+			now = l.Now()
+		}
+		key := l.client(r) + " " + r.Method + " " + route
+		result, err := l.Store.Take(r.Context(), key, limit, now)
+		if err != nil {
+			// Better to serve a few requests too many than none at all.
+			slog.WarnContext(r.Context(), "Rate limit store failed, letting request through", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(max(limit.Burst, 1)))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("X-RateLimit-Reset", seconds(result.Reset))
+		if !result.Allowed {
+			h.Set("Retry-After", seconds(result.RetryAfter))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// client identifies who a request is charged to.
+func (l *Limiter) client(r *http.Request) string {
+	if l.User != nil {
+		if user := l.User(r); user != "" {
+			return "user:" + user
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// seconds formats d as whole seconds, rounded up so clients that wait that
+// long are not turned away again.
+func seconds(d time.Duration) string {
+	return fmt.Sprint(int64(math.Ceil(d.Seconds())))
+}
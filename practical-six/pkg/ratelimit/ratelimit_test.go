@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// setup returns a router with reads limited to 2/s (burst 4) and writes to
+// one every 10s (burst 2).
+func setup() (*mux.Router, *Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)}
+	limiter := New(Config{ReadRate: 2, ReadBurst: 4, WriteRate: 0.1, WriteBurst: 2}, NewMemoryStore())
+	limiter.Now = clock.Now
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := mux.NewRouter()
+	router.Use(limiter.Middleware)
+	router.HandleFunc("/api/orders", ok).Methods("GET", "POST")
+	router.HandleFunc("/api/orders/{id}", ok).Methods("GET")
+	router.HandleFunc("/api/menu", ok).Methods("POST")
+	return router, limiter, clock
+}
+
+func do(router http.Handler, method, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":51234"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBurstThenRefill(t *testing.T) {
+	t.Parallel()
+
+	router, _, clock := setup()
+
+	for i := 3; i >= 0; i-- {
+		rec := do(router, "GET", "/api/orders", "10.0.0.1")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "4", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(i), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	rec := do(router, "GET", "/api/orders", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+
+	// Half a second buys one more request at 2/s.
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, do(router, "GET", "/api/orders", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(router, "GET", "/api/orders", "10.0.0.1").Code)
+
+	// The bucket never holds more than the burst.
+	clock.Advance(time.Hour)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusOK, do(router, "GET", "/api/orders", "10.0.0.1").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(router, "GET", "/api/orders", "10.0.0.1").Code)
+}
+
+func TestWritesAreStricter(t *testing.T) {
+	t.Parallel()
+
+	router, _, clock := setup()
+
+	assert.Equal(t, http.StatusOK, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+	rec := do(router, "POST", "/api/orders", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "20", rec.Header().Get("X-RateLimit-Reset"))
+
+	// Reads of the same route have their own bucket.
+	assert.Equal(t, http.StatusOK, do(router, "GET", "/api/orders", "10.0.0.1").Code)
+
+	clock.Advance(9 * time.Second)
+	assert.Equal(t, http.StatusTooManyRequests, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+}
+
+func TestReadRoutesAreChargedAsReads(t *testing.T) {
+	t.Parallel()
+
+	router, limiter, _ := setup()
+	limiter.ReadRoutes = []string{"/api/menu"}
+
+	for i := 0; i < 4; i++ {
+		require.Equal(t, http.StatusOK, do(router, "POST", "/api/menu", "10.0.0.1").Code)
+	}
+	rec := do(router, "POST", "/api/menu", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "4", rec.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestBucketsPerClientAndRoute(t *testing.T) {
+	t.Parallel()
+
+	router, _, _ := setup()
+
+	do(router, "POST", "/api/orders", "10.0.0.1")
+	do(router, "POST", "/api/orders", "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+
+	assert.Equal(t, http.StatusOK, do(router, "POST", "/api/orders", "10.0.0.2").Code, "another client")
+	assert.Equal(t, http.StatusOK, do(router, "POST", "/api/menu", "10.0.0.1").Code, "another route")
+
+	// Every order ID shares the /api/orders/{id} bucket.
+	for i := 1; i <= 4; i++ {
+		require.Equal(t, http.StatusOK, do(router, "GET", fmt.Sprintf("/api/orders/%d", i), "10.0.0.1").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(router, "GET", "/api/orders/5", "10.0.0.1").Code)
+}
+
+func TestAuthenticatedUsersHaveTheirOwnBucket(t *testing.T) {
+	t.Parallel()
+
+	router, limiter, _ := setup()
+	limiter.User = func(r *http.Request) string { return r.Header.Get("X-Test-User") }
+
+	post := func(user string) int {
+		req := httptest.NewRequest("POST", "/api/orders", nil)
+		req.RemoteAddr = "10.0.0.1:51234"
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Two users behind the same NAT don't use up each other's quota, or the
+	// anonymous one.
+	assert.Equal(t, http.StatusOK, post("alice"))
+	assert.Equal(t, http.StatusOK, post("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, post("alice"))
+	assert.Equal(t, http.StatusOK, post("bob"))
+	assert.Equal(t, http.StatusOK, post(""))
+}
+
+func TestZeroRateDisablesLimit(t *testing.T) {
+	t.Parallel()
+
+	router, limiter, _ := setup()
+	limiter.Read = Limit{}
+
+	for i := 0; i < 100; i++ {
+		rec := do(router, "GET", "/api/orders", "10.0.0.1")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func TestStoreFailureLetsRequestsThrough(t *testing.T) {
+	t.Parallel()
+
+	router, limiter, _ := setup()
+	limiter.Store = failingStore{}
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, do(router, "POST", "/api/orders", "10.0.0.1").Code)
+	}
+}
+
+func TestMemoryStoreForgetsFullBuckets(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	limit := Limit{Rate: 1, Burst: 5}
+
+	for i := 0; i < sweepEvery-1; i++ {
+		_, err := store.Take(context.Background(), fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256), limit, now)
+		require.NoError(t, err)
+	}
+	require.Len(t, store.buckets, sweepEvery-1)
+
+	// Four seconds later each bucket is back to 5 tokens; the next sweep
+	// drops them all except the one just used.
+	_, err := store.Take(context.Background(), "ip:10.0.0.0", limit, now.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+}
@@ -0,0 +1,109 @@
+package rpcclient
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	// ignored calls, such as ones the caller cancelled, say nothing about
+	// the upstream's health.
+	ignored
+)
+
+// breaker is a consecutive-failure circuit breaker. When open it rejects
+// calls until OpenTimeout has passed, then admits one probe: success closes
+// it, failure opens it again.
+type breaker struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *breaker) allow() bool {
+	if b.policy.FailureThreshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) record(o outcome) {
+	if b.policy.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch o {
+	case success:
+		b.state = Closed
+		b.failures = 0
+		b.probing = false
+	case failure:
+		b.failures++
+		if b.state == HalfOpen || b.failures >= b.policy.FailureThreshold {
+			b.state = Open
+			b.openedAt = b.now()
+			b.probing = false
+		}
+	case ignored:
+		b.probing = false
+	}
+}
+
+func (b *breaker) current() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,44 @@
+package rpcclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stateDesc = prometheus.NewDesc(
+		"rpcclient_circuit_breaker_state",
+		"Circuit breaker state per upstream (0 closed, 1 half-open, 2 open).",
+		[]string{"upstream"}, nil,
+	)
+	retriesDesc = prometheus.NewDesc(
+		"rpcclient_retries_total",
+		"Calls retried after an Unavailable error.",
+		[]string{"upstream"}, nil,
+	)
+	rejectionsDesc = prometheus.NewDesc(
+		"rpcclient_circuit_breaker_rejections_total",
+		"Calls failed fast because the breaker was open.",
+		[]string{"upstream"}, nil,
+	)
+)
+
+// Collector exports the upstreams' breaker state, retries and rejections.
+func Collector(upstreams ...*Upstream) prometheus.Collector {
+	return collector(upstreams)
+}
+
+type collector []*Upstream
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateDesc
+	ch <- retriesDesc
+	ch <- rejectionsDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, u := range c {
+		ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, float64(u.State()), u.name)
+		ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(u.retries.Load()), u.name)
+		ch <- prometheus.MustNewConstMetric(rejectionsDesc, prometheus.CounterValue, float64(u.rejections.Load()), u.name)
+	}
+}
@@ -0,0 +1,179 @@
+// Package rpcclient makes outgoing gRPC calls to an upstream service
+// resilient. Each Upstream adds a per-call deadline, retries idempotent
+// reads that fail with Unavailable, and guards the upstream with a circuit
+// breaker so a failing service is not hammered while it recovers.
+package rpcclient
+
+import (
+	"context"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// Timeout bounds a whole call, retries included, unless the caller's
+	// context has an earlier deadline. Zero means no deadline.
+	Timeout time.Duration
+	Retry   RetryPolicy
+	Breaker BreakerPolicy
+	// Idempotent reports whether a method is safe to retry. Defaults to
+	// IsRead.
+	Idempotent func(fullMethod string) bool
+	// Now is the breaker's clock; defaults to time.Now.
+	Now func() time.Time
+}
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first call. Values below 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker. Zero disables it.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting a single
+	// probe call through.
+	OpenTimeout time.Duration
+}
+
+// DefaultConfig suits calls between the practical-six services.
+func DefaultConfig() Config {
+	return Config{
+		Timeout: 3 * time.Second,
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 50 * time.Millisecond,
+			MaxBackoff:     time.Second,
+		},
+		Breaker: BreakerPolicy{
+			FailureThreshold: 5,
+			OpenTimeout:      10 * time.Second,
+		},
+	}
+}
+
+// IsRead reports whether fullMethod ("/pkg.Service/Method") is a Get or List
+// call. Those are the only RPCs in practical-six without side effects.
+func IsRead(fullMethod string) bool {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	return strings.HasPrefix(method, "Get") || strings.HasPrefix(method, "List")
+}
+
+// Upstream holds the retry policy and circuit breaker for one service.
+type Upstream struct {
+	name    string
+	cfg     Config
+	breaker *breaker
+
+	retries    atomic.Int64
+	rejections atomic.Int64
+}
+
+func New(name string, cfg Config) *Upstream {
+	if cfg.Idempotent == nil {
+		cfg.Idempotent = IsRead
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Upstream{
+		name:    name,
+		cfg:     cfg,
+		breaker: &breaker{policy: cfg.Breaker, now: cfg.Now},
+	}
+}
+
+func (u *Upstream) Name() string { return u.name }
+
+// State returns the circuit breaker's current state.
+func (u *Upstream) State() State { return u.breaker.current() }
+
+// DialOption installs the upstream's interceptor on a connection.
+func (u *Upstream) DialOption() grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(u.UnaryInterceptor())
+}
+
+func (u *Upstream) UnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if u.cfg.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, u.cfg.Timeout)
+			defer cancel()
+		}
+
+		attempts := 1
+		if u.cfg.Idempotent(method) && u.cfg.Retry.MaxAttempts > 1 {
+			attempts = u.cfg.Retry.MaxAttempts
+		}
+
+		var err error
+		for attempt := 0; attempt < attempts; attempt++ {
+			if attempt > 0 {
+				if !sleep(ctx, u.backoff(attempt)) {
+					return err
+				}
+				u.retries.Add(1)
+			}
+			if !u.breaker.allow() {
+				u.rejections.Add(1)
+				return status.Errorf(codes.Unavailable, "%s: circuit breaker open", u.name)
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			u.breaker.record(outcomeOf(err))
+			if status.Code(err) != codes.Unavailable {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+// backoff returns a random delay in [0, min(MaxBackoff, InitialBackoff*2^(attempt-1))]
+// ("full jitter"), which spreads out retries from many callers.
+func (u *Upstream) backoff(attempt int) time.Duration {
+	ceiling := u.cfg.Retry.InitialBackoff << (attempt - 1)
+	if u.cfg.Retry.MaxBackoff > 0 && (ceiling > u.cfg.Retry.MaxBackoff || ceiling <= 0) {
+		ceiling = u.cfg.Retry.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// sleep waits for d, returning false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// outcomeOf classifies a call for the breaker. Only errors that point at the
+// upstream itself count as failures; a NotFound is a healthy answer.
+func outcomeOf(err error) outcome {
+	switch status.Code(err) {
+	case codes.OK:
+		return success
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return failure
+	case codes.Canceled:
+		return ignored
+	default:
+		return success
+	}
+}
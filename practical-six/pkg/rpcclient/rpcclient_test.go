@@ -0,0 +1,261 @@
+package rpcclient
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// faultyServer is a health server whose Check fails or stalls on demand.
+type faultyServer struct {
+	healthpb.UnimplementedHealthServer
+
+	mu    sync.Mutex
+	fail  []codes.Code // codes to return, one per call, before succeeding
+	delay time.Duration
+	calls atomic.Int32
+}
+
+func (s *faultyServer) inject(delay time.Duration, fail ...codes.Code) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+	s.fail = fail
+}
+
+func (s *faultyServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.calls.Add(1)
+
+	s.mu.Lock()
+	delay := s.delay
+	var code codes.Code
+	if len(s.fail) > 0 {
+		code, s.fail = s.fail[0], s.fail[1:]
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if code != codes.OK {
+		return nil, status.Error(code, "injected fault")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func testConfig(clock *fakeClock) Config {
+	return Config{
+		Timeout: time.Second,
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+		},
+		Breaker: BreakerPolicy{
+			FailureThreshold: 3,
+			OpenTimeout:      10 * time.Second,
+		},
+		Idempotent: func(method string) bool { return method == healthpb.Health_Check_FullMethodName },
+		Now:        clock.Now,
+	}
+}
+
+// setup serves a faultyServer over bufconn and returns a client that goes
+// through an Upstream built from cfg.
+func setup(t *testing.T, cfg Config) (*faultyServer, *Upstream, healthpb.HealthClient) {
+	lis := bufconn.Listen(1024 * 1024)
+	server := &faultyServer{}
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	upstream := New("test-service", cfg)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		upstream.DialOption())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return server, upstream, healthpb.NewHealthClient(conn)
+}
+
+func check(client healthpb.HealthClient) error {
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestRetriesUnavailableReads(t *testing.T) {
+	t.Parallel()
+
+	server, upstream, client := setup(t, testConfig(&fakeClock{}))
+	server.inject(0, codes.Unavailable, codes.Unavailable)
+
+	require.NoError(t, check(client))
+	assert.EqualValues(t, 3, server.calls.Load())
+	assert.EqualValues(t, 2, upstream.retries.Load())
+	assert.Equal(t, Closed, upstream.State())
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(&fakeClock{})
+	cfg.Breaker.FailureThreshold = 0
+	server, _, client := setup(t, cfg)
+	server.inject(0, codes.Unavailable, codes.Unavailable, codes.Unavailable, codes.Unavailable)
+
+	assert.Equal(t, codes.Unavailable, status.Code(check(client)))
+	assert.EqualValues(t, 3, server.calls.Load())
+}
+
+func TestDoesNotRetryOtherCodes(t *testing.T) {
+	t.Parallel()
+
+	server, _, client := setup(t, testConfig(&fakeClock{}))
+	server.inject(0, codes.Internal)
+
+	assert.Equal(t, codes.Internal, status.Code(check(client)))
+	assert.EqualValues(t, 1, server.calls.Load())
+}
+
+func TestDoesNotRetryNonIdempotentMethods(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(&fakeClock{})
+	cfg.Idempotent = func(string) bool { return false }
+	server, _, client := setup(t, cfg)
+	server.inject(0, codes.Unavailable)
+
+	assert.Equal(t, codes.Unavailable, status.Code(check(client)))
+	assert.EqualValues(t, 1, server.calls.Load())
+}
+
+func TestDeadlineCutsOffSlowUpstream(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(&fakeClock{})
+	cfg.Timeout = 50 * time.Millisecond
+	server, _, client := setup(t, cfg)
+	server.inject(time.Minute)
+
+	start := time.Now()
+	err := check(client)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cfg := testConfig(clock)
+	cfg.Retry.MaxAttempts = 1
+	server, upstream, client := setup(t, cfg)
+
+	// Business errors do not count against the upstream.
+	server.inject(0, codes.NotFound, codes.NotFound, codes.NotFound)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, codes.NotFound, status.Code(check(client)))
+	}
+	assert.Equal(t, Closed, upstream.State())
+
+	server.inject(0, codes.Unavailable, codes.Unavailable, codes.Unavailable)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, codes.Unavailable, status.Code(check(client)))
+	}
+	assert.Equal(t, Open, upstream.State())
+
+	// While open, calls fail fast without reaching the server.
+	calls := server.calls.Load()
+	err := check(client)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "circuit breaker open")
+	assert.Equal(t, calls, server.calls.Load())
+	assert.EqualValues(t, 1, upstream.rejections.Load())
+
+	// After the timeout a failed probe opens it again...
+	clock.Advance(10 * time.Second)
+	server.inject(0, codes.Unavailable)
+	assert.Equal(t, codes.Unavailable, status.Code(check(client)))
+	assert.Equal(t, Open, upstream.State())
+	assert.Contains(t, check(client).Error(), "circuit breaker open")
+
+	// ...and a successful one closes it.
+	clock.Advance(10 * time.Second)
+	require.NoError(t, check(client))
+	assert.Equal(t, Closed, upstream.State())
+	require.NoError(t, check(client))
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(&fakeClock{})
+	cfg.Retry.MaxAttempts = 1
+	cfg.Breaker.FailureThreshold = 1
+	server, upstream, client := setup(t, cfg)
+	healthy := New("healthy-service", cfg)
+
+	server.inject(0, codes.Unavailable)
+	check(client)
+	check(client)
+
+	expected := `
+# HELP rpcclient_circuit_breaker_rejections_total Calls failed fast because the breaker was open.
+# TYPE rpcclient_circuit_breaker_rejections_total counter
+rpcclient_circuit_breaker_rejections_total{upstream="healthy-service"} 0
+rpcclient_circuit_breaker_rejections_total{upstream="test-service"} 1
+# HELP rpcclient_circuit_breaker_state Circuit breaker state per upstream (0 closed, 1 half-open, 2 open).
+# TYPE rpcclient_circuit_breaker_state gauge
+rpcclient_circuit_breaker_state{upstream="healthy-service"} 0
+rpcclient_circuit_breaker_state{upstream="test-service"} 2
+`
+	require.NoError(t, testutil.CollectAndCompare(Collector(upstream, healthy), strings.NewReader(expected),
+		"rpcclient_circuit_breaker_state", "rpcclient_circuit_breaker_rejections_total"))
+}
+
+func TestIsRead(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsRead("/menu.v1.MenuService/GetMenuItem"))
+	assert.True(t, IsRead("/menu.v1.MenuService/GetMenuItems"))
+	assert.False(t, IsRead("/order.v1.OrderService/CreateOrder"))
+	assert.False(t, IsRead("/menu.v1.MenuService/SetAvailability"))
+}
@@ -0,0 +1,83 @@
+// Package serve runs gRPC and HTTP servers until a context is cancelled,
+// then drains in-flight requests before returning.
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// GRPC serves s on lis until ctx is done. It then stops accepting new RPCs
+// and waits up to drainTimeout for running ones to finish before cancelling
+// them.
+func GRPC(ctx context.Context, s *grpc.Server, lis net.Listener, drainTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(lis) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down gRPC server", "drain_timeout", drainTimeout.String())
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		slog.Warn("Drain timeout exceeded, cancelling remaining RPCs")
+		s.Stop()
+		<-stopped
+	}
+	return <-errCh
+}
+
+// HTTP serves srv until ctx is done, then shuts it down, giving in-flight
+// requests up to drainTimeout to complete.
+func HTTP(ctx context.Context, srv *http.Server, drainTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down HTTP server", "addr", srv.Addr, "drain_timeout", drainTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("Drain timeout exceeded, closing remaining connections", "addr", srv.Addr)
+		err = srv.Close()
+	}
+	if serveErr := <-errCh; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
+
+// AdminServer returns the server for a binary's operational endpoints, such
+// as /metrics, which listens on its own port apart from the API.
+func AdminServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// ServerOption starts a span for every RPC a server handles, continuing the
+// caller's trace.
+func ServerOption(tp trace.TracerProvider) grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(tp),
+		otelgrpc.WithPropagators(Propagator),
+	))
+}
+
+// DialOption starts a client span for every call on a connection and sends
+// its context to the server.
+func DialOption(tp trace.TracerProvider) grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+		otelgrpc.WithTracerProvider(tp),
+		otelgrpc.WithPropagators(Propagator),
+	))
+}
+
+// Middleware starts a span named after the request's gorilla/mux route
+// template, e.g. "POST /api/orders". Install it with Router.Use.
+func Middleware(tp trace.TracerProvider) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, "",
+			otelhttp.WithTracerProvider(tp),
+			otelhttp.WithPropagators(Propagator),
+			otelhttp.WithSpanNameFormatter(spanName),
+		)
+	}
+}
+
+func spanName(_ string, r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + tmpl
+		}
+	}
+	return r.Method
+}
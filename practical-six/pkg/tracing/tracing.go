@@ -0,0 +1,75 @@
+// Package tracing sets up OpenTelemetry for a practical-six binary and
+// instruments its HTTP routes, gRPC servers and clients, and GORM queries.
+// Trace context crosses service boundaries as W3C traceparent headers, in
+// HTTP requests and in gRPC metadata alike.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Propagator carries trace context and baggage between services.
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+type Config struct {
+	// Exporter is "otlp", "stdout" or "none".
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"where to send spans: otlp, stdout or none"`
+	// OTLPEndpoint is the collector's OTLP/gRPC host:port.
+	OTLPEndpoint string  `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"OTLP/gRPC collector host:port"`
+	SampleRatio  float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"fraction of new traces to record"`
+}
+
+// DefaultConfig records every trace but exports none of them.
+func DefaultConfig() Config {
+	return Config{
+		Exporter:     "none",
+		OTLPEndpoint: "localhost:4317",
+		SampleRatio:  1,
+	}
+}
+
+// Setup installs a global tracer provider for service that exports spans as
+// cfg says, and the W3C propagator. The returned function flushes pending
+// spans and must be called before the process exits.
+func Setup(ctx context.Context, service string, cfg Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "none", "":
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q (want otlp, stdout or none)", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+func spanNamed(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	require.Failf(t, "span not recorded", "no span named %q", name)
+	return nil
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig()
+	cfg.Exporter = "jaeger"
+	_, err := Setup(context.Background(), "test-service", cfg)
+	assert.ErrorContains(t, err, `unknown tracing exporter "jaeger"`)
+}
+
+func TestGRPCPropagatesTraceContext(t *testing.T) {
+	t.Parallel()
+
+	tp, recorder := newProvider()
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(ServerOption(tp))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		DialOption(tp),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	parent.End()
+
+	require.Eventually(t, func() bool { return len(recorder.Ended()) == 3 }, time.Second, 10*time.Millisecond)
+	var client, server sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch s.SpanKind() {
+		case trace.SpanKindClient:
+			client = s
+		case trace.SpanKindServer:
+			server = s
+		}
+	}
+	require.NotNil(t, client)
+	require.NotNil(t, server)
+	assert.Equal(t, "grpc.health.v1.Health/Check", client.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), client.Parent().SpanID())
+	assert.Equal(t, client.SpanContext().SpanID(), server.Parent().SpanID())
+	assert.True(t, server.Parent().IsRemote())
+	assert.Equal(t, parent.SpanContext().TraceID(), server.SpanContext().TraceID())
+}
+
+func TestMiddlewareNamesSpansAfterRoute(t *testing.T) {
+	t.Parallel()
+
+	tp, recorder := newProvider()
+	router := mux.NewRouter()
+	router.Use(Middleware(tp))
+	router.HandleFunc("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, trace.SpanContextFromContext(r.Context()).IsValid())
+	}).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/api/users/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /api/users/{id}", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+func TestGORMRecordsQueries(t *testing.T) {
+	t.Parallel()
+
+	type Item struct {
+		ID   uint
+		Name string
+	}
+
+	tp, recorder := newProvider()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Item{}))
+	require.NoError(t, db.Use(GORM(tp)))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	require.NoError(t, db.WithContext(ctx).Create(&Item{Name: "Coffee"}).Error)
+	var item Item
+	err = db.WithContext(ctx).First(&item, 42).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	parent.End()
+
+	spans := recorder.Ended()
+	create := spanNamed(t, spans, "gorm.create")
+	assert.Equal(t, parent.SpanContext().SpanID(), create.Parent().SpanID())
+	assert.Contains(t, attributes(create)["db.query.text"], "INSERT INTO `items`")
+	assert.Equal(t, "items", attributes(create)["db.collection.name"])
+
+	query := spanNamed(t, spans, "gorm.query")
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, "sqlite", attributes(query)["db.system"])
+	assert.Equal(t, "Unset", query.Status().Code.String(), "a missing row is not an error")
+}
+
+func attributes(s sdktrace.ReadOnlySpan) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
@@ -0,0 +1,8 @@
+// Package openapi embeds the OpenAPI v2 spec that protoc-gen-openapiv2
+// generates from the services' google.api.http annotations.
+package openapi
+
+import _ "embed"
+
+//go:embed api.swagger.json
+var Spec []byte
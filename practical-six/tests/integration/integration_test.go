@@ -2,100 +2,108 @@ package integration
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	menudatabase "github.com/practical6/menu-service/database"
 	menugrpc "github.com/practical6/menu-service/grpc"
 	menumodels "github.com/practical6/menu-service/models"
-	orderdatabase "github.com/practical6/order-service/database"
+	menurepository "github.com/practical6/menu-service/repository"
 	ordergrpc "github.com/practical6/order-service/grpc"
 	ordermodels "github.com/practical6/order-service/models"
+	orderrepository "github.com/practical6/order-service/repository"
+	"github.com/practical6/pkg/lb"
+	"github.com/practical6/pkg/rpcclient"
+	"github.com/practical6/pkg/tracing"
 	menuv1 "github.com/practical6/proto/menu/v1"
 	orderv1 "github.com/practical6/proto/order/v1"
 	userv1 "github.com/practical6/proto/user/v1"
-	userdatabase "github.com/practical6/user-service/database"
 	usergrpc "github.com/practical6/user-service/grpc"
 	usermodels "github.com/practical6/user-service/models"
+	userrepository "github.com/practical6/user-service/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/test/bufconn"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-const bufSize = 1024 * 1024
-
-var (
-	userListener  *bufconn.Listener
-	menuListener  *bufconn.Listener
-	orderListener *bufconn.Listener
+const (
+	bufSize = 1024 * 1024
+	cafeID  = 1
 )
 
-func setupUserService(t *testing.T) {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	require.NoError(t, err)
+// untraced is the tracer provider for tests that don't look at spans.
+var untraced = noop.NewTracerProvider()
 
-	err = db.AutoMigrate(&usermodels.User{})
+// openDB opens a private in-memory SQLite database for the calling test, so
+// tests can run in parallel without sharing tables.
+func openDB(t *testing.T, tp trace.TracerProvider, name string, models ...interface{}) *gorm.DB {
+	test := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s_%s?mode=memory&cache=shared", test, name)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
 	require.NoError(t, err)
 
-	userdatabase.DB = db
+	err = db.AutoMigrate(models...)
+	require.NoError(t, err)
+	require.NoError(t, db.Use(tracing.GORM(tp)))
 
-	userListener = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
-	userv1.RegisterUserServiceServer(s, usergrpc.NewUserServer())
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// SQLite shared-cache databases reject concurrent writers.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
 
+// serve starts s on an in-memory listener and stops it when the test ends.
+func serve(t *testing.T, s *grpc.Server) *bufconn.Listener {
+	listener := bufconn.Listen(bufSize)
 	go func() {
-		if err := s.Serve(userListener); err != nil {
-			log.Fatalf("Server exited: %v", err)
+		if err := s.Serve(listener); err != nil {
+			t.Errorf("Server exited: %v", err)
 		}
 	}()
+	t.Cleanup(s.Stop)
+	return listener
 }
 
-func setupMenuService(t *testing.T) {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	require.NoError(t, err)
-
-	err = db.AutoMigrate(&menumodels.MenuItem{})
-	require.NoError(t, err)
-
-	menudatabase.DB = db
+func setupUserService(t *testing.T, tp trace.TracerProvider) *bufconn.Listener {
+	db := openDB(t, tp, "users", &usermodels.User{}, &usermodels.Cafe{})
 
-	menuListener = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
-	menuv1.RegisterMenuServiceServer(s, menugrpc.NewMenuServer())
-
-	go func() {
-		if err := s.Serve(menuListener); err != nil {
-			log.Fatalf("Server exited: %v", err)
-		}
-	}()
+	s := grpc.NewServer(tracing.ServerOption(tp))
+	userv1.RegisterUserServiceServer(s, usergrpc.NewUserServer(userrepository.NewGormUserRepository(db)))
+	return serve(t, s)
 }
 
-func setupOrderService(t *testing.T, userConn, menuConn *grpc.ClientConn) {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	require.NoError(t, err)
+func setupMenuService(t *testing.T, tp trace.TracerProvider) *bufconn.Listener {
+	db := openDB(t, tp, "menu", &menumodels.MenuItem{}, &menumodels.AvailabilityWindow{}, &menumodels.PriceChange{})
 
-	err = db.AutoMigrate(&ordermodels.Order{}, &ordermodels.OrderItem{})
-	require.NoError(t, err)
+	s := grpc.NewServer(tracing.ServerOption(tp))
+	menuv1.RegisterMenuServiceServer(s, menugrpc.NewMenuServer(menurepository.NewGormMenuRepository(db)))
+	return serve(t, s)
+}
 
-	orderdatabase.DB = db
+func setupOrderService(t *testing.T, tp trace.TracerProvider, userConn, menuConn *grpc.ClientConn) *bufconn.Listener {
+	db := openDB(t, tp, "orders", &ordermodels.Order{}, &ordermodels.OrderItem{})
 
 	userClient := userv1.NewUserServiceClient(userConn)
 	menuClient := menuv1.NewMenuServiceClient(menuConn)
 
-	orderListener = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
-	orderv1.RegisterOrderServiceServer(s, ordergrpc.NewOrderServer(userClient, menuClient))
-
-	go func() {
-		if err := s.Serve(orderListener); err != nil {
-			log.Fatalf("Server exited: %v", err)
-		}
-	}()
+	s := grpc.NewServer(tracing.ServerOption(tp))
+	orderv1.RegisterOrderServiceServer(s, ordergrpc.NewOrderServer(orderrepository.NewGormOrderRepository(db), userClient, menuClient))
+	return serve(t, s)
 }
 
 func bufDialer(listener *bufconn.Listener) func(context.Context, string) (net.Conn, error) {
@@ -105,8 +113,9 @@ func bufDialer(listener *bufconn.Listener) func(context.Context, string) (net.Co
 }
 
 func TestIntegration_CreateUser(t *testing.T) {
-	setupUserService(t)
-	defer userListener.Close()
+	t.Parallel()
+
+	userListener := setupUserService(t, untraced)
 
 	ctx := context.Background()
 
@@ -130,11 +139,11 @@ func TestIntegration_CreateUser(t *testing.T) {
 }
 
 func TestIntegration_CompleteOrderFlow(t *testing.T) {
-	setupUserService(t)
-	defer userListener.Close()
+	t.Parallel()
 
-	setupMenuService(t)
-	defer menuListener.Close()
+	userListener := setupUserService(t, untraced)
+
+	menuListener := setupMenuService(t, untraced)
 
 	ctx := context.Background()
 
@@ -157,8 +166,7 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 	menuClient := menuv1.NewMenuServiceClient(menuConn)
 
 	// Setup order service with connections
-	setupOrderService(t, userConn, menuConn)
-	defer orderListener.Close()
+	orderListener := setupOrderService(t, untraced, userConn, menuConn)
 
 	orderConn, err := grpc.DialContext(ctx, "bufnet",
 		grpc.WithContextDialer(bufDialer(orderListener)),
@@ -179,6 +187,7 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 
 	// Step 2: Create menu items
 	item1, err := menuClient.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId:      cafeID,
 		Name:        "Coffee",
 		Description: "Hot coffee",
 		Price:       2.50,
@@ -186,6 +195,7 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 	require.NoError(t, err)
 
 	item2, err := menuClient.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId:      cafeID,
 		Name:        "Sandwich",
 		Description: "Ham sandwich",
 		Price:       5.00,
@@ -194,6 +204,7 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 
 	// Step 3: Create an order
 	orderResp, err := orderClient.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: cafeID,
 		UserId: userID,
 		Items: []*orderv1.OrderItemRequest{
 			{MenuItemId: item1.MenuItem.Id, Quantity: 2},
@@ -213,7 +224,8 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 
 	// Step 4: Retrieve the order
 	getOrderResp, err := orderClient.GetOrder(ctx, &orderv1.GetOrderRequest{
-		Id: orderResp.Order.Id,
+		CafeId: cafeID,
+		Id:     orderResp.Order.Id,
 	})
 
 	require.NoError(t, err)
@@ -222,11 +234,11 @@ func TestIntegration_CompleteOrderFlow(t *testing.T) {
 }
 
 func TestIntegration_OrderValidation(t *testing.T) {
-	setupUserService(t)
-	defer userListener.Close()
+	t.Parallel()
+
+	userListener := setupUserService(t, untraced)
 
-	setupMenuService(t)
-	defer menuListener.Close()
+	menuListener := setupMenuService(t, untraced)
 
 	ctx := context.Background()
 
@@ -244,8 +256,7 @@ func TestIntegration_OrderValidation(t *testing.T) {
 	require.NoError(t, err)
 	defer menuConn.Close()
 
-	setupOrderService(t, userConn, menuConn)
-	defer orderListener.Close()
+	orderListener := setupOrderService(t, untraced, userConn, menuConn)
 
 	orderConn, err := grpc.DialContext(ctx, "bufnet",
 		grpc.WithContextDialer(bufDialer(orderListener)),
@@ -258,6 +269,7 @@ func TestIntegration_OrderValidation(t *testing.T) {
 	// Try to create order with invalid user
 	t.Run("invalid user", func(t *testing.T) {
 		_, err := orderClient.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+			CafeId: cafeID,
 			UserId: 9999,
 			Items: []*orderv1.OrderItemRequest{
 				{MenuItemId: 1, Quantity: 1},
@@ -277,6 +289,7 @@ func TestIntegration_OrderValidation(t *testing.T) {
 	// Try to create order with invalid menu item
 	t.Run("invalid menu item", func(t *testing.T) {
 		_, err := orderClient.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+			CafeId: cafeID,
 			UserId: userResp.User.Id,
 			Items: []*orderv1.OrderItemRequest{
 				{MenuItemId: 9999, Quantity: 1},
@@ -289,11 +302,11 @@ func TestIntegration_OrderValidation(t *testing.T) {
 }
 
 func TestIntegration_ConcurrentOrders(t *testing.T) {
-	setupUserService(t)
-	defer userListener.Close()
+	t.Parallel()
 
-	setupMenuService(t)
-	defer menuListener.Close()
+	userListener := setupUserService(t, untraced)
+
+	menuListener := setupMenuService(t, untraced)
 
 	ctx := context.Background()
 
@@ -313,8 +326,7 @@ func TestIntegration_ConcurrentOrders(t *testing.T) {
 
 	menuClient := menuv1.NewMenuServiceClient(menuConn)
 
-	setupOrderService(t, userConn, menuConn)
-	defer orderListener.Close()
+	orderListener := setupOrderService(t, untraced, userConn, menuConn)
 
 	orderConn, err := grpc.DialContext(ctx, "bufnet",
 		grpc.WithContextDialer(bufDialer(orderListener)),
@@ -332,7 +344,8 @@ func TestIntegration_ConcurrentOrders(t *testing.T) {
 	userID := userResp.User.Id
 
 	itemResp, err := menuClient.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
-		Name: "Test Item", Price: 10.00,
+		CafeId: cafeID,
+		Name:   "Test Item", Price: 10.00,
 	})
 	require.NoError(t, err)
 	itemID := itemResp.MenuItem.Id
@@ -345,6 +358,7 @@ func TestIntegration_ConcurrentOrders(t *testing.T) {
 	for i := 0; i < numOrders; i++ {
 		go func() {
 			resp, err := orderClient.CreateOrder(ctx, &orderv1.CreateOrderRequest{
+				CafeId: cafeID,
 				UserId: userID,
 				Items: []*orderv1.OrderItemRequest{
 					{MenuItemId: itemID, Quantity: 1},
@@ -364,7 +378,193 @@ func TestIntegration_ConcurrentOrders(t *testing.T) {
 	}
 
 	// Verify all orders were created
-	ordersResp, err := orderClient.GetOrders(ctx, &orderv1.GetOrdersRequest{})
+	ordersResp, err := orderClient.GetOrders(ctx, &orderv1.GetOrdersRequest{CafeId: cafeID})
 	require.NoError(t, err)
 	assert.Len(t, ordersResp.Orders, numOrders)
 }
+
+func TestIntegration_CreateOrderTrace(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	userListener := setupUserService(t, tp)
+	menuListener := setupMenuService(t, tp)
+
+	ctx := context.Background()
+
+	dial := func(listener *bufconn.Listener) *grpc.ClientConn {
+		conn, err := grpc.DialContext(ctx, "bufnet",
+			grpc.WithContextDialer(bufDialer(listener)),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			tracing.DialOption(tp))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	userConn := dial(userListener)
+	menuConn := dial(menuListener)
+	orderConn := dial(setupOrderService(t, tp, userConn, menuConn))
+
+	userResp, err := userv1.NewUserServiceClient(userConn).CreateUser(ctx, &userv1.CreateUserRequest{
+		Name: "Traced User", Email: "traced@test.com",
+	})
+	require.NoError(t, err)
+	itemResp, err := menuv1.NewMenuServiceClient(menuConn).CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{
+		CafeId: cafeID, Name: "Coffee", Price: 2.50,
+	})
+	require.NoError(t, err)
+	exporter.Reset()
+
+	ctx, root := tp.Tracer("integration").Start(ctx, "place order")
+	_, err = orderv1.NewOrderServiceClient(orderConn).CreateOrder(ctx, &orderv1.CreateOrderRequest{
+		CafeId: cafeID,
+		UserId: userResp.User.Id,
+		Items:  []*orderv1.OrderItemRequest{{MenuItemId: itemResp.MenuItem.Id, Quantity: 1}},
+	})
+	require.NoError(t, err)
+	root.End()
+
+	// Server spans end after the response is sent, so wait for the last one.
+	var spans tracetest.SpanStubs
+	require.Eventually(t, func() bool {
+		spans = exporter.GetSpans()
+		_, ok := findSpan(spans, root.SpanContext().SpanID(), "order.v1.OrderService/CreateOrder", trace.SpanKindClient)
+		return ok && len(spans) == countDescendants(spans, root.SpanContext().SpanID())+1
+	}, time.Second, 10*time.Millisecond)
+
+	for _, span := range spans {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext.TraceID(), "span %s is in another trace", span.Name)
+	}
+
+	// place order
+	// └── CreateOrder (client) → CreateOrder (server)
+	//     ├── GetUser (client) → GetUser (server) → gorm.query
+	//     ├── GetMenuItem (client) → GetMenuItem (server) → gorm.query
+	//     └── gorm.create
+	client := requireSpan(t, spans, root.SpanContext().SpanID(), "order.v1.OrderService/CreateOrder", trace.SpanKindClient)
+	server := requireSpan(t, spans, client.SpanContext.SpanID(), "order.v1.OrderService/CreateOrder", trace.SpanKindServer)
+
+	getUser := requireSpan(t, spans, server.SpanContext.SpanID(), "user.v1.UserService/GetUser", trace.SpanKindClient)
+	getUser = requireSpan(t, spans, getUser.SpanContext.SpanID(), "user.v1.UserService/GetUser", trace.SpanKindServer)
+	requireSpan(t, spans, getUser.SpanContext.SpanID(), "gorm.query", trace.SpanKindClient)
+
+	getItem := requireSpan(t, spans, server.SpanContext.SpanID(), "menu.v1.MenuService/GetMenuItem", trace.SpanKindClient)
+	getItem = requireSpan(t, spans, getItem.SpanContext.SpanID(), "menu.v1.MenuService/GetMenuItem", trace.SpanKindServer)
+	requireSpan(t, spans, getItem.SpanContext.SpanID(), "gorm.query", trace.SpanKindClient)
+
+	requireSpan(t, spans, server.SpanContext.SpanID(), "gorm.create", trace.SpanKindClient)
+}
+
+// findSpan returns the span with the given parent, name and kind.
+func findSpan(spans tracetest.SpanStubs, parent trace.SpanID, name string, kind trace.SpanKind) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Parent.SpanID() == parent && span.Name == name && span.SpanKind == kind {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func requireSpan(t *testing.T, spans tracetest.SpanStubs, parent trace.SpanID, name string, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+	span, ok := findSpan(spans, parent, name, kind)
+	require.Truef(t, ok, "no %s span %q under %s", kind, name, parent)
+	return span
+}
+
+// countDescendants counts the spans below parent.
+func countDescendants(spans tracetest.SpanStubs, parent trace.SpanID) int {
+	n := 0
+	for _, span := range spans {
+		if span.Parent.SpanID() == parent {
+			n += 1 + countDescendants(spans, span.SpanContext.SpanID())
+		}
+	}
+	return n
+}
+
+// menuReplica is one of several menu-service servers sharing a database.
+type menuReplica struct {
+	server   *grpc.Server
+	health   *health.Server
+	listener *bufconn.Listener
+	served   atomic.Int32
+}
+
+func setupMenuReplicas(t *testing.T, addrs ...string) map[string]*menuReplica {
+	db := openDB(t, untraced, "menu", &menumodels.MenuItem{}, &menumodels.AvailabilityWindow{}, &menumodels.PriceChange{})
+
+	replicas := make(map[string]*menuReplica, len(addrs))
+	for _, addr := range addrs {
+		r := &menuReplica{health: health.NewServer()}
+		r.server = grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			r.served.Add(1)
+			return handler(ctx, req)
+		}))
+		menuv1.RegisterMenuServiceServer(r.server, menugrpc.NewMenuServer(menurepository.NewGormMenuRepository(db)))
+		healthpb.RegisterHealthServer(r.server, r.health)
+		r.health.SetServingStatus(menuv1.MenuService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+		r.listener = serve(t, r.server)
+		replicas[addr] = r
+	}
+	return replicas
+}
+
+func TestIntegration_MenuReplicaFailover(t *testing.T) {
+	t.Parallel()
+
+	replicas := setupMenuReplicas(t, "menu-1:50052", "menu-2:50052")
+	first, second := replicas["menu-1:50052"], replicas["menu-2:50052"]
+
+	// The client is set up as the gateway's is, retries included.
+	upstream := rpcclient.New("menu-service", rpcclient.DefaultConfig())
+	conn, err := grpc.NewClient(lb.Target("menu-1:50052,menu-2:50052"),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return replicas[addr].listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		lb.DefaultConfig().DialOption(menuv1.MenuService_ServiceDesc.ServiceName),
+		upstream.DialOption(),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := menuv1.NewMenuServiceClient(conn)
+
+	ctx := context.Background()
+	item, err := client.CreateMenuItem(ctx, &menuv1.CreateMenuItemRequest{CafeId: cafeID, Name: "Coffee", Price: 2.50})
+	require.NoError(t, err)
+	get := func() error {
+		_, err := client.GetMenuItem(ctx, &menuv1.GetMenuItemRequest{CafeId: cafeID, Id: item.MenuItem.Id})
+		return err
+	}
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, get())
+		return first.served.Load() > 0 && second.served.Load() > 0
+	}, 5*time.Second, time.Millisecond, "calls are spread over both replicas")
+
+	// A replica that reports itself not serving gets no calls.
+	first.health.SetServingStatus(menuv1.MenuService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	require.Eventually(t, func() bool {
+		before := first.served.Load()
+		require.NoError(t, get())
+		require.NoError(t, get())
+		return first.served.Load() == before
+	}, 5*time.Second, time.Millisecond)
+	before := first.served.Load()
+	for range 10 {
+		require.NoError(t, get())
+	}
+	assert.Equal(t, before, first.served.Load(), "the unhealthy replica is ejected")
+	first.health.SetServingStatus(menuv1.MenuService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	// Once the second replica is gone, every call goes to the first.
+	second.server.Stop()
+	before = first.served.Load()
+	for range 10 {
+		require.NoError(t, get(), "calls caught by the shutdown are retried on the other replica")
+	}
+	assert.Equal(t, before+10, first.served.Load())
+}
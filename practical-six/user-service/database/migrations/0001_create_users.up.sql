@@ -0,0 +1,14 @@
+-- IF NOT EXISTS lets databases created by the old AutoMigrate start out
+-- with this migration recorded as applied.
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGSERIAL PRIMARY KEY,
+    created_at    TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ,
+    deleted_at    TIMESTAMPTZ,
+    name          TEXT NOT NULL,
+    email         TEXT NOT NULL,
+    is_cafe_owner BOOLEAN DEFAULT false,
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cafe_owners;
+DROP TABLE IF EXISTS cafes;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS cafes (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    name       TEXT NOT NULL,
+    location   TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_cafes_deleted_at ON cafes (deleted_at);
+
+CREATE TABLE IF NOT EXISTS cafe_owners (
+    cafe_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (cafe_id, user_id),
+    CONSTRAINT fk_cafe_owners_cafe FOREIGN KEY (cafe_id) REFERENCES cafes (id),
+    CONSTRAINT fk_cafe_owners_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
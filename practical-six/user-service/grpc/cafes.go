@@ -0,0 +1,114 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/practical6/proto/user/v1"
+	"github.com/practical6/user-service/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *UserServer) CreateCafe(ctx context.Context, req *userv1.CreateCafeRequest) (*userv1.CreateCafeResponse, error) {
+	if req.Name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "cafe name is required")
+	}
+
+	owners, err := s.loadOwners(ctx, req.OwnerIds)
+	if err != nil {
+		return nil, err
+	}
+
+	cafe := models.Cafe{
+		Name:     req.Name,
+		Location: req.Location,
+		Owners:   owners,
+	}
+
+	if err := s.repo.CreateCafe(ctx, &cafe); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create cafe: %v", err)
+	}
+
+	return &userv1.CreateCafeResponse{
+		Cafe: cafeToProto(cafe),
+	}, nil
+}
+
+func (s *UserServer) GetCafe(ctx context.Context, req *userv1.GetCafeRequest) (*userv1.GetCafeResponse, error) {
+	cafe, err := s.repo.GetCafe(ctx, uint(req.Id))
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "cafe not found")
+	}
+
+	return &userv1.GetCafeResponse{
+		Cafe: cafeToProto(*cafe),
+	}, nil
+}
+
+func (s *UserServer) GetCafes(ctx context.Context, req *userv1.GetCafesRequest) (*userv1.GetCafesResponse, error) {
+	cafes, err := s.repo.ListCafes(ctx, uint(req.OwnerId))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fetch cafes: %v", err)
+	}
+
+	var protoCafes []*userv1.Cafe
+	for _, cafe := range cafes {
+		protoCafes = append(protoCafes, cafeToProto(cafe))
+	}
+
+	return &userv1.GetCafesResponse{
+		Cafes: protoCafes,
+	}, nil
+}
+
+func (s *UserServer) AssignCafeOwner(ctx context.Context, req *userv1.AssignCafeOwnerRequest) (*userv1.AssignCafeOwnerResponse, error) {
+	if _, err := s.repo.GetCafe(ctx, uint(req.CafeId)); err != nil {
+		return nil, status.Errorf(codes.NotFound, "cafe not found")
+	}
+
+	if _, err := s.loadOwners(ctx, []uint32{req.UserId}); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.AddCafeOwner(ctx, uint(req.CafeId), uint(req.UserId)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to assign owner: %v", err)
+	}
+
+	cafe, err := s.repo.GetCafe(ctx, uint(req.CafeId))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reload cafe: %v", err)
+	}
+
+	return &userv1.AssignCafeOwnerResponse{
+		Cafe: cafeToProto(*cafe),
+	}, nil
+}
+
+// loadOwners fetches the given users and checks that each is a cafe owner.
+func (s *UserServer) loadOwners(ctx context.Context, ids []uint32) ([]models.User, error) {
+	var owners []models.User
+	for _, id := range ids {
+		user, err := s.repo.GetUser(ctx, uint(id))
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "user %d not found", id)
+		}
+		if !user.IsCafeOwner {
+			return nil, status.Errorf(codes.FailedPrecondition, "user %d is not a cafe owner", id)
+		}
+		user.Cafes = nil
+		owners = append(owners, *user)
+	}
+	return owners, nil
+}
+
+func cafeToProto(cafe models.Cafe) *userv1.Cafe {
+	protoCafe := &userv1.Cafe{
+		Id:       uint32(cafe.ID),
+		Name:     cafe.Name,
+		Location: cafe.Location,
+	}
+	for _, owner := range cafe.Owners {
+		protoCafe.OwnerIds = append(protoCafe.OwnerIds, uint32(owner.ID))
+	}
+	return protoCafe
+}
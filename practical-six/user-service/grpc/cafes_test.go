@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/practical6/proto/user/v1"
+	"github.com/practical6/user-service/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateCafe(t *testing.T) {
+	t.Parallel()
+
+	server := NewUserServer(repository.NewMemoryUserRepository())
+	ctx := context.Background()
+
+	owner, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name: "Owner", Email: "owner@cafe.com", IsCafeOwner: true,
+	})
+	require.NoError(t, err)
+
+	customer, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name: "Customer", Email: "customer@example.com",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		request      *userv1.CreateCafeRequest
+		expectedCode codes.Code
+	}{
+		{
+			name: "cafe with owner",
+			request: &userv1.CreateCafeRequest{
+				Name:     "Library Cafe",
+				Location: "Main Library",
+				OwnerIds: []uint32{owner.User.Id},
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "missing name",
+			request:      &userv1.CreateCafeRequest{Location: "Nowhere"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "owner is not a cafe owner",
+			request: &userv1.CreateCafeRequest{
+				Name:     "Science Cafe",
+				OwnerIds: []uint32{customer.User.Id},
+			},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name: "owner does not exist",
+			request: &userv1.CreateCafeRequest{
+				Name:     "Arts Cafe",
+				OwnerIds: []uint32{9999},
+			},
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.CreateCafe(ctx, tt.request)
+
+			if tt.expectedCode != codes.OK {
+				require.Error(t, err)
+				st, ok := status.FromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectedCode, st.Code())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotZero(t, resp.Cafe.Id)
+			assert.Equal(t, tt.request.Name, resp.Cafe.Name)
+			assert.Equal(t, tt.request.OwnerIds, resp.Cafe.OwnerIds)
+		})
+	}
+}
+
+func TestCafeOwnership(t *testing.T) {
+	t.Parallel()
+
+	server := NewUserServer(repository.NewMemoryUserRepository())
+	ctx := context.Background()
+
+	alice, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name: "Alice", Email: "alice@cafe.com", IsCafeOwner: true,
+	})
+	require.NoError(t, err)
+
+	bob, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name: "Bob", Email: "bob@cafe.com", IsCafeOwner: true,
+	})
+	require.NoError(t, err)
+
+	library, err := server.CreateCafe(ctx, &userv1.CreateCafeRequest{
+		Name: "Library Cafe", OwnerIds: []uint32{alice.User.Id},
+	})
+	require.NoError(t, err)
+
+	gym, err := server.CreateCafe(ctx, &userv1.CreateCafeRequest{
+		Name: "Gym Cafe", OwnerIds: []uint32{bob.User.Id},
+	})
+	require.NoError(t, err)
+
+	_, err = server.CreateCafe(ctx, &userv1.CreateCafeRequest{Name: "Unowned Cafe"})
+	require.NoError(t, err)
+
+	assigned, err := server.AssignCafeOwner(ctx, &userv1.AssignCafeOwnerRequest{
+		CafeId: gym.Cafe.Id,
+		UserId: alice.User.Id,
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{alice.User.Id, bob.User.Id}, assigned.Cafe.OwnerIds)
+
+	userResp, err := server.GetUser(ctx, &userv1.GetUserRequest{Id: alice.User.Id})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{library.Cafe.Id, gym.Cafe.Id}, userResp.User.ManagedCafeIds)
+
+	cafesResp, err := server.GetCafes(ctx, &userv1.GetCafesRequest{OwnerId: bob.User.Id})
+	require.NoError(t, err)
+	require.Len(t, cafesResp.Cafes, 1)
+	assert.Equal(t, "Gym Cafe", cafesResp.Cafes[0].Name)
+
+	allResp, err := server.GetCafes(ctx, &userv1.GetCafesRequest{})
+	require.NoError(t, err)
+	assert.Len(t, allResp.Cafes, 3)
+
+	_, err = server.GetCafe(ctx, &userv1.GetCafeRequest{Id: 9999})
+	st, _ := status.FromError(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+
+	_, err = server.AssignCafeOwner(ctx, &userv1.AssignCafeOwnerRequest{CafeId: 9999, UserId: alice.User.Id})
+	st, _ = status.FromError(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+}
@@ -2,20 +2,22 @@ package grpc
 
 import (
 	"context"
+	"errors"
 
 	"github.com/practical6/proto/user/v1"
-	"github.com/practical6/user-service/database"
 	"github.com/practical6/user-service/models"
+	"github.com/practical6/user-service/repository"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type UserServer struct {
 	userv1.UnimplementedUserServiceServer
+	repo repository.UserRepository
 }
 
-func NewUserServer() *UserServer {
-	return &UserServer{}
+func NewUserServer(repo repository.UserRepository) *UserServer {
+	return &UserServer{repo: repo}
 }
 
 func (s *UserServer) CreateUser(ctx context.Context, req *userv1.CreateUserRequest) (*userv1.CreateUserResponse, error) {
@@ -25,56 +27,60 @@ func (s *UserServer) CreateUser(ctx context.Context, req *userv1.CreateUserReque
 		IsCafeOwner: req.IsCafeOwner,
 	}
 
-	result := database.DB.Create(&user)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create user: %v", result.Error)
+	err := s.repo.CreateUser(ctx, &user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return nil, status.Errorf(codes.AlreadyExists, "user with email %s already exists", req.Email)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create user: %v", err)
 	}
 
 	return &userv1.CreateUserResponse{
-		User: &userv1.User{
-			Id:          uint32(user.ID),
-			Name:        user.Name,
-			Email:       user.Email,
-			IsCafeOwner: user.IsCafeOwner,
-		},
+		User: userToProto(user),
 	}, nil
 }
 
 func (s *UserServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.GetUserResponse, error) {
-	var user models.User
-	result := database.DB.First(&user, req.Id)
-	if result.Error != nil {
+	user, err := s.repo.GetUser(ctx, uint(req.Id))
+	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "user not found")
 	}
 
 	return &userv1.GetUserResponse{
-		User: &userv1.User{
-			Id:          uint32(user.ID),
-			Name:        user.Name,
-			Email:       user.Email,
-			IsCafeOwner: user.IsCafeOwner,
-		},
+		User: userToProto(*user),
 	}, nil
 }
 
 func (s *UserServer) GetUsers(ctx context.Context, req *userv1.GetUsersRequest) (*userv1.GetUsersResponse, error) {
-	var users []models.User
-	result := database.DB.Find(&users)
-	if result.Error != nil {
-		return nil, status.Errorf(codes.Internal, "failed to fetch users: %v", result.Error)
+	ids := make([]uint, len(req.Ids))
+	for i, id := range req.Ids {
+		ids[i] = uint(id)
+	}
+
+	users, err := s.repo.ListUsers(ctx, ids)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fetch users: %v", err)
 	}
 
 	var protoUsers []*userv1.User
 	for _, user := range users {
-		protoUsers = append(protoUsers, &userv1.User{
-			Id:          uint32(user.ID),
-			Name:        user.Name,
-			Email:       user.Email,
-			IsCafeOwner: user.IsCafeOwner,
-		})
+		protoUsers = append(protoUsers, userToProto(user))
 	}
 
 	return &userv1.GetUsersResponse{
 		Users: protoUsers,
 	}, nil
 }
+
+func userToProto(user models.User) *userv1.User {
+	protoUser := &userv1.User{
+		Id:          uint32(user.ID),
+		Name:        user.Name,
+		Email:       user.Email,
+		IsCafeOwner: user.IsCafeOwner,
+	}
+	for _, cafe := range user.Cafes {
+		protoUser.ManagedCafeIds = append(protoUser.ManagedCafeIds, uint32(cafe.ID))
+	}
+	return protoUser
+}
@@ -5,38 +5,17 @@ import (
 	"testing"
 
 	"github.com/practical6/proto/user/v1"
-	"github.com/practical6/user-service/database"
-	"github.com/practical6/user-service/models"
+	"github.com/practical6/user-service/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
-func setupTestDB(t *testing.T) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
-	require.NoError(t, err)
-
-	err = db.AutoMigrate(&models.User{})
-	require.NoError(t, err)
-
-	return db
-}
-
-func teardownTestDB(t *testing.T, db *gorm.DB) {
-	sqlDB, err := db.DB()
-	require.NoError(t, err)
-	sqlDB.Close()
-}
-
 func TestCreateUser(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewUserServer()
+	server := NewUserServer(repository.NewMemoryUserRepository())
 
 	tests := []struct {
 		name        string
@@ -83,11 +62,9 @@ func TestCreateUser(t *testing.T) {
 }
 
 func TestGetUser(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewUserServer()
+	server := NewUserServer(repository.NewMemoryUserRepository())
 	ctx := context.Background()
 
 	// Create a test user
@@ -137,11 +114,9 @@ func TestGetUser(t *testing.T) {
 }
 
 func TestGetUsers(t *testing.T) {
-	db := setupTestDB(t)
-	defer teardownTestDB(t, db)
-	database.DB = db
+	t.Parallel()
 
-	server := NewUserServer()
+	server := NewUserServer(repository.NewMemoryUserRepository())
 	ctx := context.Background()
 
 	// Create test users
@@ -152,7 +127,7 @@ func TestGetUsers(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	_, err = server.CreateUser(ctx, &userv1.CreateUserRequest{
+	second, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
 		Name:        "User 2",
 		Email:       "user2@example.com",
 		IsCafeOwner: true,
@@ -162,4 +137,31 @@ func TestGetUsers(t *testing.T) {
 	resp, err := server.GetUsers(ctx, &userv1.GetUsersRequest{})
 	require.NoError(t, err)
 	assert.Len(t, resp.Users, 2)
+
+	resp, err = server.GetUsers(ctx, &userv1.GetUsersRequest{Ids: []uint32{second.User.Id}})
+	require.NoError(t, err)
+	require.Len(t, resp.Users, 1)
+	assert.Equal(t, "User 2", resp.Users[0].Name)
+}
+
+func TestCreateUser_DuplicateEmail(t *testing.T) {
+	t.Parallel()
+
+	server := NewUserServer(repository.NewMemoryUserRepository())
+	ctx := context.Background()
+
+	_, err := server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name:  "First",
+		Email: "same@example.com",
+	})
+	require.NoError(t, err)
+
+	_, err = server.CreateUser(ctx, &userv1.CreateUserRequest{
+		Name:  "Second",
+		Email: "same@example.com",
+	})
+	require.Error(t, err)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.AlreadyExists, st.Code())
 }
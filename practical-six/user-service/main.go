@@ -1,34 +1,160 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/practical6/pkg/config"
+	"github.com/practical6/pkg/discovery"
+	"github.com/practical6/pkg/healthcheck"
+	"github.com/practical6/pkg/logging"
+	"github.com/practical6/pkg/metrics"
+	"github.com/practical6/pkg/mtls"
+	"github.com/practical6/pkg/serve"
+	"github.com/practical6/pkg/tracing"
 	"github.com/practical6/proto/user/v1"
 	"github.com/practical6/user-service/database"
 	"github.com/practical6/user-service/grpc"
+	"github.com/practical6/user-service/repository"
+	"go.opentelemetry.io/otel"
 	grpcServer "google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// callers says which services may call which RPCs when mutual TLS is on.
+// The gateway serves the whole API; order-service only checks that the user
+// placing an order exists.
+var callers = mtls.Policy{
+	"/user.v1.UserService/":        {mtls.ID("api-gateway")},
+	"/user.v1.UserService/GetUser": {mtls.ID("api-gateway"), mtls.ID("order-service")},
+	"/grpc.health.v1.Health/":      {mtls.ID("api-gateway"), mtls.ID("order-service")},
+}
+
+type Config struct {
+	GRPCPort        int              `yaml:"grpc_port" env:"GRPC_PORT" flag:"grpc-port" usage:"port to serve gRPC on" required:"true"`
+	MetricsPort     int              `yaml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" usage:"admin port to serve /metrics and /loglevel on" required:"true"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to drain in-flight RPCs on shutdown"`
+	DB              config.Database  `yaml:"db"`
+	LogLevel        string           `yaml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error; PUT /loglevel on the admin port changes it at runtime"`
+	Tracing         tracing.Config   `yaml:"tracing"`
+	TLS             mtls.Config      `yaml:"tls"`
+	Discovery       discovery.Config `yaml:"discovery"`
+}
+
 func main() {
-	database.InitDB()
+	cfg := Config{
+		GRPCPort:        50051,
+		MetricsPort:     9091,
+		ShutdownTimeout: 15 * time.Second,
+		DB:              config.DefaultDatabase("userdb"),
+		LogLevel:        "info",
+		Tracing:         tracing.DefaultConfig(),
+		TLS:             mtls.DefaultConfig(),
+		Discovery:       discovery.DefaultConfig(),
+	}
+	logLevel := logging.Setup("user-service")
+	args := config.MustLoad("user-service", &cfg)
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		logging.Fatal("Invalid log level", "err", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := database.Migrate(context.Background(), database.Connect(cfg.DB), args[1:], os.Stdout); err != nil {
+			logging.Fatal("Migration failed", "err", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, "user-service", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "err", err)
+		}
+	}()
+	tracerProvider := otel.GetTracerProvider()
 
-	port := os.Getenv("GRPC_PORT")
-	if port == "" {
-		port = "50051"
+	creds, err := mtls.Load(cfg.TLS)
+	if err != nil {
+		logging.Fatal("Failed to load TLS certificates", "err", err)
+	}
+	go creds.Run(ctx)
+
+	serviceRegistry, err := discovery.New(cfg.Discovery)
+	if err != nil {
+		logging.Fatal("Failed to set up service discovery", "err", err)
+	}
+
+	db := database.InitDB(cfg.DB)
+	if err := db.Use(tracing.GORM(tracerProvider)); err != nil {
+		logging.Fatal("Failed to instrument database", "err", err)
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		logging.Fatal("Failed to get database handle", "err", err)
+	}
+	defer sqlDB.Close()
 
-	lis, err := net.Listen("tcp", ":"+port)
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		logging.Fatal("Failed to listen", "err", err)
+	}
+
+	healthServer := health.NewServer()
+	checker := healthcheck.NewChecker(healthServer, userv1.UserService_ServiceDesc.ServiceName)
+	checker.Add("database", healthcheck.DB(sqlDB))
+	go checker.Run(ctx)
+
+	// Registered only once listening, and withdrawn as soon as shutdown
+	// starts; Consul hides the instance while its health checks fail.
+	if serviceRegistry != nil {
+		instance, err := cfg.Discovery.Instance("user-service", cfg.GRPCPort)
+		if err != nil {
+			logging.Fatal("Failed to describe this instance", "err", err)
+		}
+		if err := discovery.Announce(ctx, serviceRegistry, instance, checker.Err); err != nil {
+			logging.Fatal("Failed to register with Consul", "err", err)
+		}
 	}
 
-	s := grpcServer.NewServer()
-	userv1.RegisterUserServiceServer(s, grpc.NewUserServer())
+	registry := metrics.NewRegistry()
+	recorder := metrics.NewRecorder(registry)
+	admin := http.NewServeMux()
+	admin.Handle("/metrics", metrics.Handler(registry))
+	admin.Handle("/loglevel", logging.LevelHandler(logLevel))
+	go func() {
+		if err := serve.HTTP(ctx, serve.AdminServer(cfg.MetricsPort, admin), cfg.ShutdownTimeout); err != nil {
+			slog.Error("Admin server failed", "err", err)
+		}
+	}()
+
+	s := grpcServer.NewServer(
+		grpcServer.Creds(creds.Server()),
+		tracing.ServerOption(tracerProvider),
+		grpcServer.ChainUnaryInterceptor(logging.UnaryServerInterceptor(), recorder.UnaryServerInterceptor(), creds.UnaryServerInterceptor(callers)),
+		grpcServer.ChainStreamInterceptor(logging.StreamServerInterceptor(), recorder.StreamServerInterceptor(), creds.StreamServerInterceptor(callers)),
+	)
+	userv1.RegisterUserServiceServer(s, grpc.NewUserServer(repository.NewGormUserRepository(db)))
+	healthpb.RegisterHealthServer(s, healthServer)
 
-	log.Printf("User service listening on port %s", port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	slog.Info("User service listening", "port", cfg.GRPCPort)
+	if err := serve.GRPC(ctx, s, lis, cfg.ShutdownTimeout); err != nil {
+		logging.Fatal("Failed to serve", "err", err)
 	}
+	slog.Info("User service stopped")
 }
@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// Cafe is a tenant. Menu items and orders in the other services carry its ID.
+type Cafe struct {
+	gorm.Model
+	Name     string `gorm:"not null"`
+	Location string
+	Owners   []User `gorm:"many2many:cafe_owners"`
+}
@@ -7,4 +7,5 @@ type User struct {
 	Name        string `gorm:"not null"`
 	Email       string `gorm:"unique;not null"`
 	IsCafeOwner bool   `gorm:"default:false"`
+	Cafes       []Cafe `gorm:"many2many:cafe_owners"`
 }
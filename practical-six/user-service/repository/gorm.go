@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/user-service/models"
+	"gorm.io/gorm"
+)
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+// NewGormUserRepository stores users and cafes in db. The connection should
+// be opened with TranslateError so unique violations map to ErrDuplicate.
+func NewGormUserRepository(db *gorm.DB) UserRepository {
+	return &gormUserRepository{db: db}
+}
+
+func (r *gormUserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	return translate(r.db.WithContext(ctx).Create(user).Error)
+}
+
+func (r *gormUserRepository) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Preload("Cafes").First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) ListUsers(ctx context.Context, ids []uint) ([]models.User, error) {
+	query := r.db.WithContext(ctx).Preload("Cafes")
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, translate(err)
+	}
+	return users, nil
+}
+
+func (r *gormUserRepository) CreateCafe(ctx context.Context, cafe *models.Cafe) error {
+	return translate(r.db.WithContext(ctx).Create(cafe).Error)
+}
+
+func (r *gormUserRepository) GetCafe(ctx context.Context, id uint) (*models.Cafe, error) {
+	var cafe models.Cafe
+	if err := r.db.WithContext(ctx).Preload("Owners").First(&cafe, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &cafe, nil
+}
+
+func (r *gormUserRepository) ListCafes(ctx context.Context, ownerID uint) ([]models.Cafe, error) {
+	db := r.db.WithContext(ctx)
+	query := db.Preload("Owners")
+	if ownerID != 0 {
+		query = query.Where("id IN (?)",
+			db.Table("cafe_owners").Select("cafe_id").Where("user_id = ?", ownerID))
+	}
+
+	var cafes []models.Cafe
+	if err := query.Find(&cafes).Error; err != nil {
+		return nil, translate(err)
+	}
+	return cafes, nil
+}
+
+func (r *gormUserRepository) AddCafeOwner(ctx context.Context, cafeID, userID uint) error {
+	db := r.db.WithContext(ctx)
+
+	var cafe models.Cafe
+	if err := db.First(&cafe, cafeID).Error; err != nil {
+		return translate(err)
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return translate(err)
+	}
+	return translate(db.Model(&cafe).Association("Owners").Append(&user))
+}
+
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	}
+	return err
+}
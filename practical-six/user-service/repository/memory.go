@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/practical6/user-service/models"
+)
+
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[uint]models.User
+	cafes  map[uint]models.Cafe
+	owners map[uint]map[uint]bool // cafe ID -> owner user IDs
+
+	nextUserID uint
+	nextCafeID uint
+}
+
+// NewMemoryUserRepository returns an empty repository that keeps everything
+// in memory. It is safe for concurrent use.
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		users:  make(map[uint]models.User),
+		cafes:  make(map[uint]models.Cafe),
+		owners: make(map[uint]map[uint]bool),
+	}
+}
+
+func (r *memoryUserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+
+	r.nextUserID++
+	user.ID = r.nextUserID
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	stored := *user
+	stored.Cafes = nil
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *memoryUserRepository) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user.Cafes = r.cafesOwnedBy(id)
+	return &user, nil
+}
+
+func (r *memoryUserRepository) ListUsers(ctx context.Context, ids []uint) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if len(ids) > 0 && !slices.Contains(ids, user.ID) {
+			continue
+		}
+		user.Cafes = r.cafesOwnedBy(user.ID)
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r *memoryUserRepository) CreateCafe(ctx context.Context, cafe *models.Cafe) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, owner := range cafe.Owners {
+		if _, ok := r.users[owner.ID]; !ok {
+			return ErrNotFound
+		}
+	}
+
+	r.nextCafeID++
+	cafe.ID = r.nextCafeID
+	cafe.CreatedAt = time.Now()
+	cafe.UpdatedAt = cafe.CreatedAt
+
+	stored := *cafe
+	stored.Owners = nil
+	r.cafes[cafe.ID] = stored
+	r.owners[cafe.ID] = make(map[uint]bool)
+	for _, owner := range cafe.Owners {
+		r.owners[cafe.ID][owner.ID] = true
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) GetCafe(ctx context.Context, id uint) (*models.Cafe, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cafe, ok := r.cafes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cafe.Owners = r.ownersOf(id)
+	return &cafe, nil
+}
+
+func (r *memoryUserRepository) ListCafes(ctx context.Context, ownerID uint) ([]models.Cafe, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var cafes []models.Cafe
+	for id, cafe := range r.cafes {
+		if ownerID != 0 && !r.owners[id][ownerID] {
+			continue
+		}
+		cafe.Owners = r.ownersOf(id)
+		cafes = append(cafes, cafe)
+	}
+	sort.Slice(cafes, func(i, j int) bool { return cafes[i].ID < cafes[j].ID })
+	return cafes, nil
+}
+
+func (r *memoryUserRepository) AddCafeOwner(ctx context.Context, cafeID, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cafes[cafeID]; !ok {
+		return ErrNotFound
+	}
+	if _, ok := r.users[userID]; !ok {
+		return ErrNotFound
+	}
+	r.owners[cafeID][userID] = true
+	return nil
+}
+
+// cafesOwnedBy and ownersOf must be called with r.mu held.
+func (r *memoryUserRepository) cafesOwnedBy(userID uint) []models.Cafe {
+	var cafes []models.Cafe
+	for id, owners := range r.owners {
+		if owners[userID] {
+			cafes = append(cafes, r.cafes[id])
+		}
+	}
+	sort.Slice(cafes, func(i, j int) bool { return cafes[i].ID < cafes[j].ID })
+	return cafes
+}
+
+func (r *memoryUserRepository) ownersOf(cafeID uint) []models.User {
+	var owners []models.User
+	for userID := range r.owners[cafeID] {
+		owners = append(owners, r.users[userID])
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].ID < owners[j].ID })
+	return owners
+}
@@ -0,0 +1,34 @@
+// Package repository defines the storage interface used by the user service,
+// with a GORM implementation for production and an in-memory one for tests.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/practical6/user-service/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a unique field (such as email) is taken.
+	ErrDuplicate = errors.New("duplicate record")
+)
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	// GetUser returns the user with the cafes they manage.
+	GetUser(ctx context.Context, id uint) (*models.User, error)
+	// ListUsers returns all users, or only those whose ID is in ids if it is
+	// non-empty.
+	ListUsers(ctx context.Context, ids []uint) ([]models.User, error)
+
+	CreateCafe(ctx context.Context, cafe *models.Cafe) error
+	// GetCafe returns the cafe with its owners.
+	GetCafe(ctx context.Context, id uint) (*models.Cafe, error)
+	// ListCafes returns all cafes, or only those managed by ownerID if it is
+	// non-zero.
+	ListCafes(ctx context.Context, ownerID uint) ([]models.Cafe, error)
+	AddCafeOwner(ctx context.Context, cafeID, userID uint) error
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/practical6/user-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newGormRepository opens a private in-memory SQLite database so tests can
+// run in parallel without sharing tables.
+func newGormRepository(t *testing.T) UserRepository {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
+	require.NoError(t, err)
+
+	err = db.AutoMigrate(&models.User{}, &models.Cafe{})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		sqlDB.Close()
+	})
+	return NewGormUserRepository(db)
+}
+
+var implementations = []struct {
+	name string
+	new  func(t *testing.T) UserRepository
+}{
+	{"gorm", newGormRepository},
+	{"memory", func(t *testing.T) UserRepository { return NewMemoryUserRepository() }},
+}
+
+func TestUserRepository(t *testing.T) {
+	t.Parallel()
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := impl.new(t)
+			ctx := context.Background()
+
+			user := models.User{Name: "Alice", Email: "alice@example.com"}
+			require.NoError(t, repo.CreateUser(ctx, &user))
+			assert.NotZero(t, user.ID)
+
+			err := repo.CreateUser(ctx, &models.User{Name: "Other Alice", Email: "alice@example.com"})
+			assert.ErrorIs(t, err, ErrDuplicate)
+
+			found, err := repo.GetUser(ctx, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "Alice", found.Name)
+
+			_, err = repo.GetUser(ctx, 9999)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, repo.CreateUser(ctx, &models.User{Name: "Bob", Email: "bob@example.com"}))
+			users, err := repo.ListUsers(ctx, nil)
+			require.NoError(t, err)
+			require.Len(t, users, 2)
+			assert.Equal(t, "Alice", users[0].Name)
+
+			users, err = repo.ListUsers(ctx, []uint{users[1].ID, 9999})
+			require.NoError(t, err)
+			require.Len(t, users, 1)
+			assert.Equal(t, "Bob", users[0].Name)
+		})
+	}
+}
+
+func TestCafeRepository(t *testing.T) {
+	t.Parallel()
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := impl.new(t)
+			ctx := context.Background()
+
+			owner := models.User{Name: "Owner", Email: "owner@cafe.com", IsCafeOwner: true}
+			require.NoError(t, repo.CreateUser(ctx, &owner))
+			other := models.User{Name: "Other", Email: "other@cafe.com", IsCafeOwner: true}
+			require.NoError(t, repo.CreateUser(ctx, &other))
+
+			library := models.Cafe{Name: "Library Cafe", Owners: []models.User{owner}}
+			require.NoError(t, repo.CreateCafe(ctx, &library))
+			assert.NotZero(t, library.ID)
+
+			gym := models.Cafe{Name: "Gym Cafe"}
+			require.NoError(t, repo.CreateCafe(ctx, &gym))
+			require.NoError(t, repo.AddCafeOwner(ctx, gym.ID, other.ID))
+
+			assert.ErrorIs(t, repo.AddCafeOwner(ctx, 9999, other.ID), ErrNotFound)
+			assert.ErrorIs(t, repo.AddCafeOwner(ctx, gym.ID, 9999), ErrNotFound)
+
+			found, err := repo.GetCafe(ctx, library.ID)
+			require.NoError(t, err)
+			require.Len(t, found.Owners, 1)
+			assert.Equal(t, owner.ID, found.Owners[0].ID)
+
+			_, err = repo.GetCafe(ctx, 9999)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			owned, err := repo.ListCafes(ctx, other.ID)
+			require.NoError(t, err)
+			require.Len(t, owned, 1)
+			assert.Equal(t, "Gym Cafe", owned[0].Name)
+
+			all, err := repo.ListCafes(ctx, 0)
+			require.NoError(t, err)
+			assert.Len(t, all, 2)
+
+			withCafes, err := repo.GetUser(ctx, owner.ID)
+			require.NoError(t, err)
+			require.Len(t, withCafes.Cafes, 1)
+			assert.Equal(t, library.ID, withCafes.Cafes[0].ID)
+		})
+	}
+}
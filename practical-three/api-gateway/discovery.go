@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ServiceDiscovery keeps a pool of connections to the healthy instances of
+// each upstream service. Consul blocking queries keep the pools current:
+// instances that pass their health checks are dialled, and instances that
+// fail them or deregister are closed and dropped.
+type ServiceDiscovery struct {
+	consul   *consulapi.Client
+	services []string
+
+	mu    sync.RWMutex
+	pools map[string]*pool
+}
+
+// evictGrace is how long the connection to an evicted instance stays open.
+const evictGrace = 10 * time.Second
+
+// pool holds the connections to one service's healthy instances.
+type pool struct {
+	conns map[string]*instanceConn // by Consul service ID
+	ids   []string                 // sorted, for round-robin
+	next  int
+}
+
+type instanceConn struct {
+	addr string
+	conn *grpc.ClientConn
+}
+
+func NewServiceDiscovery(consul *consulapi.Client, services ...string) *ServiceDiscovery {
+	pools := make(map[string]*pool, len(services))
+	for _, name := range services {
+		pools[name] = &pool{conns: make(map[string]*instanceConn)}
+	}
+	return &ServiceDiscovery{consul: consul, services: services, pools: pools}
+}
+
+// Run watches every service until ctx is done, then closes the pools.
+func (sd *ServiceDiscovery) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, name := range sd.services {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			sd.watch(ctx, name)
+		}(name)
+	}
+	wg.Wait()
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	for _, p := range sd.pools {
+		for id, ic := range p.conns {
+			ic.conn.Close()
+			delete(p.conns, id)
+		}
+		p.ids = nil
+	}
+}
+
+// watch follows the passing instances of name with blocking queries. When
+// Consul cannot be reached the pool is left as it is and the query retried
+// with backoff.
+func (sd *ServiceDiscovery) watch(ctx context.Context, name string) {
+	var index uint64
+	retry := time.Second
+	for {
+		q := (&consulapi.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+		entries, meta, err := sd.consul.Health().Service(name, "", true, q)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("api-gateway: consul health query for %s failed, retrying in %v: %v", name, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			if retry *= 2; retry > 30*time.Second {
+				retry = 30 * time.Second
+			}
+			continue
+		}
+		retry = time.Second
+
+		// An index that goes backwards means Consul's state was reset; start
+		// over rather than wait for an index that will not come. Starting
+		// over is from 1, as a wait index of 0 does not block at all.
+		if meta.LastIndex < index || meta.LastIndex == 0 {
+			index = 1
+		} else {
+			index = meta.LastIndex
+		}
+
+		healthy := make(map[string]string, len(entries))
+		for _, e := range entries {
+			host := e.Service.Address
+			if host == "" {
+				host = e.Node.Address
+			}
+			healthy[e.Service.ID] = fmt.Sprintf("%s:%d", host, e.Service.Port)
+		}
+		sd.update(name, healthy)
+	}
+}
+
+// update makes name's pool hold exactly the instances in healthy, which maps
+// Consul service IDs to addresses.
+func (sd *ServiceDiscovery) update(name string, healthy map[string]string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	p := sd.pools[name]
+
+	for id, ic := range p.conns {
+		if addr, ok := healthy[id]; !ok || addr != ic.addr {
+			log.Printf("api-gateway: evicting %s instance %s at %s", name, id, ic.addr)
+			// Calls already handed the connection get a while to finish.
+			time.AfterFunc(evictGrace, func() { ic.conn.Close() })
+			delete(p.conns, id)
+		}
+	}
+	for id, addr := range healthy {
+		if _, ok := p.conns[id]; ok {
+			continue
+		}
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Printf("api-gateway: cannot dial %s instance %s at %s: %v", name, id, addr, err)
+			continue
+		}
+		conn.Connect()
+		log.Printf("api-gateway: added %s instance %s at %s", name, id, addr)
+		p.conns[id] = &instanceConn{addr: addr, conn: conn}
+	}
+
+	p.ids = p.ids[:0]
+	for id := range p.conns {
+		p.ids = append(p.ids, id)
+	}
+	sort.Strings(p.ids)
+}
+
+// getServiceConnection returns a connection to one of name's healthy
+// instances, taking them in turn. Instances whose connection has failed are
+// passed over until it recovers, even before Consul marks them critical.
+func (sd *ServiceDiscovery) getServiceConnection(name string) (*grpc.ClientConn, error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	p, ok := sd.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %s", name)
+	}
+	if len(p.ids) == 0 {
+		return nil, fmt.Errorf("no healthy instances of %s", name)
+	}
+	for range p.ids {
+		ic := p.conns[p.ids[p.next%len(p.ids)]]
+		p.next++
+		if ic.conn.GetState() != connectivity.TransientFailure {
+			return ic.conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no reachable instances of %s", name)
+}
+
+// Ready reports an error until every service has at least one healthy
+// instance.
+func (sd *ServiceDiscovery) Ready() error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	var missing []string
+	for _, name := range sd.services {
+		if len(sd.pools[name].ids) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no healthy instances of %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
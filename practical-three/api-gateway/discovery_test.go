@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// fakeConsul answers health queries for one service from a list of passing
+// instances that the test changes. Like Consul, a query with the current
+// index as its wait index blocks until the list changes.
+type fakeConsul struct {
+	t       *testing.T
+	service string
+
+	mu      sync.Mutex
+	index   uint64
+	entries []*consulapi.ServiceEntry
+	changed chan struct{}
+	waits   []uint64 // the wait index of every query
+}
+
+func newFakeConsul(t *testing.T, service string) (*fakeConsul, *consulapi.Client) {
+	f := &fakeConsul{t: t, service: service, index: 1, changed: make(chan struct{})}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client, err := consulapi.NewClient(&consulapi.Config{Address: strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/health/service/"+f.service || r.URL.Query().Get("passing") == "" {
+		f.t.Errorf("unexpected query %s", r.URL)
+		http.NotFound(w, r)
+		return
+	}
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	f.mu.Lock()
+	f.waits = append(f.waits, wait)
+	for wait != 0 && wait == f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+	}
+	index, entries := f.index, f.entries
+	f.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// set makes addrs, by service ID, the passing instances at Consul index
+// index, and wakes any blocked queries.
+func (f *fakeConsul) set(index uint64, addrs map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index = index
+	f.entries = nil
+	for id, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		f.entries = append(f.entries, &consulapi.ServiceEntry{
+			Node:    &consulapi.Node{Address: host},
+			Service: &consulapi.AgentService{ID: id, Service: f.service, Port: p},
+		})
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) waitIndexes() []uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.waits)
+}
+
+// liveAddr starts a gRPC server and returns its address.
+func liveAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+// instances returns the service IDs in name's pool, and their addresses.
+func (sd *ServiceDiscovery) instances(name string) map[string]string {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	addrs := make(map[string]string)
+	for _, id := range sd.pools[name].ids {
+		addrs[id] = sd.pools[name].conns[id].addr
+	}
+	return addrs
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchFollowsConsul(t *testing.T) {
+	consul, client := newFakeConsul(t, "users-service")
+	a, b := liveAddr(t), liveAddr(t)
+	consul.set(10, map[string]string{"users-1": a})
+
+	sd := NewServiceDiscovery(client, "users-service")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sd.Run(ctx)
+		close(done)
+	}()
+
+	eventually(t, "the first instance", func() bool {
+		return maps.Equal(sd.instances("users-service"), map[string]string{"users-1": a})
+	})
+	if err := sd.Ready(); err != nil {
+		t.Fatalf("Ready() = %v with an instance up", err)
+	}
+
+	consul.set(11, map[string]string{"users-1": a, "users-2": b})
+	eventually(t, "the second instance", func() bool {
+		return maps.Equal(sd.instances("users-service"), map[string]string{"users-1": a, "users-2": b})
+	})
+
+	// users-1 fails its health check, and users-2 comes back elsewhere.
+	c := liveAddr(t)
+	consul.set(12, map[string]string{"users-2": c})
+	eventually(t, "users-1 to be evicted and users-2 to move", func() bool {
+		return maps.Equal(sd.instances("users-service"), map[string]string{"users-2": c})
+	})
+
+	consul.set(13, nil)
+	eventually(t, "the pool to empty", func() bool { return len(sd.instances("users-service")) == 0 })
+	if err := sd.Ready(); err == nil {
+		t.Error("Ready() = nil with no instances")
+	}
+
+	// Each query waits on the index the one before returned.
+	eventually(t, "a query waiting on index 13", func() bool { return slices.Contains(consul.waitIndexes(), 13) })
+	if waits, want := consul.waitIndexes(), []uint64{0, 10, 11, 12, 13}; !slices.Equal(waits, want) {
+		t.Errorf("wait indexes = %v, want %v", waits, want)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+}
+
+func TestWatchStartsOverWhenIndexGoesBack(t *testing.T) {
+	consul, client := newFakeConsul(t, "users-service")
+	a, b := liveAddr(t), liveAddr(t)
+	consul.set(50, map[string]string{"users-1": a})
+
+	sd := NewServiceDiscovery(client, "users-service")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sd.Run(ctx)
+
+	eventually(t, "the first instance", func() bool { return len(sd.instances("users-service")) == 1 })
+
+	// Consul lost its state: the index starts again lower down.
+	consul.set(3, map[string]string{"users-2": b})
+	eventually(t, "the instance after the reset", func() bool {
+		return maps.Equal(sd.instances("users-service"), map[string]string{"users-2": b})
+	})
+
+	// A wait index of 0 would not block, so the watch would spin; it starts
+	// over from 1 instead and blocks on 3 from then on.
+	eventually(t, "a query waiting on index 3", func() bool { return slices.Contains(consul.waitIndexes(), 3) })
+	for i, wait := range consul.waitIndexes() {
+		if i > 0 && wait == 0 {
+			t.Errorf("query %d did not block: wait indexes %v", i, consul.waitIndexes())
+		}
+	}
+}
+
+func TestGetServiceConnectionSkipsFailedInstances(t *testing.T) {
+	sd := NewServiceDiscovery(nil, "users-service")
+	defer sd.update("users-service", nil)
+	live1, live2, dead := liveAddr(t), liveAddr(t), deadAddr(t)
+	sd.update("users-service", map[string]string{"users-1": live1, "users-2": dead, "users-3": live2})
+
+	sd.mu.RLock()
+	deadConn := sd.pools["users-service"].conns["users-2"].conn
+	sd.mu.RUnlock()
+	eventually(t, "the dead instance to fail", func() bool {
+		return deadConn.GetState() == connectivity.TransientFailure
+	})
+
+	got := make(map[string]int)
+	for range 6 {
+		conn, err := sd.getServiceConnection("users-service")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[conn.Target()]++
+	}
+	if want := map[string]int{live1: 3, live2: 3}; !maps.Equal(got, want) {
+		t.Errorf("connections by target = %v, want %v", got, want)
+	}
+}
+
+func TestGetServiceConnectionErrors(t *testing.T) {
+	sd := NewServiceDiscovery(nil, "users-service", "products-service")
+	defer sd.update("products-service", nil)
+	dead := deadAddr(t)
+	sd.update("products-service", map[string]string{"products-1": dead})
+
+	sd.mu.RLock()
+	deadConn := sd.pools["products-service"].conns["products-1"].conn
+	sd.mu.RUnlock()
+	eventually(t, "the dead instance to fail", func() bool {
+		return deadConn.GetState() == connectivity.TransientFailure
+	})
+
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"orders-service", "unknown service orders-service"},
+		{"users-service", "no healthy instances of users-service"},
+		{"products-service", "no reachable instances of products-service"},
+	}
+	for _, tt := range tests {
+		if _, err := sd.getServiceConnection(tt.service); err == nil || err.Error() != tt.want {
+			t.Errorf("getServiceConnection(%q) error = %v, want %q", tt.service, err, tt.want)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	sd := NewServiceDiscovery(nil, "users-service", "products-service")
+	defer sd.update("users-service", nil)
+	defer sd.update("products-service", nil)
+
+	if err := sd.Ready(); err == nil || err.Error() != "no healthy instances of users-service, products-service" {
+		t.Errorf("Ready() = %v with no instances", err)
+	}
+
+	sd.update("users-service", map[string]string{"users-1": liveAddr(t)})
+	if err := sd.Ready(); err == nil || err.Error() != "no healthy instances of products-service" {
+		t.Errorf("Ready() = %v with no products-service instances", err)
+	}
+
+	sd.update("products-service", map[string]string{"products-1": liveAddr(t)})
+	if err := sd.Ready(); err != nil {
+		t.Errorf("Ready() = %v with every service up", err)
+	}
+
+	sd.update("users-service", nil)
+	if err := sd.Ready(); err == nil || err.Error() != "no healthy instances of users-service" {
+		t.Errorf("Ready() = %v after users-service went away", err)
+	}
+}
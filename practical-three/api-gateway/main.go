@@ -8,22 +8,13 @@ import (
 	"net/http"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/gorilla/mux"
 	consulapi "github.com/hashicorp/consul/api"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	pb "practicalthree/proto/gen"
 )
 
-type ServiceDiscovery struct {
-	consul *consulapi.Client
-	mu     sync.RWMutex
-	conns  map[string]*grpc.ClientConn
-}
-
 var sd *ServiceDiscovery
 
 func main() {
@@ -36,12 +27,11 @@ func main() {
 		log.Fatalf("api-gateway: consul client failed: %v", err)
 	}
 
-	sd = &ServiceDiscovery{consul: consul, conns: make(map[string]*grpc.ClientConn)}
-
-	// Wait for services to register with Consul
-	time.Sleep(8 * time.Second)
+	sd = NewServiceDiscovery(consul, "users-service", "products-service")
+	go sd.Run(context.Background())
 
 	r := mux.NewRouter()
+	r.HandleFunc("/ready", readyHandler).Methods("GET")
 	r.HandleFunc("/api/users", createUserHandler).Methods("POST")
 	r.HandleFunc("/api/users/{id}", getUserHandler).Methods("GET")
 	r.HandleFunc("/api/products", createProductHandler).Methods("POST")
@@ -52,39 +42,6 @@ func main() {
 	http.ListenAndServe(":8080", r)
 }
 
-func (sd *ServiceDiscovery) getServiceConnection(name string) (*grpc.ClientConn, error) {
-	sd.mu.Lock()
-	defer sd.mu.Unlock()
-
-	if c, ok := sd.conns[name]; ok {
-		return c, nil
-	}
-
-	// Try to get from Consul catalog
-	cat, _, err := sd.consul.Catalog().Service(name, "", nil)
-	if err != nil {
-		return nil, fmt.Errorf("consul catalog query failed: %v", err)
-	}
-	if len(cat) == 0 {
-		return nil, fmt.Errorf("no instances of %s found in consul", name)
-	}
-
-	svc := cat[0]
-	addr := fmt.Sprintf("%s:%d", svc.ServiceAddress, svc.ServicePort)
-	if svc.ServiceAddress == "" {
-		addr = fmt.Sprintf("%s:%d", svc.Address, svc.ServicePort)
-	}
-
-	log.Printf("api-gateway: connecting to %s at %s", name, addr)
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil, fmt.Errorf("grpc dial failed: %v", err)
-	}
-
-	sd.conns[name] = conn
-	return conn, nil
-}
-
 func getUsersClient() (pb.UserServiceClient, error) {
 	conn, err := sd.getServiceConnection("users-service")
 	if err != nil {
@@ -102,6 +59,14 @@ func getProductsClient() (pb.ProductServiceClient, error) {
 }
 
 // Handlers
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := sd.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
 func createUserHandler(w http.ResponseWriter, r *http.Request) {
 	client, err := getUsersClient()
 	if err != nil {
@@ -10,6 +10,8 @@ import (
 
 	consulapi "github.com/hashicorp/consul/api"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
@@ -74,6 +76,8 @@ func main() {
 
 	s := grpc.NewServer()
 	pb.RegisterUserServiceServer(s, &server{db: db})
+	// Consul checks this to decide whether the gateway may route here.
+	healthpb.RegisterHealthServer(s, health.NewServer())
 
 	if err := registerServiceWithConsul(); err != nil {
 		log.Printf("users-service: consul registration failed: %v", err)
@@ -107,6 +111,12 @@ func registerServiceWithConsul() error {
 		Name:    serviceName,
 		Port:    servicePort,
 		Address: serviceAddr,
+		Check: &consulapi.AgentServiceCheck{
+			GRPC:                           fmt.Sprintf("%s:%d", serviceAddr, servicePort),
+			Interval:                       "10s",
+			Timeout:                        "2s",
+			DeregisterCriticalServiceAfter: "1m",
+		},
 	}
 	return consul.Agent().ServiceRegister(reg)
 }